@@ -13,15 +13,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wyp0596/go2short/internal/alert"
 	"github.com/wyp0596/go2short/internal/cache"
 	"github.com/wyp0596/go2short/internal/config"
 	"github.com/wyp0596/go2short/internal/events"
+	"github.com/wyp0596/go2short/internal/geo"
 	"github.com/wyp0596/go2short/internal/handler"
 	"github.com/wyp0596/go2short/internal/link"
 	"github.com/wyp0596/go2short/internal/logger"
 	_ "github.com/wyp0596/go2short/internal/metrics" // register metrics
 	"github.com/wyp0596/go2short/internal/middleware"
+	"github.com/wyp0596/go2short/internal/partition"
 	"github.com/wyp0596/go2short/internal/redirect"
+	"github.com/wyp0596/go2short/internal/rollup"
 	"github.com/wyp0596/go2short/internal/store"
 	"github.com/wyp0596/go2short/web"
 )
@@ -29,6 +33,15 @@ import (
 func main() {
 	_ = godotenv.Load() // .env is optional
 	cfg := config.Load()
+	if _, err := store.ParseInsertMode(cfg.ClickInsertMode); err != nil {
+		logger.Error("invalid CLICK_INSERT_MODE", logger.Err(err))
+		os.Exit(1)
+	}
+	detach, err := store.ParseRetentionMode(cfg.ClickRetentionMode)
+	if err != nil {
+		logger.Error("invalid CLICK_RETENTION_MODE", logger.Err(err))
+		os.Exit(1)
+	}
 
 	// Initialize cache
 	c, err := cache.New(cfg)
@@ -46,41 +59,117 @@ func main() {
 	}
 	defer s.Close()
 
+	// Optional in-process tier in front of Redis for hot codes
+	var redirectCache redirect.Cacher = c
+	if cfg.LocalCacheSize > 0 {
+		local := cache.NewLocal(c, cfg.LocalCacheSize, cfg.LocalCacheTTL)
+		c.OnInvalidate(local.Remove)
+		redirectCache = local
+	}
+
 	// Initialize services
-	redirectService := redirect.NewService(c, s, cfg.CodeLength)
+	redirectService := redirect.NewService(redirectCache, s, cfg.CodeLength, cfg.RedirectStatusCode)
 	linkService := link.NewService(c, s, cfg.CodeLength)
-	producer := events.NewProducer(c.Client(), cfg.StreamName)
-	redirectHandler := handler.NewRedirectHandler(redirectService, producer)
+	ipMode, err := events.ParsePrivacyMode(cfg.PrivacyIPMode, true)
+	if err != nil {
+		logger.Error("invalid PRIVACY_IP_MODE", logger.Err(err))
+		os.Exit(1)
+	}
+	uaMode, err := events.ParsePrivacyMode(cfg.PrivacyUAMode, false)
+	if err != nil {
+		logger.Error("invalid PRIVACY_UA_MODE", logger.Err(err))
+		os.Exit(1)
+	}
+	if _, err := time.LoadLocation(cfg.StatsTimezone); err != nil || cfg.StatsTimezone == "Local" {
+		logger.Error("invalid STATS_TIMEZONE", logger.Extra("timezone", cfg.StatsTimezone))
+		os.Exit(1)
+	}
+	var clickIDs *events.ClickIDs
+	if cfg.ClickIDParam != "" {
+		if len(cfg.ClickIDSecret) < 32 {
+			logger.Error("CLICK_ID_SECRET must be at least 32 characters with CLICK_ID_PARAM set")
+			os.Exit(1)
+		}
+		clickIDs = events.NewClickIDs(cfg.ClickIDSecret)
+	}
+	privacy := events.NewPrivacy(ipMode, uaMode, cfg.PrivacyHonorDNT, events.NewRedisSalts(c.Client(), cfg.RedisKeyPrefix, c.Breaker()))
+	geoResolver, err := geo.Open(cfg.GeoIPDBPath)
+	if err != nil {
+		logger.Error("failed to open GeoIP database", logger.Err(err))
+		os.Exit(1)
+	}
+	defer geoResolver.Close()
+	producer := events.NewProducer(c.Client(), cfg.StreamName, int64(cfg.StreamMaxLen), c.Breaker(), privacy, geoResolver, cfg.ClickBufferSize)
+	visitors := cache.NewVisitors(c.Client(), cfg.RedisKeyPrefix, cfg.UniqueVisitorTTL)
+	redirectHandler := handler.NewRedirectHandler(redirectService, producer, privacy, cfg.ClickIDParam, clickIDs)
 	linkHandler := handler.NewLinkHandler(linkService, cfg.BaseURL)
 
 	// Initialize admin
 	authMiddleware := middleware.NewAuthMiddleware(c.Client(), cfg.RedisKeyPrefix)
 	apiTokenMiddleware := middleware.NewAPITokenMiddleware(s)
-	adminHandler := handler.NewAdminHandler(s, c, authMiddleware, cfg, linkService)
+	adminHandler := handler.NewAdminHandler(s, c, visitors, authMiddleware, cfg, linkService)
 	authHandler := handler.NewAuthHandler(cfg, s, authMiddleware)
+	conversionHandler := handler.NewConversionHandler(s, clickIDs)
+	alertHandler := handler.NewAlertHandler(s)
 
 	// Initialize rate limiter (60 requests per minute for link creation)
 	rateLimiter := middleware.NewRateLimiter(c.Client(), cfg.RedisKeyPrefix, 60, time.Minute)
+	// Separate counters for the public conversion pixel
+	pixelLimiter := middleware.NewRateLimiter(c.Client(), cfg.RedisKeyPrefix+":pixel", 60, time.Minute)
 
-	// Start click event consumer
-	consumer := events.NewConsumer(
-		c.Client(),
-		s,
-		cfg.StreamName,
-		cfg.StreamGroup,
-		"worker-1",
-		cfg.WorkerBatchSize,
-		cfg.WorkerFlushInterval,
-	)
+	// Click event consumer, unless workers run standalone (cmd/worker)
+	deadLetters := events.NewDeadLetters(c.Client(), cfg.DeadLetterStream, cfg.StreamName, int64(cfg.StreamMaxLen))
+	deadLetterHandler := handler.NewDeadLetterHandler(deadLetters)
+	liveFeed := events.NewFeed(c.Client(), cfg.StreamName, cfg.LiveFeedMaxConns)
+	liveHandler := handler.NewLiveHandler(liveFeed, s)
+	var consumer *events.Consumer
+	if cfg.EmbeddedWorker {
+		consumer = events.NewConsumer(
+			c.Client(),
+			s,
+			deadLetters,
+			visitors,
+			geoResolver,
+			alert.NewEvaluator(s, cfg.AlertEvalInterval),
+			cfg.StreamName,
+			cfg.StreamGroup,
+			events.ConsumerName(cfg.WorkerConsumerName),
+			cfg.WorkerBatchSize,
+			cfg.WorkerFlushInterval,
+			events.ReclaimConfig{
+				Interval:      cfg.WorkerReclaimInterval,
+				MinIdle:       cfg.WorkerReclaimMinIdle,
+				MaxDeliveries: int64(cfg.WorkerMaxDeliveries),
+			},
+		)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := consumer.Start(ctx); err != nil {
-		logger.Error("failed to start consumer", logger.Err(err))
+	// Receive cache invalidations published by other instances
+	if err := c.ListenInvalidations(ctx); err != nil {
+		logger.Error("failed to subscribe to cache invalidations", logger.Err(err))
 		os.Exit(1)
 	}
 
+	if consumer != nil {
+		if err := consumer.Start(ctx); err != nil {
+			logger.Error("failed to start consumer", logger.Err(err))
+			os.Exit(1)
+		}
+		events.NewLagSampler(c.Client(), cfg.StreamName, cfg.StreamGroup, cfg.StreamLagInterval).Start(ctx)
+		rollup.NewJob(s, cfg.RollupInterval, cfg.RollupMaxHours).Start(ctx)
+		partition.NewJob(s, cfg.PartitionInterval, store.PartitionPolicy{
+			Ahead:           cfg.PartitionsAhead,
+			RetentionMonths: cfg.ClickRetentionMonths,
+			Detach:          detach,
+		}).Start(ctx)
+		alert.NewDispatcher(s, cfg.WebhookInterval).Start(ctx)
+		logger.Info("embedded consumer started", logger.Extra("consumer", consumer.Name()))
+	}
+	producer.Start(ctx)
+
 	// Setup router
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
@@ -100,7 +189,17 @@ func main() {
 	// Routes
 	r.GET("/", serveIndex)
 	r.GET("/docs", serveIndex)
+	breaker := c.Breaker()
 	r.GET("/health", func(c *gin.Context) {
+		// Still 200 when degraded: redirects keep working from Postgres
+		if breaker.Degraded() {
+			c.JSON(http.StatusOK, gin.H{
+				"status":          "degraded",
+				"redis":           "unavailable",
+				"buffered_clicks": producer.Buffered(),
+			})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
@@ -111,6 +210,11 @@ func main() {
 	api.POST("/links/batch", apiTokenMiddleware.RequireAPIToken(), rateLimiter.Limit(), linkHandler.BatchCreate)
 	api.GET("/links/:code/preview", apiTokenMiddleware.RequireAPIToken(), linkHandler.Preview)
 
+	// Conversions: server-to-server postback (API token) and public pixel
+	api.POST("/conversions", apiTokenMiddleware.RequireAPIToken(), conversionHandler.Postback)
+	api.GET("/conversions", apiTokenMiddleware.RequireAPIToken(), conversionHandler.Postback)
+	api.GET("/conversions/pixel.gif", pixelLimiter.Limit(), conversionHandler.Pixel)
+
 	// User auth routes (public)
 	auth := api.Group("/auth")
 	auth.POST("/register", authHandler.Register)
@@ -134,13 +238,39 @@ func main() {
 	adminAuth.DELETE("/links/:code", adminHandler.DeleteLink)
 	adminAuth.PATCH("/links/:code/disable", adminHandler.SetLinkDisabled)
 	adminAuth.GET("/links/:code/stats", adminHandler.GetLinkStats)
+	adminAuth.GET("/links/:code/heatmap", adminHandler.GetLinkClickHeatmap)
+	adminAuth.GET("/links/:code/export/clicks", adminHandler.ExportLinkClicks)
+	adminAuth.GET("/links/:code/export/stats", adminHandler.ExportLinkStats)
 	adminAuth.GET("/stats/overview", adminHandler.GetOverviewStats)
 	adminAuth.GET("/stats/top-links", adminHandler.GetTopLinks)
 	adminAuth.GET("/stats/trend", adminHandler.GetClickTrend)
+	adminAuth.GET("/stats/heatmap", adminHandler.GetClickHeatmap)
 	adminAuth.GET("/stats/devices", adminHandler.GetDeviceStats)
+	adminAuth.GET("/stats/geo", adminHandler.GetGeoStats)
+	adminAuth.GET("/stats/referrers", adminHandler.GetReferrerStats)
+	adminAuth.GET("/stats/campaigns", adminHandler.GetCampaignStats)
+	adminAuth.POST("/stats/query", adminHandler.QueryStats)
+	adminAuth.GET("/export/clicks", adminHandler.ExportClicks)
+	adminAuth.GET("/export/stats", adminHandler.ExportStats)
+	adminAuth.GET("/live", liveHandler.Stream)
+	adminAuth.GET("/preferences", adminHandler.GetPreferences)
+	adminAuth.PUT("/preferences", adminHandler.UpdatePreferences)
 	adminAuth.POST("/tokens", adminHandler.CreateAPIToken)
 	adminAuth.GET("/tokens", adminHandler.ListAPITokens)
 	adminAuth.DELETE("/tokens/:id", adminHandler.DeleteAPIToken)
+	adminAuth.GET("/alerts", alertHandler.ListAlertRules)
+	adminAuth.POST("/alerts", alertHandler.CreateAlertRule)
+	adminAuth.PUT("/alerts/:id", alertHandler.UpdateAlertRule)
+	adminAuth.DELETE("/alerts/:id", alertHandler.DeleteAlertRule)
+	adminAuth.GET("/alerts/:id/deliveries", alertHandler.ListDeliveries)
+	adminAuth.POST("/alerts/:id/deliveries/:delivery/retry", alertHandler.RetryDelivery)
+
+	// Dead-lettered click events (super admin only)
+	deadLetterRoutes := adminAuth.Group("/dead-letters")
+	deadLetterRoutes.Use(authMiddleware.RequireAdmin())
+	deadLetterRoutes.GET("", deadLetterHandler.List)
+	deadLetterRoutes.POST("/replay", deadLetterHandler.Replay)
+	deadLetterRoutes.DELETE("/:id", deadLetterHandler.Delete)
 
 	// Serve static assets
 	serveStatic := func(prefix string) gin.HandlerFunc {
@@ -173,19 +303,32 @@ func main() {
 	// Redirect (must be last - catches all other paths)
 	r.GET("/:code", redirectHandler.Handle)
 
+	srv := &http.Server{Addr: cfg.HTTPAddr, Handler: r.Handler()}
+	// Live feed streams never finish on their own
+	srv.RegisterOnShutdown(liveFeed.Close)
+
 	// Graceful shutdown
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 		logger.Info("shutting down")
-		cancel()
-		consumer.Stop()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("server shutdown failed", logger.Err(err))
+		}
 	}()
 
 	logger.Info("server started", logger.Extra("addr", cfg.HTTPAddr))
-	if err := r.Run(cfg.HTTPAddr); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Error("server failed", logger.Err(err))
 		os.Exit(1)
 	}
+
+	// Stop background work after in-flight requests are done
+	cancel()
+	if consumer != nil {
+		consumer.Stop() // flushes and hands off pending entries
+	}
 }
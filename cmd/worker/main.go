@@ -0,0 +1,122 @@
+// Command worker runs the click event consumer on its own, so click
+// ingestion can scale separately from the API. Run the API with
+// EMBEDDED_WORKER=false when using it.
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wyp0596/go2short/internal/alert"
+	"github.com/wyp0596/go2short/internal/cache"
+	"github.com/wyp0596/go2short/internal/config"
+	"github.com/wyp0596/go2short/internal/events"
+	"github.com/wyp0596/go2short/internal/geo"
+	"github.com/wyp0596/go2short/internal/logger"
+	_ "github.com/wyp0596/go2short/internal/metrics" // register metrics
+	"github.com/wyp0596/go2short/internal/partition"
+	"github.com/wyp0596/go2short/internal/rollup"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+func main() {
+	_ = godotenv.Load() // .env is optional
+	cfg := config.Load()
+	if _, err := store.ParseInsertMode(cfg.ClickInsertMode); err != nil {
+		logger.Error("invalid CLICK_INSERT_MODE", logger.Err(err))
+		os.Exit(1)
+	}
+	detach, err := store.ParseRetentionMode(cfg.ClickRetentionMode)
+	if err != nil {
+		logger.Error("invalid CLICK_RETENTION_MODE", logger.Err(err))
+		os.Exit(1)
+	}
+
+	c, err := cache.New(cfg)
+	if err != nil {
+		logger.Error("failed to connect to Redis", logger.Err(err))
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	s, err := store.New(cfg)
+	if err != nil {
+		logger.Error("failed to connect to Postgres", logger.Err(err))
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	geoResolver, err := geo.Open(cfg.GeoIPDBPath)
+	if err != nil {
+		logger.Error("failed to open GeoIP database", logger.Err(err))
+		os.Exit(1)
+	}
+	defer geoResolver.Close()
+
+	visitors := cache.NewVisitors(c.Client(), cfg.RedisKeyPrefix, cfg.UniqueVisitorTTL)
+	deadLetters := events.NewDeadLetters(c.Client(), cfg.DeadLetterStream, cfg.StreamName, int64(cfg.StreamMaxLen))
+	consumer := events.NewConsumer(
+		c.Client(),
+		s,
+		deadLetters,
+		visitors,
+		geoResolver,
+		alert.NewEvaluator(s, cfg.AlertEvalInterval),
+		cfg.StreamName,
+		cfg.StreamGroup,
+		events.ConsumerName(cfg.WorkerConsumerName),
+		cfg.WorkerBatchSize,
+		cfg.WorkerFlushInterval,
+		events.ReclaimConfig{
+			Interval:      cfg.WorkerReclaimInterval,
+			MinIdle:       cfg.WorkerReclaimMinIdle,
+			MaxDeliveries: int64(cfg.WorkerMaxDeliveries),
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := consumer.Start(ctx); err != nil {
+		logger.Error("failed to start consumer", logger.Err(err))
+		os.Exit(1)
+	}
+	events.NewLagSampler(c.Client(), cfg.StreamName, cfg.StreamGroup, cfg.StreamLagInterval).Start(ctx)
+	rollup.NewJob(s, cfg.RollupInterval, cfg.RollupMaxHours).Start(ctx)
+	partition.NewJob(s, cfg.PartitionInterval, store.PartitionPolicy{
+		Ahead:           cfg.PartitionsAhead,
+		RetentionMonths: cfg.ClickRetentionMonths,
+		Detach:          detach,
+	}).Start(ctx)
+	alert.NewDispatcher(s, cfg.WebhookInterval).Start(ctx)
+
+	// Health and metrics for the orchestrator and Prometheus
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: cfg.WorkerHTTPAddr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("worker http server failed", logger.Err(err))
+		}
+	}()
+
+	logger.Info("worker started",
+		logger.Extra("consumer", consumer.Name()), logger.Extra("addr", cfg.WorkerHTTPAddr))
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	logger.Info("shutting down", logger.Extra("consumer", consumer.Name()))
+
+	consumer.Stop() // flushes and hands off pending entries
+	srv.Close()
+}
@@ -0,0 +1,224 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+const (
+	// rulesTTL is how long loaded rules are used before they are reloaded.
+	rulesTTL = 30 * time.Second
+	// spikeBaseline is the time before a spike window whose click rate is
+	// taken as usual.
+	spikeBaseline = 24 * time.Hour
+)
+
+// Payload is the JSON body of an alert webhook.
+type Payload struct {
+	Event         string    `json:"event"` // alert.clicks, alert.spike
+	RuleID        int       `json:"rule_id"`
+	Code          string    `json:"code,omitempty"` // empty for account rules
+	Threshold     int64     `json:"threshold"`
+	Clicks        int64     `json:"clicks"` // total, or within the window for spikes
+	WindowMinutes int       `json:"window_minutes,omitempty"`
+	UsualClicks   *float64  `json:"usual_clicks,omitempty"` // spike: average per window over the previous day
+	FiredAt       time.Time `json:"fired_at"`
+}
+
+// Evaluator checks alert rules after click batches are persisted. A rule
+// is evaluated only when a batch had human clicks on its links, at most
+// once per interval; touches within the interval are evaluated once it has
+// passed. Firing is claimed in the store, so consumers sharing the stream
+// never fire a rule twice. Not safe for concurrent use: the consumer calls
+// it from its run loop. A nil Evaluator does nothing.
+type Evaluator struct {
+	store     Storer
+	interval  time.Duration
+	now       func() time.Time
+	rules     []store.AlertRule
+	loadedAt  time.Time
+	pending   map[int]bool      // rules touched since their last evaluation
+	evaluated map[int]time.Time // last evaluation per rule
+}
+
+func NewEvaluator(s Storer, interval time.Duration) *Evaluator {
+	return &Evaluator{
+		store:     s,
+		interval:  interval,
+		now:       time.Now,
+		pending:   make(map[int]bool),
+		evaluated: make(map[int]time.Time),
+	}
+}
+
+// Observe evaluates the rules touched by events, a persisted batch, and
+// those touched earlier whose interval has passed. events may be empty.
+func (e *Evaluator) Observe(ctx context.Context, events []store.ClickEvent) {
+	if e == nil {
+		return
+	}
+	now := e.now()
+	e.loadRules(ctx, now)
+	if len(e.rules) == 0 {
+		return
+	}
+	e.touch(ctx, events)
+
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !e.pending[r.ID] || now.Sub(e.evaluated[r.ID]) < e.interval {
+			continue
+		}
+		delete(e.pending, r.ID)
+		e.evaluated[r.ID] = now
+		if err := e.evaluate(ctx, r, now); err != nil {
+			logger.Error("failed to evaluate alert rule", logger.Err(err), logger.Extra("rule_id", r.ID))
+		}
+	}
+}
+
+// loadRules reloads the rules once rulesTTL has passed. On failure the
+// previous rules stay in use.
+func (e *Evaluator) loadRules(ctx context.Context, now time.Time) {
+	if !e.loadedAt.IsZero() && now.Sub(e.loadedAt) < rulesTTL {
+		return
+	}
+	rules, err := e.store.ActiveAlertRules(ctx)
+	if err != nil {
+		logger.Error("failed to load alert rules", logger.Err(err))
+		return
+	}
+	e.rules, e.loadedAt = rules, now
+
+	// Forget rules that were deleted or disabled
+	ids := make(map[int]bool, len(rules))
+	for _, r := range rules {
+		ids[r.ID] = true
+	}
+	for id := range e.evaluated {
+		if !ids[id] {
+			delete(e.evaluated, id)
+			delete(e.pending, id)
+		}
+	}
+}
+
+// touch marks the rules watching links with human clicks in events.
+func (e *Evaluator) touch(ctx context.Context, events []store.ClickEvent) {
+	codes := make(map[string]bool)
+	for _, ev := range events {
+		if !ev.IsBot {
+			codes[ev.Code] = true
+		}
+	}
+	if len(codes) == 0 {
+		return
+	}
+
+	var owners map[string]int // loaded for the first account rule
+	for _, r := range e.rules {
+		switch {
+		case r.Code != "":
+			if codes[r.Code] {
+				e.pending[r.ID] = true
+			}
+		case r.UserID == nil:
+			e.pending[r.ID] = true
+		default:
+			if owners == nil {
+				owners = e.linkOwners(ctx, codes)
+			}
+			for code := range codes {
+				if uid, ok := owners[code]; ok && uid == *r.UserID {
+					e.pending[r.ID] = true
+					break
+				}
+			}
+		}
+	}
+}
+
+func (e *Evaluator) linkOwners(ctx context.Context, codes map[string]bool) map[string]int {
+	list := make([]string, 0, len(codes))
+	for code := range codes {
+		list = append(list, code)
+	}
+	owners, err := e.store.LinkOwners(ctx, list)
+	if err != nil || owners == nil {
+		if err != nil {
+			logger.Error("failed to load link owners for alert rules", logger.Err(err))
+		}
+		return map[string]int{}
+	}
+	return owners
+}
+
+// evaluate fires r if its condition holds. Click rules fire once; spike
+// rules at most once per window.
+func (e *Evaluator) evaluate(ctx context.Context, r *store.AlertRule, now time.Time) error {
+	switch r.Kind {
+	case store.AlertClicks:
+		if r.LastFiredAt != nil {
+			return nil // re-armed by editing the rule
+		}
+		clicks, err := e.store.AlertRuleClicks(ctx, *r, time.Time{}, now)
+		if err != nil || clicks < r.Threshold {
+			return err
+		}
+		return e.fire(ctx, r, 0, Payload{Clicks: clicks}, now)
+
+	case store.AlertSpike:
+		window := time.Duration(r.WindowMinutes) * time.Minute
+		if r.LastFiredAt != nil && now.Sub(*r.LastFiredAt) < window {
+			return nil
+		}
+		start := now.Add(-window)
+		clicks, err := e.store.AlertRuleRecentClicks(ctx, *r, start)
+		if err != nil || clicks < r.Threshold {
+			return err
+		}
+		before, err := e.store.AlertRuleClicks(ctx, *r, start.Add(-spikeBaseline), start)
+		if err != nil {
+			return err
+		}
+		usual := float64(before) * float64(window) / float64(spikeBaseline)
+		if float64(clicks) < r.Factor*usual {
+			return nil
+		}
+		usual = math.Round(usual*100) / 100
+		return e.fire(ctx, r, window, Payload{Clicks: clicks, WindowMinutes: r.WindowMinutes, UsualClicks: &usual}, now)
+	}
+	return nil
+}
+
+// fire queues the webhook of r unless another consumer fired it first.
+func (e *Evaluator) fire(ctx context.Context, r *store.AlertRule, cooldown time.Duration, p Payload, now time.Time) error {
+	p.Event = "alert." + r.Kind
+	p.RuleID = r.ID
+	p.Code = r.Code
+	p.Threshold = r.Threshold
+	p.FiredAt = now.UTC()
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	fired, err := e.store.FireAlert(ctx, *r, cooldown, p.Event, body)
+	if err != nil {
+		return err
+	}
+	// Fired here or elsewhere: skip until the rules are reloaded
+	r.LastFiredAt = &now
+	if fired {
+		metrics.AlertsFired.WithLabelValues(r.Kind).Inc()
+		logger.Info("alert fired", logger.Extra("rule_id", r.ID), logger.Extra("kind", r.Kind),
+			logger.Extra("clicks", p.Clicks))
+	}
+	return nil
+}
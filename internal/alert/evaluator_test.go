@@ -0,0 +1,255 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+type mockStore struct {
+	rules      []store.AlertRule
+	owners     map[string]int
+	clicks     int64 // AlertRuleClicks from zero (totals)
+	baseline   int64 // AlertRuleClicks from a non-zero time
+	recent     int64
+	loads      int
+	evaluated  []int // rule IDs passed to click queries
+	fired      map[int]bool
+	payloads   [][]byte
+	firedByOth bool // FireAlert reports another consumer fired first
+	now        func() time.Time
+}
+
+func (m *mockStore) ActiveAlertRules(ctx context.Context) ([]store.AlertRule, error) {
+	m.loads++
+	return append([]store.AlertRule(nil), m.rules...), nil
+}
+
+func (m *mockStore) LinkOwners(ctx context.Context, codes []string) (map[string]int, error) {
+	return m.owners, nil
+}
+
+func (m *mockStore) AlertRuleClicks(ctx context.Context, r store.AlertRule, from, to time.Time) (int64, error) {
+	if from.IsZero() {
+		m.evaluated = append(m.evaluated, r.ID)
+		return m.clicks, nil
+	}
+	return m.baseline, nil
+}
+
+func (m *mockStore) AlertRuleRecentClicks(ctx context.Context, r store.AlertRule, since time.Time) (int64, error) {
+	m.evaluated = append(m.evaluated, r.ID)
+	return m.recent, nil
+}
+
+func (m *mockStore) FireAlert(ctx context.Context, r store.AlertRule, cooldown time.Duration, event string, payload []byte) (bool, error) {
+	// Like the store, remember the firing for reloads
+	for i := range m.rules {
+		if m.rules[i].ID == r.ID {
+			t := m.now()
+			m.rules[i].LastFiredAt = &t
+		}
+	}
+	if m.firedByOth {
+		return false, nil
+	}
+	if m.fired == nil {
+		m.fired = make(map[int]bool)
+	}
+	m.fired[r.ID] = true
+	m.payloads = append(m.payloads, payload)
+	return true, nil
+}
+
+func newTestEvaluator(s *mockStore, now *time.Time) *Evaluator {
+	e := NewEvaluator(s, 10*time.Second)
+	e.now = func() time.Time { return *now }
+	s.now = e.now
+	return e
+}
+
+func clicks(codes ...string) []store.ClickEvent {
+	events := make([]store.ClickEvent, len(codes))
+	for i, code := range codes {
+		events[i] = store.ClickEvent{Code: code}
+	}
+	return events
+}
+
+func TestObserveFiresClickRuleOnce(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &mockStore{
+		rules:  []store.AlertRule{{ID: 1, Code: "abc", Kind: store.AlertClicks, Threshold: 100}},
+		clicks: 100,
+	}
+	e := newTestEvaluator(s, &now)
+
+	e.Observe(context.Background(), clicks("abc"))
+	if !s.fired[1] {
+		t.Fatal("expected rule to fire at threshold")
+	}
+	var p Payload
+	if err := json.Unmarshal(s.payloads[0], &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != "alert.clicks" || p.RuleID != 1 || p.Code != "abc" || p.Clicks != 100 || p.Threshold != 100 {
+		t.Errorf("unexpected payload %+v", p)
+	}
+
+	now = now.Add(time.Minute)
+	e.Observe(context.Background(), clicks("abc"))
+	if len(s.payloads) != 1 || len(s.evaluated) != 1 {
+		t.Errorf("expected a fired click rule to be skipped, got %d payloads, %d evaluations", len(s.payloads), len(s.evaluated))
+	}
+}
+
+func TestObserveOnlyTouchedRules(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	user := 7
+	other := 8
+	s := &mockStore{
+		rules: []store.AlertRule{
+			{ID: 1, Code: "abc", Kind: store.AlertClicks, Threshold: 100},
+			{ID: 2, UserID: &user, Kind: store.AlertClicks, Threshold: 100},
+			{ID: 3, UserID: &other, Kind: store.AlertClicks, Threshold: 100},
+			{ID: 4, Kind: store.AlertClicks, Threshold: 100}, // admin: all links
+		},
+		owners: map[string]int{"xyz": 7},
+	}
+	e := newTestEvaluator(s, &now)
+
+	// Bots touch nothing
+	e.Observe(context.Background(), []store.ClickEvent{{Code: "abc", IsBot: true}})
+	if len(s.evaluated) != 0 {
+		t.Fatalf("expected no evaluations for bot clicks, got %v", s.evaluated)
+	}
+
+	e.Observe(context.Background(), clicks("xyz"))
+	want := map[int]bool{2: true, 4: true}
+	if len(s.evaluated) != len(want) {
+		t.Fatalf("expected rules 2 and 4 evaluated, got %v", s.evaluated)
+	}
+	for _, id := range s.evaluated {
+		if !want[id] {
+			t.Errorf("rule %d evaluated without clicks on its links", id)
+		}
+	}
+}
+
+func TestObserveThrottlesRules(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &mockStore{rules: []store.AlertRule{{ID: 1, Code: "abc", Kind: store.AlertClicks, Threshold: 100}}}
+	e := newTestEvaluator(s, &now)
+
+	e.Observe(context.Background(), clicks("abc"))
+	now = now.Add(time.Second)
+	e.Observe(context.Background(), clicks("abc"))
+	if len(s.evaluated) != 1 {
+		t.Fatalf("expected 1 evaluation within the interval, got %d", len(s.evaluated))
+	}
+
+	// The throttled touch is evaluated once the interval passes, without new clicks
+	now = now.Add(10 * time.Second)
+	s.clicks = 100
+	e.Observe(context.Background(), nil)
+	if len(s.evaluated) != 2 || !s.fired[1] {
+		t.Errorf("expected the pending rule to be evaluated and fire, got %d evaluations", len(s.evaluated))
+	}
+	now = now.Add(10 * time.Second)
+	e.Observe(context.Background(), nil)
+	if len(s.evaluated) != 2 {
+		t.Errorf("expected no evaluation without new clicks, got %d", len(s.evaluated))
+	}
+}
+
+func TestObserveReloadsRules(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &mockStore{}
+	e := newTestEvaluator(s, &now)
+
+	e.Observe(context.Background(), nil)
+	now = now.Add(time.Second)
+	e.Observe(context.Background(), nil)
+	if s.loads != 1 {
+		t.Fatalf("expected rules cached, got %d loads", s.loads)
+	}
+	now = now.Add(rulesTTL)
+	e.Observe(context.Background(), nil)
+	if s.loads != 2 {
+		t.Errorf("expected rules reloaded after %v, got %d loads", rulesTTL, s.loads)
+	}
+}
+
+func TestObserveSpike(t *testing.T) {
+	tests := []struct {
+		name     string
+		recent   int64
+		baseline int64 // clicks in the 24h before the window
+		want     bool
+	}{
+		{"below threshold", 40, 0, false},
+		{"usual traffic", 100, 24 * 100, false},
+		{"spike", 300, 24 * 100, true},
+		{"first traffic", 50, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			s := &mockStore{
+				rules:    []store.AlertRule{{ID: 1, Code: "abc", Kind: store.AlertSpike, Threshold: 50, WindowMinutes: 60, Factor: 3}},
+				recent:   tt.recent,
+				baseline: tt.baseline,
+			}
+			newTestEvaluator(s, &now).Observe(context.Background(), clicks("abc"))
+			if s.fired[1] != tt.want {
+				t.Errorf("fired = %v, want %v", s.fired[1], tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveSpikeCooldown(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &mockStore{
+		rules:  []store.AlertRule{{ID: 1, Code: "abc", Kind: store.AlertSpike, Threshold: 50, WindowMinutes: 60, Factor: 3}},
+		recent: 100,
+	}
+	e := newTestEvaluator(s, &now)
+
+	e.Observe(context.Background(), clicks("abc"))
+	now = now.Add(30 * time.Minute)
+	e.Observe(context.Background(), clicks("abc"))
+	if len(s.payloads) != 1 {
+		t.Fatalf("expected 1 webhook within the window, got %d", len(s.payloads))
+	}
+	now = now.Add(31 * time.Minute)
+	e.Observe(context.Background(), clicks("abc"))
+	if len(s.payloads) != 2 {
+		t.Errorf("expected the rule to fire again after the window, got %d webhooks", len(s.payloads))
+	}
+}
+
+func TestObserveFiredElsewhere(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &mockStore{
+		rules:      []store.AlertRule{{ID: 1, Code: "abc", Kind: store.AlertClicks, Threshold: 10}},
+		clicks:     10,
+		firedByOth: true,
+	}
+	e := newTestEvaluator(s, &now)
+
+	e.Observe(context.Background(), clicks("abc"))
+	now = now.Add(time.Minute)
+	e.Observe(context.Background(), clicks("abc"))
+	if len(s.evaluated) != 1 {
+		t.Errorf("expected a rule fired by another consumer to be skipped, got %d evaluations", len(s.evaluated))
+	}
+}
+
+func TestNilEvaluator(t *testing.T) {
+	var e *Evaluator
+	e.Observe(context.Background(), clicks("abc")) // must not panic
+}
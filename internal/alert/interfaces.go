@@ -0,0 +1,24 @@
+package alert
+
+import (
+	"context"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// Storer defines the store operations needed to evaluate alert rules.
+type Storer interface {
+	ActiveAlertRules(ctx context.Context) ([]store.AlertRule, error)
+	LinkOwners(ctx context.Context, codes []string) (map[string]int, error)
+	AlertRuleClicks(ctx context.Context, r store.AlertRule, from, to time.Time) (int64, error)
+	AlertRuleRecentClicks(ctx context.Context, r store.AlertRule, since time.Time) (int64, error)
+	FireAlert(ctx context.Context, r store.AlertRule, cooldown time.Duration, event string, payload []byte) (bool, error)
+}
+
+// DeliveryStorer defines the store operations needed to deliver webhooks.
+type DeliveryStorer interface {
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]store.WebhookDelivery, error)
+	FinishWebhookDelivery(ctx context.Context, id int64, res store.DeliveryResult) error
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,93 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+var (
+	ErrInvalidURL     = errors.New("invalid webhook url")
+	ErrBlockedAddress = errors.New("webhook address not allowed")
+)
+
+// blockedNets are ranges not covered by the net.IP predicates.
+var blockedNets = []*net.IPNet{
+	mustCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustCIDR("198.18.0.0/15"), // benchmarking
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// lookupIPAddr resolves webhook hosts; replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// isBlockedIP reports whether webhooks may not be sent to ip: loopback,
+// private, link-local, unspecified, multicast and shared addresses.
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL validates a webhook URL: http(s) with a host whose addresses are
+// all allowed. It fails closed, so hosts that do not resolve are rejected.
+// Delivery checks the address again when connecting (see NewDispatcher),
+// since DNS can change after the rule is saved.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedIP(ip) {
+			return ErrBlockedAddress
+		}
+		return nil
+	}
+
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if len(addrs) == 0 {
+		return ErrInvalidURL
+	}
+	for _, a := range addrs {
+		if isBlockedIP(a.IP) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+// dialControl rejects connections to blocked addresses. It runs after DNS
+// resolution, for every address dialed.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
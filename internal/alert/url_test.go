@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	hosts := map[string][]string{
+		"hooks.example.com": {"93.184.216.34"},
+		"mixed.example.com": {"93.184.216.34", "10.0.0.5"},
+		"v6.example.com":    {"2606:2800:220:1::1", "fd00::1"},
+	}
+	orig := lookupIPAddr
+	defer func() { lookupIPAddr = orig }()
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		ips, ok := hosts[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		addrs := make([]net.IPAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+		}
+		return addrs, nil
+	}
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://hooks.example.com/alerts", nil},
+		{"http://93.184.216.34:8080/", nil},
+		{"ftp://hooks.example.com/", ErrInvalidURL},
+		{"https:///path", ErrInvalidURL},
+		{"https://unknown.example.com/", ErrInvalidURL}, // fails closed
+		{"http://127.0.0.1/", ErrBlockedAddress},
+		{"http://0.0.0.0/", ErrBlockedAddress},
+		{"http://[::]/", ErrBlockedAddress},
+		{"http://[::1]:9000/", ErrBlockedAddress},
+		{"http://[::ffff:127.0.0.1]/", ErrBlockedAddress},
+		{"http://10.1.2.3/", ErrBlockedAddress},
+		{"http://172.16.0.1/", ErrBlockedAddress},
+		{"http://192.168.1.1/", ErrBlockedAddress},
+		{"http://169.254.169.254/latest/meta-data/", ErrBlockedAddress},
+		{"http://100.64.0.1/", ErrBlockedAddress},
+		{"http://100.127.255.254/", ErrBlockedAddress},
+		{"https://mixed.example.com/", ErrBlockedAddress}, // every address is checked
+		{"https://v6.example.com/", ErrBlockedAddress},
+	}
+	for _, tt := range tests {
+		err := CheckURL(context.Background(), tt.url)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("CheckURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	for addr, blocked := range map[string]bool{
+		"93.184.216.34:443": false,
+		"127.0.0.1:80":      true,
+		"0.0.0.0:80":        true,
+		"100.64.1.1:443":    true,
+		"[fe80::1]:443":     true,
+	} {
+		err := dialControl("tcp", addr, nil)
+		if blocked != errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("dialControl(%q) = %v, want blocked = %v", addr, err, blocked)
+		}
+	}
+}
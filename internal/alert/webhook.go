@@ -0,0 +1,199 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// retryBackoff is the wait before each retry of a failed delivery. The
+// delivery fails for good when the last retry fails.
+var retryBackoff = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 6 * time.Hour}
+
+const (
+	deliveryBatch     = 20
+	deliveryTimeout   = 10 * time.Second
+	deliveryLease     = time.Minute // a claimed delivery is due again after this if its sender dies
+	deliveryRetention = 30 * 24 * time.Hour
+	pruneInterval     = time.Hour
+	maxErrorLen       = 500
+)
+
+// Webhook request headers.
+const (
+	HeaderEvent     = "X-Go2Short-Event"
+	HeaderDelivery  = "X-Go2Short-Delivery"
+	HeaderSignature = "X-Go2Short-Signature"
+)
+
+// Sign returns the signature header of body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+// Receivers recompute it with the rule's secret and reject old timestamps.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends queued alert webhooks and records each attempt, retrying
+// failures with backoff. Every consumer process runs one; deliveries are
+// claimed in the store, so each is sent by one dispatcher at a time.
+type Dispatcher struct {
+	store    DeliveryStorer
+	client   *http.Client
+	interval time.Duration
+	now      func() time.Time
+	pruned   time.Time
+}
+
+func NewDispatcher(s DeliveryStorer, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		store: s,
+		client: &http.Client{
+			Timeout: deliveryTimeout,
+			// Every dialed address is checked, whatever the URL resolved to
+			// when the rule was saved; no proxy, which would hide it
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout: deliveryTimeout,
+					Control: dialControl,
+				}).DialContext,
+				TLSHandshakeTimeout: deliveryTimeout,
+				MaxIdleConns:        deliveryBatch,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// Receivers must answer themselves: a redirect could point anywhere
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start runs the dispatcher until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			d.run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// run sends due deliveries until none are left, then prunes the log.
+func (d *Dispatcher) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := d.store.ClaimWebhookDeliveries(ctx, deliveryBatch, deliveryLease)
+		if err != nil {
+			logger.Error("failed to claim webhook deliveries", logger.Err(err))
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, w := range deliveries {
+			wg.Add(1)
+			go func(w store.WebhookDelivery) {
+				defer wg.Done()
+				d.deliver(ctx, w)
+			}(w)
+		}
+		wg.Wait()
+
+		if len(deliveries) < deliveryBatch {
+			break
+		}
+	}
+	d.prune(ctx)
+}
+
+// deliver sends one claimed delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, w store.WebhookDelivery) {
+	status, err := d.send(ctx, w)
+	res := store.DeliveryResult{Status: store.DeliveryDelivered, ResponseStatus: status}
+	if err != nil {
+		res.Error = err.Error()
+		if len(res.Error) > maxErrorLen {
+			res.Error = res.Error[:maxErrorLen]
+		}
+		if w.Attempts > len(retryBackoff) {
+			res.Status = store.DeliveryFailed
+			logger.Error("webhook delivery failed", logger.Err(err),
+				logger.Extra("delivery_id", w.ID), logger.Extra("attempts", w.Attempts))
+		} else {
+			res.Status = store.DeliveryPending
+			res.NextAttempt = d.now().Add(retryBackoff[w.Attempts-1])
+		}
+	}
+	metrics.WebhookDeliveries.WithLabelValues(res.Status).Inc()
+
+	if err := d.store.FinishWebhookDelivery(ctx, w.ID, res); err != nil {
+		// Due again after the lease: it may be sent twice
+		logger.Error("failed to record webhook delivery", logger.Err(err), logger.Extra("delivery_id", w.ID))
+	}
+}
+
+// send posts the payload and returns the response status, with an error
+// unless it is 2xx.
+func (d *Dispatcher) send(ctx context.Context, w store.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(w.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go2short-webhook")
+	req.Header.Set(HeaderEvent, w.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(w.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, d.now(), w.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// prune deletes finished deliveries past retention, at most once per
+// pruneInterval.
+func (d *Dispatcher) prune(ctx context.Context) {
+	now := d.now()
+	if now.Sub(d.pruned) < pruneInterval {
+		return
+	}
+	d.pruned = now
+	n, err := d.store.PruneWebhookDeliveries(ctx, now.Add(-deliveryRetention))
+	if err != nil {
+		logger.Error("failed to prune webhook deliveries", logger.Err(err))
+		return
+	}
+	if n > 0 {
+		logger.Info("pruned webhook deliveries", logger.Extra("count", n))
+	}
+}
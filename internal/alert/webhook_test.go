@@ -0,0 +1,196 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+type mockDeliveryStore struct {
+	mu       sync.Mutex
+	batches  [][]store.WebhookDelivery
+	claimErr error
+	claims   int
+	results  map[int64]store.DeliveryResult
+	pruned   int
+}
+
+func (m *mockDeliveryStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]store.WebhookDelivery, error) {
+	m.claims++
+	if m.claimErr != nil {
+		return nil, m.claimErr
+	}
+	if len(m.batches) == 0 {
+		return nil, nil
+	}
+	b := m.batches[0]
+	m.batches = m.batches[1:]
+	return b, nil
+}
+
+func (m *mockDeliveryStore) FinishWebhookDelivery(ctx context.Context, id int64, res store.DeliveryResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.results == nil {
+		m.results = make(map[int64]store.DeliveryResult)
+	}
+	m.results[id] = res
+	return nil
+}
+
+func (m *mockDeliveryStore) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	m.pruned++
+	return 0, nil
+}
+
+// newTestDispatcher returns a dispatcher that may reach httptest servers on
+// loopback addresses.
+func newTestDispatcher(s DeliveryStorer) *Dispatcher {
+	d := NewDispatcher(s, time.Second)
+	d.client.Transport = &http.Transport{}
+	return d
+}
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	sig := Sign("secret", at, []byte(`{"a":1}`))
+	if !strings.HasPrefix(sig, "t=1700000000,v1=") || len(sig) != len("t=1700000000,v1=")+64 {
+		t.Fatalf("unexpected signature format %q", sig)
+	}
+	if Sign("secret", at, []byte(`{"a":1}`)) != sig {
+		t.Error("signature is not deterministic")
+	}
+	if Sign("other", at, []byte(`{"a":1}`)) == sig {
+		t.Error("signature does not depend on the secret")
+	}
+	if Sign("secret", at, []byte(`{"a":2}`)) == sig {
+		t.Error("signature does not depend on the body")
+	}
+	if Sign("secret", at.Add(time.Second), []byte(`{"a":1}`)) == sig {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := `{"event":"alert.clicks"}`
+	var got *http.Request
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, gotBody = r, string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := &mockDeliveryStore{batches: [][]store.WebhookDelivery{{
+		{ID: 5, Event: "alert.clicks", Payload: []byte(body), Attempts: 1, URL: srv.URL, Secret: "s3cret"},
+	}}}
+	d := newTestDispatcher(s)
+	d.now = func() time.Time { return now }
+	d.run(context.Background())
+
+	if got == nil {
+		t.Fatal("webhook not sent")
+	}
+	if gotBody != body {
+		t.Errorf("body = %q, want %q", gotBody, body)
+	}
+	if h := got.Header.Get(HeaderSignature); h != Sign("s3cret", now, []byte(body)) {
+		t.Errorf("signature = %q", h)
+	}
+	if got.Header.Get(HeaderEvent) != "alert.clicks" || got.Header.Get(HeaderDelivery) != "5" {
+		t.Errorf("unexpected headers %v", got.Header)
+	}
+	res := s.results[5]
+	if res.Status != store.DeliveryDelivered || res.ResponseStatus != http.StatusNoContent || res.Error != "" {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if s.pruned != 1 {
+		t.Errorf("expected the log to be pruned once, got %d", s.pruned)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	s := &mockDeliveryStore{batches: [][]store.WebhookDelivery{{
+		{ID: 1, Payload: []byte(`{}`), Attempts: 1, URL: srv.URL},
+		{ID: 2, Payload: []byte(`{}`), Attempts: len(retryBackoff) + 1, URL: srv.URL},
+	}}}
+	d := newTestDispatcher(s)
+	d.now = func() time.Time { return now }
+	d.run(context.Background())
+
+	retry := s.results[1]
+	if retry.Status != store.DeliveryPending || !retry.NextAttempt.Equal(now.Add(retryBackoff[0])) {
+		t.Errorf("expected a retry after %v, got %+v", retryBackoff[0], retry)
+	}
+	if retry.ResponseStatus != http.StatusServiceUnavailable || retry.Error == "" {
+		t.Errorf("expected the response recorded, got %+v", retry)
+	}
+	if failed := s.results[2]; failed.Status != store.DeliveryFailed {
+		t.Errorf("expected the last attempt to fail for good, got %+v", failed)
+	}
+}
+
+func TestDispatcherDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	s := &mockDeliveryStore{batches: [][]store.WebhookDelivery{{{ID: 1, Payload: []byte(`{}`), Attempts: 1, URL: srv.URL}}}}
+	newTestDispatcher(s).run(context.Background())
+
+	if followed {
+		t.Error("redirect was followed")
+	}
+	if res := s.results[1]; res.Status != store.DeliveryPending || res.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Errorf("expected a redirect to count as a failure, got %+v", res)
+	}
+}
+
+func TestDispatcherStopsOnClaimError(t *testing.T) {
+	s := &mockDeliveryStore{claimErr: errors.New("db down")}
+	NewDispatcher(s, time.Second).run(context.Background())
+
+	if s.claims != 1 || s.pruned != 0 {
+		t.Errorf("expected 1 claim and no prune, got %d claims, %d prunes", s.claims, s.pruned)
+	}
+}
+
+func TestDispatcherBlocksLoopback(t *testing.T) {
+	reached := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer srv.Close()
+
+	s := &mockDeliveryStore{batches: [][]store.WebhookDelivery{{{ID: 1, Payload: []byte(`{}`), Attempts: 1, URL: srv.URL}}}}
+	NewDispatcher(s, time.Second).run(context.Background())
+
+	if reached {
+		t.Error("webhook sent to a loopback address")
+	}
+	if res := s.results[1]; res.Status != store.DeliveryPending || !strings.Contains(res.Error, ErrBlockedAddress.Error()) {
+		t.Errorf("expected a blocked delivery, got %+v", res)
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+)
+
+// ErrUnavailable is returned instead of calling Redis while the breaker is open.
+var ErrUnavailable = errors.New("redis unavailable (circuit open)")
+
+// Breaker is a consecutive-failure circuit breaker for Redis.
+// After threshold failures it opens and rejects calls for cooldown, then
+// lets one probe through per cooldown until a call succeeds.
+type Breaker struct {
+	threshold int32
+	cooldown  time.Duration
+
+	failures atomic.Int32
+	mu       sync.Mutex
+	retryAt  time.Time // open: no calls before this time
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{threshold: int32(threshold), cooldown: cooldown}
+}
+
+// Allow reports whether a call may go to Redis.
+func (b *Breaker) Allow() bool {
+	if b.failures.Load() < b.threshold {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Before(b.retryAt) {
+		return false
+	}
+	// Half-open: allow this probe, hold the rest off for another cooldown
+	b.retryAt = now.Add(b.cooldown)
+	return true
+}
+
+// Success records a successful call and closes the breaker.
+func (b *Breaker) Success() {
+	if b.failures.Load() == 0 {
+		return
+	}
+	if b.failures.Swap(0) >= b.threshold {
+		metrics.RedisDegraded.Set(0)
+		logger.Info("redis recovered, leaving degraded mode")
+	}
+}
+
+// Failure records a failed call and opens the breaker at the threshold.
+func (b *Breaker) Failure() {
+	n := b.failures.Add(1)
+	if n < b.threshold {
+		return
+	}
+	b.mu.Lock()
+	b.retryAt = time.Now().Add(b.cooldown)
+	b.mu.Unlock()
+	if n == b.threshold {
+		metrics.RedisDegraded.Set(1)
+		logger.Error("redis failing, entering degraded mode", logger.Extra("failures", n))
+	}
+}
+
+// Degraded reports whether the breaker is open.
+func (b *Breaker) Degraded() bool {
+	return b.failures.Load() >= b.threshold
+}
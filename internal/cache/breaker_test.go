@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(3, 20*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		b.Failure()
+	}
+	if !b.Allow() || b.Degraded() {
+		t.Fatal("breaker should stay closed below threshold")
+	}
+
+	b.Failure()
+	if !b.Degraded() {
+		t.Fatal("breaker should open at threshold")
+	}
+	if b.Allow() {
+		t.Error("open breaker should reject calls during cooldown")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	if b.Allow() {
+		t.Error("only one probe should pass per cooldown")
+	}
+
+	b.Success()
+	if b.Degraded() || !b.Allow() {
+		t.Error("successful probe should close the breaker")
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := NewBreaker(2, time.Minute)
+	b.Failure()
+	b.Success()
+	b.Failure()
+	if b.Degraded() {
+		t.Error("failures should be consecutive")
+	}
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+)
+
+func (c *Cache) invalidateChannel() string {
+	return c.prefix + ":invalidate"
+}
+
+// Invalidate drops the cached URL and negative entry for a code and
+// broadcasts the code so every instance can drop its local copies.
+// Every path that creates, updates, disables or deletes a link must call it.
+func (c *Cache) Invalidate(ctx context.Context, code string) error {
+	pipe := c.client.TxPipeline()
+	pipe.Del(ctx, c.linkKey(code), c.missKey(code))
+	pipe.Publish(ctx, c.invalidateChannel(), code)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	metrics.CacheInvalidations.Inc()
+	// Drop local copies right away; the broadcast reaches other instances
+	c.notify(code)
+	return nil
+}
+
+// OnInvalidate registers fn to be called with every invalidated code,
+// including codes invalidated by other instances.
+func (c *Cache) OnInvalidate(fn func(code string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// ListenInvalidations subscribes to invalidation broadcasts until ctx is done.
+func (c *Cache) ListenInvalidations(ctx context.Context) error {
+	pubsub := c.client.Subscribe(ctx, c.invalidateChannel())
+	// Wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					logger.Error("invalidation subscription closed")
+					return
+				}
+				c.notify(msg.Payload)
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Cache) notify(code string) {
+	c.mu.RLock()
+	listeners := c.listeners
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(code)
+	}
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/metrics"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// Backend is the cache tier behind Local (normally *Cache, i.e. Redis).
+type Backend interface {
+	GetLink(ctx context.Context, code string) (*store.Link, error)
+	SetLink(ctx context.Context, link *store.Link) error
+	IsMiss(ctx context.Context, code string) (bool, error)
+	SetMiss(ctx context.Context, code string) error
+}
+
+// Local is a bounded in-process LRU cache with TTL, layered in front of a
+// Backend. It caches both link records and negative entries. Reads fall
+// through to the backend; writes go to both tiers.
+//
+// Entries must be dropped with Remove when a link changes; wire it with
+// Cache.OnInvalidate so every instance drops its copy.
+type Local struct {
+	next Backend
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+type localEntry struct {
+	code    string
+	link    *store.Link // nil for negative entries
+	expires time.Time
+}
+
+func NewLocal(next Backend, size int, ttl time.Duration) *Local {
+	return &Local{
+		next:  next,
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+// GetLink returns the link from the local tier, falling back to the backend.
+func (l *Local) GetLink(ctx context.Context, code string) (*store.Link, error) {
+	if e, ok := l.get(code); ok {
+		if e.link == nil {
+			return nil, nil // negative entry, IsMiss answers it
+		}
+		metrics.CacheTierHits.WithLabelValues("local").Inc()
+		return e.link, nil
+	}
+	metrics.CacheTierMisses.WithLabelValues("local").Inc()
+
+	link, err := l.next.GetLink(ctx, code)
+	if err != nil || link == nil {
+		return link, err
+	}
+	l.put(code, link)
+	return link, nil
+}
+
+// SetLink stores the link in both tiers.
+func (l *Local) SetLink(ctx context.Context, link *store.Link) error {
+	l.put(link.Code, link)
+	return l.next.SetLink(ctx, link)
+}
+
+// IsMiss checks the local negative entry, falling back to the backend.
+func (l *Local) IsMiss(ctx context.Context, code string) (bool, error) {
+	if e, ok := l.get(code); ok && e.link == nil {
+		return true, nil
+	}
+	miss, err := l.next.IsMiss(ctx, code)
+	if err != nil || !miss {
+		return false, err
+	}
+	l.put(code, nil)
+	return true, nil
+}
+
+// SetMiss stores a negative entry in both tiers.
+func (l *Local) SetMiss(ctx context.Context, code string) error {
+	l.put(code, nil)
+	return l.next.SetMiss(ctx, code)
+}
+
+// Remove drops a code from the local tier.
+func (l *Local) Remove(code string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[code]; ok {
+		l.removeElement(el)
+	}
+}
+
+// Len returns the number of entries in the local tier.
+func (l *Local) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+func (l *Local) get(code string) (*localEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[code]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*localEntry)
+	if time.Now().After(e.expires) {
+		l.removeElement(el)
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return e, true
+}
+
+func (l *Local) put(code string, link *store.Link) {
+	if l.size <= 0 {
+		return
+	}
+	expires := time.Now().Add(l.ttl)
+	if link != nil && link.ExpiresAt.Valid && link.ExpiresAt.Time.After(time.Now()) && link.ExpiresAt.Time.Before(expires) {
+		expires = link.ExpiresAt.Time
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[code]; ok {
+		e := el.Value.(*localEntry)
+		e.link = link
+		e.expires = expires
+		l.ll.MoveToFront(el)
+		return
+	}
+	l.items[code] = l.ll.PushFront(&localEntry{code: code, link: link, expires: expires})
+	for l.ll.Len() > l.size {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *Local) removeElement(el *list.Element) {
+	l.ll.Remove(el)
+	delete(l.items, el.Value.(*localEntry).code)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+type fakeBackend struct {
+	links  map[string]*store.Link
+	misses map[string]bool
+	gets   int
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{links: make(map[string]*store.Link), misses: make(map[string]bool)}
+}
+
+func (f *fakeBackend) GetLink(_ context.Context, code string) (*store.Link, error) {
+	f.gets++
+	return f.links[code], nil
+}
+
+func (f *fakeBackend) SetLink(_ context.Context, link *store.Link) error {
+	f.links[link.Code] = link
+	return nil
+}
+
+func (f *fakeBackend) IsMiss(_ context.Context, code string) (bool, error) {
+	return f.misses[code], nil
+}
+
+func (f *fakeBackend) SetMiss(_ context.Context, code string) error {
+	f.misses[code] = true
+	return nil
+}
+
+func TestLocalReadThrough(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	fb.links["abc123"] = &store.Link{Code: "abc123", LongURL: "https://example.com"}
+	l := NewLocal(fb, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		link, err := l.GetLink(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("GetLink failed: %v", err)
+		}
+		if link == nil || link.LongURL != "https://example.com" {
+			t.Fatalf("unexpected link %+v", link)
+		}
+	}
+	if fb.gets != 1 {
+		t.Errorf("expected 1 backend read, got %d", fb.gets)
+	}
+}
+
+func TestLocalRemove(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	l := NewLocal(fb, 10, time.Minute)
+
+	_ = l.SetLink(ctx, &store.Link{Code: "abc123", LongURL: "https://old.example.com"})
+	fb.links["abc123"] = &store.Link{Code: "abc123", LongURL: "https://new.example.com"}
+	l.Remove("abc123")
+
+	link, _ := l.GetLink(ctx, "abc123")
+	if link == nil || link.LongURL != "https://new.example.com" {
+		t.Errorf("expected fresh value after Remove, got %+v", link)
+	}
+}
+
+func TestLocalNegativeEntries(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	l := NewLocal(fb, 10, time.Minute)
+
+	_ = l.SetMiss(ctx, "nolink")
+	delete(fb.misses, "nolink") // local tier must answer on its own
+
+	if miss, _ := l.IsMiss(ctx, "nolink"); !miss {
+		t.Error("expected local negative hit")
+	}
+	if link, _ := l.GetLink(ctx, "nolink"); link != nil {
+		t.Error("negative entry should not return a link")
+	}
+	if fb.gets != 0 {
+		t.Errorf("negative entry should not read backend, got %d reads", fb.gets)
+	}
+}
+
+func TestLocalEviction(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	l := NewLocal(fb, 2, time.Minute)
+
+	_ = l.SetLink(ctx, &store.Link{Code: "code01", LongURL: "https://1.example.com"})
+	_ = l.SetLink(ctx, &store.Link{Code: "code02", LongURL: "https://2.example.com"})
+	_, _ = l.GetLink(ctx, "code01") // code01 is now most recently used
+	_ = l.SetLink(ctx, &store.Link{Code: "code03", LongURL: "https://3.example.com"})
+
+	if l.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", l.Len())
+	}
+	if _, ok := l.get("code02"); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+	if _, ok := l.get("code01"); !ok {
+		t.Error("recently used entry should be kept")
+	}
+}
+
+func TestLocalTTL(t *testing.T) {
+	ctx := context.Background()
+	fb := newFakeBackend()
+	l := NewLocal(fb, 10, time.Minute)
+
+	// TTL is capped at link expiry
+	_ = l.SetLink(ctx, &store.Link{
+		Code:      "soon01",
+		LongURL:   "https://example.com",
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(20 * time.Millisecond), Valid: true},
+	})
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := l.get("soon01"); ok {
+		t.Error("entry should expire with the link")
+	}
+
+	l = NewLocal(fb, 10, 10*time.Millisecond)
+	_ = l.SetLink(ctx, &store.Link{Code: "short1", LongURL: "https://example.com"})
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := l.get("short1"); ok {
+		t.Error("entry should expire after TTL")
+	}
+}
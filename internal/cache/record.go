@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// recordVersion prefixes every encoded link so the format can evolve.
+const recordVersion = "1"
+
+// encodeLink packs the fields needed to serve a redirect into one string:
+//
+//	1|<redirect_type>|<disabled 0/1>|<expires_at unix or empty>|<user_id or empty>|<long_url>
+//
+// The URL goes last so it may contain the separator.
+func encodeLink(l *store.Link) string {
+	var b strings.Builder
+	b.Grow(len(l.LongURL) + 32)
+	b.WriteString(recordVersion)
+	b.WriteByte('|')
+	b.WriteString(strconv.Itoa(l.RedirectType))
+	b.WriteByte('|')
+	if l.IsDisabled {
+		b.WriteByte('1')
+	} else {
+		b.WriteByte('0')
+	}
+	b.WriteByte('|')
+	if l.ExpiresAt.Valid {
+		b.WriteString(strconv.FormatInt(l.ExpiresAt.Time.Unix(), 10))
+	}
+	b.WriteByte('|')
+	if l.UserID.Valid {
+		b.WriteString(strconv.FormatInt(int64(l.UserID.Int32), 10))
+	}
+	b.WriteByte('|')
+	b.WriteString(l.LongURL)
+	return b.String()
+}
+
+// decodeLink parses a value written by encodeLink. Returns false for
+// values in an unknown format (e.g. plain URLs from older versions).
+func decodeLink(code, val string) (*store.Link, bool) {
+	parts := strings.SplitN(val, "|", 6)
+	if len(parts) != 6 || parts[0] != recordVersion || parts[5] == "" {
+		return nil, false
+	}
+
+	redirectType, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	link := &store.Link{
+		Code:         code,
+		LongURL:      parts[5],
+		IsDisabled:   parts[2] == "1",
+		RedirectType: redirectType,
+	}
+	if parts[3] != "" {
+		sec, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		link.ExpiresAt = sql.NullTime{Time: time.Unix(sec, 0), Valid: true}
+	}
+	if parts[4] != "" {
+		uid, err := strconv.ParseInt(parts[4], 10, 32)
+		if err != nil {
+			return nil, false
+		}
+		link.UserID = sql.NullInt32{Int32: int32(uid), Valid: true}
+	}
+	return link, true
+}
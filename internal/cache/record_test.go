@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+func TestEncodeDecodeLink(t *testing.T) {
+	exp := time.Unix(1767225599, 0)
+
+	tests := []struct {
+		name string
+		link store.Link
+	}{
+		{"minimal", store.Link{Code: "abc123", LongURL: "https://example.com"}},
+		{"all fields", store.Link{
+			Code:         "abc123",
+			LongURL:      "https://example.com/a|b?c=d",
+			ExpiresAt:    sql.NullTime{Time: exp, Valid: true},
+			IsDisabled:   true,
+			UserID:       sql.NullInt32{Int32: 42, Valid: true},
+			RedirectType: 301,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeLink(tt.link.Code, encodeLink(&tt.link))
+			if !ok {
+				t.Fatal("decodeLink failed")
+			}
+			if got.Code != tt.link.Code || got.LongURL != tt.link.LongURL ||
+				got.IsDisabled != tt.link.IsDisabled || got.RedirectType != tt.link.RedirectType ||
+				got.UserID != tt.link.UserID || got.ExpiresAt.Valid != tt.link.ExpiresAt.Valid ||
+				!got.ExpiresAt.Time.Equal(tt.link.ExpiresAt.Time) {
+				t.Errorf("round trip mismatch: got %+v, want %+v", *got, tt.link)
+			}
+		})
+	}
+}
+
+func TestDecodeLinkRejectsUnknownFormat(t *testing.T) {
+	for _, val := range []string{
+		"https://example.com",               // plain URL from older versions
+		"301|https://example.com",           // prefixed URL from older versions
+		"2|302|0|||https://x.com",           // unknown version
+		"1|abc|0|||https://x.com",           // bad redirect type
+		"1|302|0|notanumber||https://x.com", // bad expiry
+		"1|302|0|||",                        // empty URL
+	} {
+		if _, ok := decodeLink("abc123", val); ok {
+			t.Errorf("decodeLink(%q) should fail", val)
+		}
+	}
+}
+
+func TestTTLFor(t *testing.T) {
+	c := &Cache{negTTL: time.Minute, linkTTL: time.Hour}
+
+	if ttl := c.ttlFor(&store.Link{}); ttl != time.Hour {
+		t.Errorf("no expiry: got %v, want 1h", ttl)
+	}
+
+	soon := &store.Link{ExpiresAt: sql.NullTime{Time: time.Now().Add(10 * time.Minute), Valid: true}}
+	if ttl := c.ttlFor(soon); ttl > 10*time.Minute || ttl < 9*time.Minute {
+		t.Errorf("expiring link: got %v, want ~10m", ttl)
+	}
+
+	expired := &store.Link{ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}}
+	if ttl := c.ttlFor(expired); ttl != time.Minute {
+		t.Errorf("expired link: got %v, want negative TTL", ttl)
+	}
+
+	c.linkTTL = 0
+	if ttl := c.ttlFor(soon); ttl > 10*time.Minute || ttl == 0 {
+		t.Errorf("unbounded TTL should still be capped at expiry, got %v", ttl)
+	}
+}
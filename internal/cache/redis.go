@@ -3,16 +3,24 @@ package cache
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/wyp0596/go2short/internal/config"
+	"github.com/wyp0596/go2short/internal/metrics"
+	"github.com/wyp0596/go2short/internal/store"
 )
 
 type Cache struct {
-	client *redis.Client
-	prefix string
-	negTTL time.Duration
+	client  *redis.Client
+	prefix  string
+	negTTL  time.Duration
+	linkTTL time.Duration
+	breaker *Breaker
+
+	mu        sync.RWMutex
+	listeners []func(code string) // invalidation listeners
 }
 
 func New(cfg *config.Config) (*Cache, error) {
@@ -41,9 +49,11 @@ func New(cfg *config.Config) (*Cache, error) {
 	}
 
 	return &Cache{
-		client: client,
-		prefix: cfg.RedisKeyPrefix,
-		negTTL: cfg.NegativeCacheTTL,
+		client:  client,
+		prefix:  cfg.RedisKeyPrefix,
+		negTTL:  cfg.NegativeCacheTTL,
+		linkTTL: cfg.LinkCacheTTL,
+		breaker: NewBreaker(cfg.RedisBreakerThreshold, cfg.RedisBreakerCooldown),
 	}, nil
 }
 
@@ -55,29 +65,93 @@ func (c *Cache) missKey(code string) string {
 	return c.prefix + ":miss:" + code
 }
 
-// GetURL returns the long URL for a code. Returns empty string if not found.
-func (c *Cache) GetURL(ctx context.Context, code string) (string, error) {
-	val, err := c.client.Get(ctx, c.linkKey(code)).Result()
+// GetLink returns the cached link record for a code. Returns nil if not found.
+// Entries that cannot be decoded are treated as a miss.
+func (c *Cache) GetLink(ctx context.Context, code string) (*store.Link, error) {
+	var val string
+	err := c.do(ctx, "get_link", func() (err error) {
+		val, err = c.client.Get(ctx, c.linkKey(code)).Result()
+		return err
+	})
 	if err == redis.Nil {
-		return "", nil
+		metrics.CacheTierMisses.WithLabelValues("redis").Inc()
+		return nil, nil
 	}
-	return val, err
+	if err != nil {
+		return nil, err
+	}
+	link, ok := decodeLink(code, val)
+	if !ok {
+		metrics.CacheTierMisses.WithLabelValues("redis").Inc()
+		return nil, nil
+	}
+	metrics.CacheTierHits.WithLabelValues("redis").Inc()
+	return link, nil
+}
+
+// SetLink caches a link record. The TTL is capped at the link's expiry.
+func (c *Cache) SetLink(ctx context.Context, link *store.Link) error {
+	return c.do(ctx, "set_link", func() error {
+		return c.client.Set(ctx, c.linkKey(link.Code), encodeLink(link), c.ttlFor(link)).Err()
+	})
 }
 
-// SetURL caches a code -> URL mapping.
-func (c *Cache) SetURL(ctx context.Context, code, url string) error {
-	return c.client.Set(ctx, c.linkKey(code), url, 0).Err()
+// ttlFor returns the cache TTL for a link (0 = no expiry).
+// Already expired links are cached for the negative TTL so repeated hits
+// are answered with 410 without going to the database.
+func (c *Cache) ttlFor(link *store.Link) time.Duration {
+	ttl := c.linkTTL
+	if link.ExpiresAt.Valid {
+		until := time.Until(link.ExpiresAt.Time)
+		if until <= 0 {
+			return c.negTTL
+		}
+		if ttl == 0 || until < ttl {
+			ttl = until
+		}
+	}
+	return ttl
 }
 
 // IsMiss checks if code is in negative cache.
 func (c *Cache) IsMiss(ctx context.Context, code string) (bool, error) {
-	exists, err := c.client.Exists(ctx, c.missKey(code)).Result()
+	var exists int64
+	err := c.do(ctx, "is_miss", func() (err error) {
+		exists, err = c.client.Exists(ctx, c.missKey(code)).Result()
+		return err
+	})
 	return exists > 0, err
 }
 
 // SetMiss marks a code as not found (negative cache).
 func (c *Cache) SetMiss(ctx context.Context, code string) error {
-	return c.client.Set(ctx, c.missKey(code), "1", c.negTTL).Err()
+	return c.do(ctx, "set_miss", func() error {
+		return c.client.Set(ctx, c.missKey(code), "1", c.negTTL).Err()
+	})
+}
+
+// do runs a redirect-path Redis call through the circuit breaker.
+// Returns ErrUnavailable without calling Redis while the breaker is open.
+func (c *Cache) do(ctx context.Context, op string, fn func() error) error {
+	if !c.breaker.Allow() {
+		return ErrUnavailable
+	}
+	err := fn()
+	switch {
+	case err == nil || err == redis.Nil:
+		c.breaker.Success()
+	case ctx.Err() != nil:
+		// Caller gave up; says nothing about Redis health
+	default:
+		c.breaker.Failure()
+		metrics.CacheErrors.WithLabelValues(op).Inc()
+	}
+	return err
+}
+
+// Breaker returns the Redis circuit breaker shared with other Redis users.
+func (c *Cache) Breaker() *Breaker {
+	return c.breaker
 }
 
 // Client returns the underlying redis client for stream operations.
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Visitors keeps approximate unique visitor counts as one HyperLogLog per
+// scope and UTC day: {prefix}:uv:{scope}:{YYYY-MM-DD}. Scopes are a link
+// ("l:<code>"), a link owner ("u:<id>") and all links ("all").
+type Visitors struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func NewVisitors(client *redis.Client, prefix string, ttl time.Duration) *Visitors {
+	return &Visitors{client: client, prefix: prefix, ttl: ttl}
+}
+
+// VisitorHit is one visitor seen on a link.
+type VisitorHit struct {
+	Code    string
+	UserID  *int // link owner, nil if none
+	Day     time.Time
+	Visitor string
+}
+
+// LinkScope is the scope for a single link.
+func LinkScope(code string) string {
+	return "l:" + code
+}
+
+// UserScope is the scope for a user's links; nil (admin) means all links.
+func UserScope(userID *int) string {
+	if userID == nil {
+		return "all"
+	}
+	return "u:" + strconv.Itoa(*userID)
+}
+
+func (v *Visitors) key(scope string, day time.Time) string {
+	return v.prefix + ":uv:" + scope + ":" + day.UTC().Format("2006-01-02")
+}
+
+// Add records hits in every scope they belong to.
+func (v *Visitors) Add(ctx context.Context, hits []VisitorHit) error {
+	members := make(map[string][]interface{})
+	for _, h := range hits {
+		if h.Visitor == "" {
+			continue
+		}
+		keys := []string{v.key(LinkScope(h.Code), h.Day), v.key("all", h.Day)}
+		if h.UserID != nil {
+			keys = append(keys, v.key(UserScope(h.UserID), h.Day))
+		}
+		for _, k := range keys {
+			members[k] = append(members[k], h.Visitor)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	pipe := v.client.Pipeline()
+	for k, m := range members {
+		pipe.PFAdd(ctx, k, m...)
+		if v.ttl > 0 {
+			pipe.Expire(ctx, k, v.ttl)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// CountDays returns the unique visitors of scope on each day.
+func (v *Visitors) CountDays(ctx context.Context, scope string, days []time.Time) ([]int64, error) {
+	pipe := v.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(days))
+	for i, d := range days {
+		cmds[i] = pipe.PFCount(ctx, v.key(scope, d))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(days))
+	for i, cmd := range cmds {
+		counts[i] = cmd.Val()
+	}
+	return counts, nil
+}
+
+// CountVisitorDays returns the sum of the daily unique visitors of scope
+// over the last n days (including today). Visitor IDs use a daily salt, so
+// a visitor returning on another day cannot be told apart from a new one:
+// this counts visitor-days, not distinct people.
+func (v *Visitors) CountVisitorDays(ctx context.Context, scope string, n int) (int64, error) {
+	tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	return v.CountVisitorDaysBefore(ctx, scope, tomorrow, n)
+}
+
+// CountVisitorDaysBefore is CountVisitorDays over the n UTC days before the
+// day of end.
+func (v *Visitors) CountVisitorDaysBefore(ctx context.Context, scope string, end time.Time, n int) (int64, error) {
+	days := make([]time.Time, 0, n)
+	end = end.UTC().Truncate(24 * time.Hour)
+	for i := 1; i <= n; i++ {
+		days = append(days, end.AddDate(0, 0, -i))
+	}
+	counts, err := v.CountDays(ctx, scope, days)
+	if err != nil {
+		return 0, err
+	}
+	var sum int64
+	for _, c := range counts {
+		sum += c
+	}
+	return sum, nil
+}
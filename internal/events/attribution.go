@@ -0,0 +1,36 @@
+package events
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCampaignLen bounds stored UTM values; they come straight from the
+// query string of the short URL.
+const maxCampaignLen = 100
+
+// RefererHost returns the lowercase host of a Referer header without a
+// leading "www.", or "" if it is empty or not an absolute URL.
+func RefererHost(referer string) string {
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// CampaignValue normalizes a utm_* query parameter: trimmed, lowercase and
+// at most maxCampaignLen characters, so "Newsletter" and "newsletter " group
+// together.
+func CampaignValue(v string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if utf8.RuneCountInString(v) <= maxCampaignLen {
+		return v
+	}
+	return string([]rune(v)[:maxCampaignLen])
+}
@@ -0,0 +1,44 @@
+package events
+
+import (
+	"regexp"
+	"strings"
+)
+
+// botPatterns are lowercase User-Agent substrings of crawlers, link preview
+// fetchers, monitors and HTTP libraries that mssola/useragent does not flag.
+// They name products, not words: in-app browsers mention their app
+// ("[Pinterest/iOS]", "WhatsApp") and phone models can contain "bot" (CUBOT).
+var botPatterns = []string{
+	"crawler", "spider", "slurp",
+	// Link unfurlers
+	"facebookexternalhit", "facebookcatalog", "twitterbot", "slackbot", "slack-imgproxy",
+	"discordbot", "telegrambot", "whatsapp/", "linkedinbot", "skypeuripreview",
+	"embedly", "pinterestbot", "redditbot", "vkshare", "iframely", "bingpreview",
+	// Uptime monitors and checkers
+	"uptimerobot", "pingdom", "statuscake", "site24x7", "betteruptime",
+	"newrelicpinger", "datadog", "chrome-lighthouse", "headlesschrome",
+	// HTTP clients and libraries
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "go-http-client",
+	"okhttp", "java/", "apache-httpclient", "node-fetch", "axios/", "libwww-perl",
+	"httpie", "postmanruntime",
+}
+
+// botToken matches generic crawler names: "bot" as a word or ending a
+// product token ("Googlebot/2.1", "PetalBot;"), but not inside a word.
+var botToken = regexp.MustCompile(`\bbot\b|bot[/;]`)
+
+// IsBot reports whether a click looks automated: an empty User-Agent, a
+// known bot pattern, or no Accept-Language header, which every browser sends.
+func IsBot(ua, acceptLanguage string) bool {
+	if ua == "" || acceptLanguage == "" {
+		return true
+	}
+	ua = strings.ToLower(ua)
+	for _, p := range botPatterns {
+		if strings.Contains(ua, p) {
+			return true
+		}
+	}
+	return botToken.MatchString(ua)
+}
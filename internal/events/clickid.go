@@ -0,0 +1,98 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+const (
+	clickIDRandLen = 12 // random part of a click ID in bytes
+	clickIDMACLen  = 12 // truncated HMAC-SHA256 in bytes
+)
+
+// ClickIDs makes and verifies click IDs, signed with a server secret so
+// conversions can only be reported for IDs handed out on redirects. A nil
+// ClickIDs accepts no IDs.
+type ClickIDs struct {
+	secret []byte
+}
+
+func NewClickIDs(secret string) *ClickIDs {
+	return &ClickIDs{secret: []byte(secret)}
+}
+
+// New returns a click ID for a redirect of code: "<code>.<24 hex>.<24 hex
+// HMAC>". The code is embedded so conversions can be attributed before the
+// click itself has been persisted.
+func (k *ClickIDs) New(code string) string {
+	b := make([]byte, clickIDRandLen)
+	rand.Read(b)
+	body := code + "." + hex.EncodeToString(b)
+	return body + "." + hex.EncodeToString(k.mac(body))
+}
+
+// Parse returns the link code of a click ID, or false if id was not made by
+// New with the same secret.
+func (k *ClickIDs) Parse(id string) (string, bool) {
+	if k == nil {
+		return "", false
+	}
+	body, sig, ok := cutLast(id, ".")
+	if !ok || len(sig) != 2*clickIDMACLen {
+		return "", false
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, k.mac(body)) {
+		return "", false
+	}
+
+	code, random, ok := strings.Cut(body, ".")
+	if !ok || code == "" || len(random) != 2*clickIDRandLen {
+		return "", false
+	}
+	for _, c := range code {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return "", false
+		}
+	}
+	if _, err := hex.DecodeString(random); err != nil {
+		return "", false
+	}
+	return code, true
+}
+
+func (k *ClickIDs) mac(body string) []byte {
+	m := hmac.New(sha256.New, k.secret)
+	m.Write([]byte(body))
+	return m.Sum(nil)[:clickIDMACLen]
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// AppendQueryParam adds key=value to the query of rawURL, keeping the
+// existing query as is (signed URLs break if it is re-encoded) and any
+// fragment last.
+func AppendQueryParam(rawURL, key, value string) string {
+	fragment := ""
+	if i := strings.IndexByte(rawURL, '#'); i >= 0 {
+		rawURL, fragment = rawURL[:i], rawURL[i:]
+	}
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+		if strings.HasSuffix(rawURL, "?") || strings.HasSuffix(rawURL, "&") {
+			sep = ""
+		}
+	}
+	return rawURL + sep + url.QueryEscape(key) + "=" + url.QueryEscape(value) + fragment
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClickID(t *testing.T) {
+	ids := NewClickIDs("secret")
+	id := ids.New("abc123")
+	code, ok := ids.Parse(id)
+	if !ok || code != "abc123" {
+		t.Fatalf("Parse(%q) = %q, %v", id, code, ok)
+	}
+	if ids.New("abc123") == id {
+		t.Error("New() returned the same ID twice")
+	}
+
+	const sig = ".0123456789abcdef01234567"
+	for _, bad := range []string{
+		"",
+		"abc123",
+		"abc123.",
+		".0123456789abcdef01234567" + sig,
+		"abc123.0123456789abcdef0123456" + sig,   // short
+		"abc123.0123456789abcdef0123456z" + sig,  // not hex
+		"ab-123.0123456789abcdef01234567" + sig,  // not base62
+		"abc123.0123456789abcdef01234567x" + sig, // long
+		id[:len(id)-1],
+	} {
+		if _, ok := ids.Parse(bad); ok {
+			t.Errorf("Parse(%q) accepted", bad)
+		}
+	}
+}
+
+func TestClickIDRejectsForgery(t *testing.T) {
+	ids := NewClickIDs("secret")
+	id := ids.New("abc123")
+
+	// Another link's code with the same random part and signature
+	body, sig, _ := cutLast(id, ".")
+	_, random, _ := strings.Cut(body, ".")
+	if _, ok := ids.Parse("xyz789." + random + "." + sig); ok {
+		t.Error("accepted an ID moved to another link")
+	}
+	if _, ok := NewClickIDs("other").Parse(id); ok {
+		t.Error("accepted an ID signed with another secret")
+	}
+	var none *ClickIDs
+	if _, ok := none.Parse(id); ok {
+		t.Error("nil ClickIDs accepted an ID")
+	}
+}
+
+func TestAppendQueryParam(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "https://example.com?cid=x"},
+		{"https://example.com/p?a=1", "https://example.com/p?a=1&cid=x"},
+		{"https://example.com/p?", "https://example.com/p?cid=x"},
+		{"https://example.com/p?a=1&", "https://example.com/p?a=1&cid=x"},
+		{"https://example.com/p#top", "https://example.com/p?cid=x#top"},
+		{"https://example.com/p?sig=a%2Fb#s", "https://example.com/p?sig=a%2Fb&cid=x#s"},
+	}
+	for _, tt := range tests {
+		if got := AppendQueryParam(tt.url, "cid", "x"); got != tt.want {
+			t.Errorf("AppendQueryParam(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -2,46 +2,114 @@ package events
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"os"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/wyp0596/go2short/internal/alert"
+	"github.com/wyp0596/go2short/internal/cache"
+	"github.com/wyp0596/go2short/internal/geo"
 	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
 	"github.com/wyp0596/go2short/internal/store"
 )
 
+// flushBackoff is the wait before each retry of a failed batch insert.
+var flushBackoff = []time.Duration{200 * time.Millisecond, 1 * time.Second, 5 * time.Second}
+
+const (
+	// shutdownTimeout bounds the final flush and pending handoff on Stop.
+	shutdownTimeout = 10 * time.Second
+	// staleConsumerIdle is how long a consumer with nothing pending may be
+	// idle before it is removed from the group (e.g. after a crash).
+	staleConsumerIdle = time.Hour
+)
+
+// ConsumerName returns name, or if empty a per-instance name built from the
+// hostname and a random suffix, so replicas never share a consumer.
+func ConsumerName(name string) string {
+	if name != "" {
+		return name
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// ReclaimConfig controls recovery of unacknowledged stream entries.
+type ReclaimConfig struct {
+	Interval      time.Duration // how often to run XAUTOCLAIM
+	MinIdle       time.Duration // entries idle this long are considered abandoned
+	MaxDeliveries int64         // entries delivered more often are dead-lettered
+}
+
+// Consumer reads click events from the stream and bulk inserts them.
+// Entries are acknowledged only after their batch is persisted
+// (at-least-once); failed entries stay pending and are reclaimed later.
 type Consumer struct {
 	client        *redis.Client
 	store         *store.Store
+	deadLetters   *DeadLetters
+	visitors      *cache.Visitors
+	geo           *geo.Resolver
+	alerts        *alert.Evaluator // nil = no alert rules
 	streamName    string
 	groupName     string
 	consumerName  string
 	batchSize     int
 	flushInterval time.Duration
+	reclaim       ReclaimConfig
 	buffer        []store.ClickEvent
+	ids           []string // stream IDs of buffered events, same order
+	dbDown        bool     // the last batch failed entirely; reclaim waits for the database
 	stopCh        chan struct{}
+	done          chan struct{}
 }
 
 func NewConsumer(
 	client *redis.Client,
 	s *store.Store,
+	dl *DeadLetters,
+	visitors *cache.Visitors,
+	geo *geo.Resolver,
+	alerts *alert.Evaluator,
 	streamName, groupName, consumerName string,
 	batchSize int,
 	flushInterval time.Duration,
+	reclaim ReclaimConfig,
 ) *Consumer {
 	return &Consumer{
 		client:        client,
 		store:         s,
+		deadLetters:   dl,
+		visitors:      visitors,
+		geo:           geo,
+		alerts:        alerts,
 		streamName:    streamName,
 		groupName:     groupName,
 		consumerName:  consumerName,
 		batchSize:     batchSize,
 		flushInterval: flushInterval,
+		reclaim:       reclaim,
 		buffer:        make([]store.ClickEvent, 0, batchSize),
+		ids:           make([]string, 0, batchSize),
 		stopCh:        make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 }
 
+// Name returns the consumer name within the group.
+func (c *Consumer) Name() string {
+	return c.consumerName
+}
+
 func (c *Consumer) Start(ctx context.Context) error {
 	// Create consumer group if not exists
 	err := c.client.XGroupCreateMkStream(ctx, c.streamName, c.groupName, "0").Err()
@@ -53,24 +121,37 @@ func (c *Consumer) Start(ctx context.Context) error {
 	return nil
 }
 
+// Stop flushes the buffer, hands off pending entries and waits for the
+// consumer to exit. Safe to call after the Start context is cancelled.
 func (c *Consumer) Stop() {
-	close(c.stopCh)
+	select {
+	case <-c.stopCh:
+	default:
+		close(c.stopCh)
+	}
+	<-c.done
 }
 
 func (c *Consumer) run(ctx context.Context) {
+	defer close(c.done)
 	ticker := time.NewTicker(c.flushInterval)
 	defer ticker.Stop()
+	reclaimTicker := time.NewTicker(c.reclaim.Interval)
+	defer reclaimTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			c.flush(context.Background()) // flush remaining on shutdown
+			c.shutdown()
 			return
 		case <-c.stopCh:
-			c.flush(context.Background())
+			c.shutdown()
 			return
 		case <-ticker.C:
 			c.flush(ctx)
+		case <-reclaimTicker.C:
+			c.reclaimPending(ctx)
+			c.pruneConsumers(ctx)
 		default:
 			c.consume(ctx)
 		}
@@ -95,32 +176,7 @@ func (c *Consumer) consume(ctx context.Context) {
 	}
 
 	for _, stream := range streams {
-		for _, msg := range stream.Messages {
-			data, ok := msg.Values["data"].(string)
-			if !ok {
-				continue
-			}
-
-			var event ClickEvent
-			if err := json.Unmarshal([]byte(data), &event); err != nil {
-				logger.Error("failed to unmarshal event", logger.Err(err))
-				continue
-			}
-
-			c.buffer = append(c.buffer, store.ClickEvent{
-				Code:       event.Code,
-				Timestamp:  event.Timestamp,
-				IP:         event.IP,
-				UA:         event.UA,
-				DeviceType: event.DeviceType,
-				Browser:    event.Browser,
-				OS:         event.OS,
-				Referer:    event.Referer,
-			})
-
-			// ACK message
-			c.client.XAck(ctx, c.streamName, c.groupName, msg.ID)
-		}
+		c.add(ctx, stream.Messages)
 	}
 
 	if len(c.buffer) >= c.batchSize {
@@ -128,16 +184,375 @@ func (c *Consumer) consume(ctx context.Context) {
 	}
 }
 
+// add decodes messages into the buffer. Events enqueued without a location
+// (the producer had no GeoIP database) are resolved from the IP as stored
+// after the privacy policy. Undecodable messages are dead-lettered.
+func (c *Consumer) add(ctx context.Context, msgs []redis.XMessage) {
+	for _, msg := range msgs {
+		event, err := decodeMessage(msg)
+		if err != nil {
+			c.deadLetter(ctx, msg, "decode: "+err.Error())
+			continue
+		}
+		if event.Country == "" {
+			event.Country, event.Region, event.City = c.geo.Lookup(event.IP)
+		}
+		c.buffer = append(c.buffer, event)
+		c.ids = append(c.ids, msg.ID)
+	}
+}
+
+func decodeMessage(msg redis.XMessage) (store.ClickEvent, error) {
+	data, ok := msg.Values["data"].(string)
+	if !ok {
+		return store.ClickEvent{}, errMissingData
+	}
+
+	var event ClickEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return store.ClickEvent{}, err
+	}
+
+	return store.ClickEvent{
+		Code:        event.Code,
+		Timestamp:   event.Timestamp,
+		IP:          event.IP,
+		UA:          event.UA,
+		DeviceType:  event.DeviceType,
+		Browser:     event.Browser,
+		OS:          event.OS,
+		Referer:     event.Referer,
+		RefererHost: event.RefHost,
+		UTMSource:   event.UTMSource,
+		UTMMedium:   event.UTMMedium,
+		UTMCampaign: event.UTMCampaign,
+		IsBot:       event.Bot,
+		ClickID:     event.ClickID,
+		Country:     event.Country,
+		Region:      event.Region,
+		City:        event.City,
+		VisitorID:   event.Visitor,
+	}, nil
+}
+
+// flush persists the buffer and acknowledges it, then evaluates alert
+// rules. On failure it retries with backoff; if the batch still fails, rows
+// that fail on their own are dead-lettered and the rest stay pending for
+// reclaim.
 func (c *Consumer) flush(ctx context.Context) {
 	if len(c.buffer) == 0 {
+		c.alerts.Observe(ctx, nil) // rules throttled on earlier batches
 		return
 	}
 
 	events := make([]store.ClickEvent, len(c.buffer))
 	copy(events, c.buffer)
+	ids := make([]string, len(c.ids))
+	copy(ids, c.ids)
 	c.buffer = c.buffer[:0]
+	c.ids = c.ids[:0]
+
+	err := c.store.InsertClickEvents(ctx, events)
+	for _, wait := range flushBackoff {
+		if err == nil {
+			break
+		}
+		logger.Error("failed to insert click events, retrying", logger.Err(err),
+			logger.Extra("count", len(events)), logger.Extra("retry_in", wait.String()))
+		select {
+		case <-ctx.Done():
+			return // not acked, reclaimed after restart
+		case <-time.After(wait):
+		}
+		err = c.store.InsertClickEvents(ctx, events)
+	}
+	if err == nil {
+		c.dbDown = false
+		metrics.ClickEventsProcessed.Add(float64(len(events)))
+		c.ack(ctx, ids...)
+		c.countVisitors(ctx, events)
+		c.alerts.Observe(ctx, events)
+		return
+	}
+
+	logger.Error("giving up on click batch, isolating failures", logger.Err(err), logger.Extra("count", len(events)))
+	c.isolate(ctx, events, ids)
+}
+
+// isolate inserts events one at a time after a batch failed for good.
+// If none succeed the database is likely down: everything stays pending.
+// Otherwise the events that still fail are poison and are dead-lettered.
+func (c *Consumer) isolate(ctx context.Context, events []store.ClickEvent, ids []string) {
+	var ok, failed []string
+	var persisted []store.ClickEvent
+	failures := make(map[string]error)
+	for i, e := range events {
+		if err := c.store.InsertClickEvents(ctx, []store.ClickEvent{e}); err != nil {
+			failed = append(failed, ids[i])
+			failures[ids[i]] = err
+			continue
+		}
+		ok = append(ok, ids[i])
+		persisted = append(persisted, e)
+	}
+	metrics.ClickEventsFailed.Add(float64(len(failed)))
+	c.dbDown = len(ok) == 0
+	if c.dbDown {
+		return
+	}
+	metrics.ClickEventsProcessed.Add(float64(len(ok)))
+	c.ack(ctx, ok...)
+	c.countVisitors(ctx, persisted)
+	c.alerts.Observe(ctx, persisted)
+
+	for _, id := range failed {
+		msgs, err := c.client.XRange(ctx, c.streamName, id, id).Result()
+		if err != nil || len(msgs) == 0 {
+			logger.Error("failed to load poison click event", logger.Err(err), logger.Extra("id", id))
+			continue
+		}
+		c.deadLetter(ctx, msgs[0], "insert: "+failures[id].Error())
+	}
+}
+
+// countVisitors adds persisted events to the unique visitor sketches.
+// Redelivered events are harmless: adding a visitor twice is a no-op.
+func (c *Consumer) countVisitors(ctx context.Context, events []store.ClickEvent) {
+	if c.visitors == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var codes []string
+	for _, e := range events {
+		if e.VisitorID != "" && !e.IsBot && !seen[e.Code] {
+			seen[e.Code] = true
+			codes = append(codes, e.Code)
+		}
+	}
+	if len(codes) == 0 {
+		return
+	}
+
+	owners, err := c.store.LinkOwners(ctx, codes)
+	if err != nil {
+		logger.Error("failed to load link owners for visitor counts", logger.Err(err))
+	}
+	hits := make([]cache.VisitorHit, 0, len(events))
+	for _, e := range events {
+		if e.IsBot {
+			continue
+		}
+		hit := cache.VisitorHit{Code: e.Code, Day: e.Timestamp, Visitor: e.VisitorID}
+		if uid, ok := owners[e.Code]; ok {
+			hit.UserID = &uid
+		}
+		hits = append(hits, hit)
+	}
+	if err := c.visitors.Add(ctx, hits); err != nil {
+		logger.Error("failed to count unique visitors", logger.Err(err), logger.Extra("count", len(hits)))
+	}
+}
+
+// reclaimPending takes over entries left pending by dead or failed consumers
+// (including this one). Entries delivered too often are dead-lettered.
+// While the database is down nothing is reclaimed: every reclaim counts as
+// a delivery, and an outage must not push valid entries over the limit.
+func (c *Consumer) reclaimPending(ctx context.Context) {
+	if c.dbDown {
+		if err := c.store.Ping(ctx); err != nil {
+			logger.Error("database unavailable, not reclaiming click events", logger.Err(err))
+			return
+		}
+		c.dbDown = false
+	}
+
+	start := "0-0"
+	for {
+		msgs, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.streamName,
+			Group:    c.groupName,
+			Consumer: c.consumerName,
+			MinIdle:  c.reclaim.MinIdle,
+			Start:    start,
+			Count:    int64(c.batchSize),
+		}).Result()
+		if err != nil {
+			logger.Error("XAutoClaim error", logger.Err(err))
+			return
+		}
+		if len(msgs) > 0 {
+			metrics.ClickEventsReclaimed.Add(float64(len(msgs)))
+			c.addReclaimed(ctx, msgs)
+			c.flush(ctx)
+		}
+		if next == "0-0" || len(msgs) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// addReclaimed buffers reclaimed messages, dead-lettering those that have
+// exceeded the delivery limit.
+func (c *Consumer) addReclaimed(ctx context.Context, msgs []redis.XMessage) {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   c.streamName,
+		Group:    c.groupName,
+		Start:    msgs[0].ID,
+		End:      msgs[len(msgs)-1].ID,
+		Count:    int64(len(msgs)),
+		Consumer: c.consumerName,
+	}).Result()
+	if err != nil {
+		logger.Error("XPending error", logger.Err(err))
+		c.add(ctx, msgs)
+		return
+	}
+
+	deliveries := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		deliveries[p.ID] = p.RetryCount
+	}
+
+	retry := msgs[:0:0]
+	for _, msg := range msgs {
+		if c.reclaim.MaxDeliveries > 0 && deliveries[msg.ID] > c.reclaim.MaxDeliveries {
+			c.deadLetter(ctx, msg, "max deliveries exceeded")
+			continue
+		}
+		retry = append(retry, msg)
+	}
+	c.add(ctx, retry)
+}
+
+// deadLetter moves a message to the dead-letter stream and acknowledges it.
+// If the move fails the message stays pending and is retried on reclaim.
+func (c *Consumer) deadLetter(ctx context.Context, msg redis.XMessage, reason string) {
+	if err := c.deadLetters.Add(ctx, msg, reason); err != nil {
+		logger.Error("failed to dead-letter click event", logger.Err(err), logger.Extra("id", msg.ID))
+		return
+	}
+	logger.Error("click event dead-lettered", logger.Extra("id", msg.ID), logger.Extra("reason", reason))
+	metrics.ClickEventsDeadLettered.Inc()
+	c.ack(ctx, msg.ID)
+}
+
+func (c *Consumer) ack(ctx context.Context, ids ...string) {
+	if err := c.client.XAck(ctx, c.streamName, c.groupName, ids...).Err(); err != nil {
+		// Entries will be redelivered via reclaim (at-least-once)
+		logger.Error("XAck error", logger.Err(err), logger.Extra("count", len(ids)))
+	}
+}
+
+// shutdown flushes the buffer and hands off whatever is still pending.
+func (c *Consumer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	c.flush(ctx)
+	c.handoff(ctx)
+}
+
+// handoff passes entries still pending for this consumer to the most
+// recently active consumer in the group, then leaves the group. With no
+// other consumer the entries stay pending and are reclaimed on next start.
+func (c *Consumer) handoff(ctx context.Context) {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   c.streamName,
+		Group:    c.groupName,
+		Start:    "-",
+		End:      "+",
+		Count:    int64(c.batchSize),
+		Consumer: c.consumerName,
+	}).Result()
+	if err != nil {
+		logger.Error("XPending error on shutdown", logger.Err(err))
+		return
+	}
 
-	if err := c.store.InsertClickEvents(ctx, events); err != nil {
-		logger.Error("failed to insert click events", logger.Err(err), logger.Extra("count", len(events)))
+	for len(pending) > 0 {
+		successor, err := c.successor(ctx)
+		if err != nil {
+			logger.Error("failed to find consumer for handoff", logger.Err(err))
+			return
+		}
+		if successor == "" {
+			logger.Info("no other consumer, leaving pending click events for reclaim",
+				logger.Extra("consumer", c.consumerName), logger.Extra("count", len(pending)))
+			return
+		}
+
+		ids := make([]string, len(pending))
+		for i, p := range pending {
+			ids[i] = p.ID
+		}
+		if err := c.client.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   c.streamName,
+			Group:    c.groupName,
+			Consumer: successor,
+			Messages: ids,
+		}).Err(); err != nil {
+			logger.Error("XClaim error on shutdown", logger.Err(err))
+			return
+		}
+		logger.Info("handed off pending click events",
+			logger.Extra("from", c.consumerName), logger.Extra("to", successor), logger.Extra("count", len(ids)))
+
+		pending, err = c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   c.streamName,
+			Group:    c.groupName,
+			Start:    "-",
+			End:      "+",
+			Count:    int64(c.batchSize),
+			Consumer: c.consumerName,
+		}).Result()
+		if err != nil {
+			logger.Error("XPending error on shutdown", logger.Err(err))
+			return
+		}
+	}
+
+	// Nothing pending: leaving the group loses no entries
+	if err := c.client.XGroupDelConsumer(ctx, c.streamName, c.groupName, c.consumerName).Err(); err != nil {
+		logger.Error("failed to remove consumer from group", logger.Err(err))
+	}
+}
+
+// successor returns the most recently active other consumer, or "".
+func (c *Consumer) successor(ctx context.Context) (string, error) {
+	consumers, err := c.client.XInfoConsumers(ctx, c.streamName, c.groupName).Result()
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	var idle time.Duration
+	for _, ci := range consumers {
+		if ci.Name == c.consumerName || ci.Idle > c.reclaim.MinIdle {
+			continue // self, or probably dead
+		}
+		if name == "" || ci.Idle < idle {
+			name, idle = ci.Name, ci.Idle
+		}
+	}
+	return name, nil
+}
+
+// pruneConsumers removes consumers that crashed without leaving the group.
+// Only consumers with no pending entries are removed.
+func (c *Consumer) pruneConsumers(ctx context.Context) {
+	consumers, err := c.client.XInfoConsumers(ctx, c.streamName, c.groupName).Result()
+	if err != nil {
+		logger.Error("XInfoConsumers error", logger.Err(err))
+		return
+	}
+
+	for _, ci := range consumers {
+		if ci.Name == c.consumerName || ci.Pending > 0 || ci.Idle < staleConsumerIdle {
+			continue
+		}
+		if err := c.client.XGroupDelConsumer(ctx, c.streamName, c.groupName, ci.Name).Err(); err != nil {
+			logger.Error("failed to prune consumer", logger.Err(err), logger.Extra("consumer", ci.Name))
+		}
 	}
 }
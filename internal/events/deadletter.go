@@ -0,0 +1,124 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var errMissingData = errors.New("missing data field")
+
+// DeadLetter is a click event that could not be processed.
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	SourceID string    `json:"source_id"` // ID in the click stream
+	Reason   string    `json:"reason"`
+	FailedAt time.Time `json:"failed_at"`
+	Data     string    `json:"data"` // raw event payload
+}
+
+// DeadLetters is the dead-letter stream for poison click events. Both it
+// and the click stream are trimmed to about maxLen entries, like the producer
+// does, so replays cannot grow the click stream past its limit.
+type DeadLetters struct {
+	client       *redis.Client
+	streamName   string
+	sourceStream string
+	maxLen       int64 // approximate MAXLEN, 0 = unbounded
+}
+
+func NewDeadLetters(client *redis.Client, streamName, sourceStream string, maxLen int64) *DeadLetters {
+	return &DeadLetters{
+		client:       client,
+		streamName:   streamName,
+		sourceStream: sourceStream,
+		maxLen:       maxLen,
+	}
+}
+
+// Add copies a click stream message to the dead-letter stream.
+func (d *DeadLetters) Add(ctx context.Context, msg redis.XMessage, reason string) error {
+	data, _ := msg.Values["data"].(string)
+	return d.client.XAdd(ctx, trimmedXAdd(d.streamName, d.maxLen, map[string]interface{}{
+		"data":      data,
+		"source_id": msg.ID,
+		"reason":    reason,
+		"failed_at": time.Now().UTC().Format(time.RFC3339),
+	})).Err()
+}
+
+// List returns up to limit dead letters, newest first, and the total count.
+func (d *DeadLetters) List(ctx context.Context, limit int64) ([]DeadLetter, int64, error) {
+	total, err := d.client.XLen(ctx, d.streamName).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	msgs, err := d.client.XRevRangeN(ctx, d.streamName, "+", "-", limit).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	letters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, toDeadLetter(msg))
+	}
+	return letters, total, nil
+}
+
+// Replay moves dead letters back to the click stream. Empty ids replays up
+// to limit of the oldest entries. Returns the number replayed.
+func (d *DeadLetters) Replay(ctx context.Context, ids []string, limit int64) (int, error) {
+	var msgs []redis.XMessage
+	if len(ids) == 0 {
+		var err error
+		msgs, err = d.client.XRangeN(ctx, d.streamName, "-", "+", limit).Result()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		for _, id := range ids {
+			found, err := d.client.XRange(ctx, d.streamName, id, id).Result()
+			if err != nil {
+				return 0, err
+			}
+			msgs = append(msgs, found...)
+		}
+	}
+
+	replayed := 0
+	for _, msg := range msgs {
+		data, _ := msg.Values["data"].(string)
+		_, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAdd(ctx, trimmedXAdd(d.sourceStream, d.maxLen, map[string]interface{}{"data": data}))
+			pipe.XDel(ctx, d.streamName, msg.ID)
+			return nil
+		})
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// Delete removes dead letters. Returns the number removed.
+func (d *DeadLetters) Delete(ctx context.Context, ids ...string) (int64, error) {
+	return d.client.XDel(ctx, d.streamName, ids...).Result()
+}
+
+func toDeadLetter(msg redis.XMessage) DeadLetter {
+	str := func(key string) string {
+		v, _ := msg.Values[key].(string)
+		return v
+	}
+	failedAt, _ := time.Parse(time.RFC3339, str("failed_at"))
+	return DeadLetter{
+		ID:       msg.ID,
+		SourceID: str("source_id"),
+		Reason:   str("reason"),
+		FailedAt: failedAt,
+		Data:     str("data"),
+	}
+}
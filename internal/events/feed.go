@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+)
+
+const (
+	feedBlock  = 5 * time.Second // XREAD block, also how fast an idle reader stops
+	feedCount  = 500             // max entries per XREAD
+	feedBuffer = 256             // events queued per subscriber before dropping
+)
+
+var (
+	ErrFeedFull   = errors.New("too many live feed subscribers")
+	ErrFeedClosed = errors.New("live feed closed")
+)
+
+// Feed fans new click events out to live subscribers. While anyone is
+// subscribed, a single reader follows the stream with plain XREAD, outside
+// the consumer group, so neither redirects nor the consumer do extra work.
+// Subscribers that fall behind miss events rather than slow the others.
+type Feed struct {
+	client     *redis.Client
+	streamName string
+	maxSubs    int // 0 = unlimited
+
+	mu     sync.Mutex
+	subs   map[chan ClickEvent]struct{}
+	stop   context.CancelFunc // stops the reader, nil while idle
+	closed bool
+}
+
+func NewFeed(client *redis.Client, streamName string, maxSubs int) *Feed {
+	return &Feed{
+		client:     client,
+		streamName: streamName,
+		maxSubs:    maxSubs,
+		subs:       make(map[chan ClickEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel of click events added to the stream from now
+// on and a function to unsubscribe. The channel is closed on unsubscribe or
+// when the feed is closed.
+func (f *Feed) Subscribe() (<-chan ClickEvent, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, nil, ErrFeedClosed
+	}
+	if f.maxSubs > 0 && len(f.subs) >= f.maxSubs {
+		return nil, nil, ErrFeedFull
+	}
+
+	ch := make(chan ClickEvent, feedBuffer)
+	f.subs[ch] = struct{}{}
+	metrics.LiveFeedSubscribers.Set(float64(len(f.subs)))
+	if f.stop == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		f.stop = cancel
+		go f.read(ctx)
+	}
+	return ch, func() { f.unsubscribe(ch) }, nil
+}
+
+func (f *Feed) unsubscribe(ch chan ClickEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[ch]; !ok {
+		return // already closed by Close
+	}
+	delete(f.subs, ch)
+	close(ch)
+	metrics.LiveFeedSubscribers.Set(float64(len(f.subs)))
+	if len(f.subs) == 0 && f.stop != nil {
+		f.stop()
+		f.stop = nil
+	}
+}
+
+// Close stops the reader and closes every subscriber channel, ending their
+// streams so the HTTP server can shut down.
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.stop != nil {
+		f.stop()
+		f.stop = nil
+	}
+	for ch := range f.subs {
+		close(ch)
+	}
+	f.subs = make(map[chan ClickEvent]struct{})
+	metrics.LiveFeedSubscribers.Set(0)
+}
+
+// read follows the stream from its current end until ctx is done. Entries
+// added while Redis is unreachable are skipped once it is back.
+func (f *Feed) read(ctx context.Context) {
+	lastID := "$"
+	for ctx.Err() == nil {
+		streams, err := f.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{f.streamName, lastID},
+			Count:   feedCount,
+			Block:   feedBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("live feed XRead error", logger.Err(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				lastID = msg.ID
+				data, _ := msg.Values["data"].(string)
+				var event ClickEvent
+				if err := json.Unmarshal([]byte(data), &event); err != nil {
+					continue // the consumer dead-letters it
+				}
+				f.publish(ctx, event)
+			}
+		}
+	}
+}
+
+// publish hands event to every subscriber, unless ctx's reader has been
+// stopped and another may already have taken over.
+func (f *Feed) publish(ctx context.Context, event ClickEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- event:
+		default:
+			metrics.LiveFeedDropped.Inc()
+		}
+	}
+}
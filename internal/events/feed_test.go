@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestFeed returns a feed whose reader cannot connect; tests publish directly.
+func newTestFeed(maxSubs int) *Feed {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 10 * time.Millisecond, MaxRetries: -1})
+	return NewFeed(client, "test:clicks", maxSubs)
+}
+
+func TestFeedFanOut(t *testing.T) {
+	f := newTestFeed(2)
+	defer f.Close()
+
+	a, unsubA, err := f.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, err := f.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Subscribe(); !errors.Is(err, ErrFeedFull) {
+		t.Fatalf("third subscribe: err = %v, want ErrFeedFull", err)
+	}
+
+	f.publish(context.Background(), ClickEvent{Code: "abc"})
+	for _, ch := range []<-chan ClickEvent{a, b} {
+		if e := <-ch; e.Code != "abc" {
+			t.Errorf("got %q, want abc", e.Code)
+		}
+	}
+
+	unsubA()
+	if _, ok := <-a; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+	unsubA() // idempotent
+	if _, _, err := f.Subscribe(); err != nil {
+		t.Errorf("subscribe after unsubscribe: %v", err)
+	}
+}
+
+func TestFeedDropsForSlowSubscribers(t *testing.T) {
+	f := newTestFeed(0)
+	defer f.Close()
+
+	ch, _, err := f.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < feedBuffer+10; i++ {
+		f.publish(context.Background(), ClickEvent{Code: "abc"})
+	}
+	if len(ch) != feedBuffer {
+		t.Errorf("queued %d, want %d", len(ch), feedBuffer)
+	}
+}
+
+func TestFeedClose(t *testing.T) {
+	f := newTestFeed(0)
+	ch, unsubscribe, err := f.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+	for range ch {
+	}
+	unsubscribe() // after Close: no double close
+	if _, _, err := f.Subscribe(); !errors.Is(err, ErrFeedClosed) {
+		t.Errorf("subscribe after close: err = %v, want ErrFeedClosed", err)
+	}
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+)
+
+// LagSampler periodically exports click stream backlog metrics.
+type LagSampler struct {
+	client     *redis.Client
+	streamName string
+	groupName  string
+	interval   time.Duration
+}
+
+func NewLagSampler(client *redis.Client, streamName, groupName string, interval time.Duration) *LagSampler {
+	return &LagSampler{
+		client:     client,
+		streamName: streamName,
+		groupName:  groupName,
+		interval:   interval,
+	}
+}
+
+// Start samples until ctx is done.
+func (l *LagSampler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		for {
+			l.sample(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (l *LagSampler) sample(ctx context.Context) {
+	length, err := l.client.XLen(ctx, l.streamName).Result()
+	if err != nil {
+		logger.Error("XLen error", logger.Err(err))
+		return
+	}
+	metrics.StreamLength.Set(float64(length))
+
+	groups, err := l.client.XInfoGroups(ctx, l.streamName).Result()
+	if err != nil {
+		logger.Error("XInfoGroups error", logger.Err(err))
+		return
+	}
+	for _, g := range groups {
+		if g.Name != l.groupName {
+			continue
+		}
+		// -1 when Redis cannot compute it (entries deleted mid-stream)
+		if g.Lag >= 0 {
+			metrics.StreamLag.Set(float64(g.Lag))
+		}
+	}
+
+	pending, err := l.client.XPending(ctx, l.streamName, l.groupName).Result()
+	if err != nil {
+		logger.Error("XPending error", logger.Err(err))
+		return
+	}
+	metrics.StreamPending.Set(float64(pending.Count))
+
+	var age float64
+	if pending.Count > 0 {
+		if ts, ok := idTime(pending.Lower); ok {
+			age = time.Since(ts).Seconds()
+		}
+	}
+	metrics.StreamOldestPendingAge.Set(age)
+}
+
+// idTime returns the creation time encoded in a stream ID ("<ms>-<seq>").
+func idTime(id string) (time.Time, bool) {
+	ms, _, _ := strings.Cut(id, "-")
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(n), true
+}
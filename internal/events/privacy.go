@@ -0,0 +1,226 @@
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wyp0596/go2short/internal/cache"
+)
+
+// PrivacyMode controls how a click's IP or User-Agent is stored.
+type PrivacyMode string
+
+const (
+	PrivacyRaw      PrivacyMode = "raw"      // store as received
+	PrivacyTruncate PrivacyMode = "truncate" // IPv4 /24, IPv6 /48 (IP only)
+	PrivacyHash     PrivacyMode = "hash"     // HMAC-SHA256 with a daily rotating salt
+	PrivacyDrop     PrivacyMode = "drop"     // do not store
+)
+
+// ParsePrivacyMode validates a mode. Truncation only applies to IPs.
+func ParsePrivacyMode(s string, allowTruncate bool) (PrivacyMode, error) {
+	switch m := PrivacyMode(s); m {
+	case PrivacyRaw, PrivacyHash, PrivacyDrop:
+		return m, nil
+	case PrivacyTruncate:
+		if allowTruncate {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("invalid privacy mode %q", s)
+}
+
+// SaltSource returns the hashing salt for a UTC day (YYYY-MM-DD).
+type SaltSource interface {
+	Salt(ctx context.Context, day string) ([]byte, error)
+}
+
+// Privacy applies the configured policy to click events before they are
+// enqueued, so raw IPs and UAs never reach the stream unless allowed.
+type Privacy struct {
+	ipMode   PrivacyMode
+	uaMode   PrivacyMode
+	honorDNT bool
+	salts    SaltSource
+}
+
+func NewPrivacy(ipMode, uaMode PrivacyMode, honorDNT bool, salts SaltSource) *Privacy {
+	return &Privacy{ipMode: ipMode, uaMode: uaMode, honorDNT: honorDNT, salts: salts}
+}
+
+// Tracks reports whether a click may carry identifiers beyond what is
+// counted: not when it sent DNT or GPC and honorDNT is set.
+func (p *Privacy) Tracks(e *ClickEvent) bool {
+	return p == nil || !p.honorDNT || !e.DoNotTrack
+}
+
+// Apply sets e.Visitor and rewrites e.IP and e.UA in place. Clicks from
+// clients sending DNT or GPC are still counted but stored without IP, UA,
+// visitor ID or click ID when honorDNT is set. If the salt is unavailable,
+// hashed fields are dropped rather than stored raw.
+func (p *Privacy) Apply(ctx context.Context, e *ClickEvent) {
+	if p == nil {
+		return
+	}
+	if !p.Tracks(e) {
+		e.IP, e.UA, e.ClickID = "", "", ""
+		return
+	}
+
+	var salt []byte
+	if p.salts != nil {
+		salt, _ = p.salts.Salt(ctx, e.Timestamp.UTC().Format("2006-01-02"))
+	}
+	// Visitor ID for unique counts, independent of what is stored
+	if e.IP != "" || e.UA != "" {
+		e.Visitor = hashValue(salt, "visitor:"+e.IP+"|"+e.UA)
+	}
+	e.IP = p.apply(p.ipMode, e.IP, "ip:", salt)
+	e.UA = p.apply(p.uaMode, e.UA, "ua:", salt)
+}
+
+func (p *Privacy) apply(mode PrivacyMode, v, domain string, salt []byte) string {
+	if v == "" {
+		return ""
+	}
+	switch mode {
+	case PrivacyRaw:
+		return v
+	case PrivacyTruncate:
+		return truncateIP(v)
+	case PrivacyHash:
+		return hashValue(salt, domain+v)
+	default:
+		return ""
+	}
+}
+
+// hashValue returns a truncated HMAC-SHA256 of v, or "" without a salt.
+func hashValue(salt []byte, v string) string {
+	if salt == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// truncateIP zeroes the host part: IPv4 to /24, IPv6 to /48.
+// Unparseable input is dropped.
+func truncateIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+// RedisSalts keeps one random salt per UTC day in Redis, shared by all
+// instances and expired after two days so old hashes cannot be recomputed.
+// While the breaker is open, or the lookup fails, each instance uses a salt
+// of its own for the day, so hashes still match within the instance.
+type RedisSalts struct {
+	client  *redis.Client
+	prefix  string
+	breaker *cache.Breaker
+
+	mu    sync.Mutex
+	salts map[string][]byte // day -> salt, last two days only
+	local map[string][]byte // day -> instance salt used without Redis
+}
+
+func NewRedisSalts(client *redis.Client, prefix string, breaker *cache.Breaker) *RedisSalts {
+	return &RedisSalts{
+		client:  client,
+		prefix:  prefix,
+		breaker: breaker,
+		salts:   make(map[string][]byte),
+		local:   make(map[string][]byte),
+	}
+}
+
+func (r *RedisSalts) Salt(ctx context.Context, day string) ([]byte, error) {
+	r.mu.Lock()
+	salt, ok := r.salts[day]
+	r.mu.Unlock()
+	if ok {
+		return salt, nil
+	}
+
+	if !r.breaker.Allow() {
+		return r.localSalt(day), nil
+	}
+	salt, err := r.shared(ctx, day)
+	if err != nil {
+		r.breaker.Failure()
+		return r.localSalt(day), nil
+	}
+	r.breaker.Success()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.salts[day] = salt
+	r.prune(day)
+	return salt, nil
+}
+
+// shared returns the salt stored in Redis for day. The first instance to
+// ask sets it; everyone reads the winner.
+func (r *RedisSalts) shared(ctx context.Context, day string) ([]byte, error) {
+	key := r.prefix + ":salt:" + day
+	if err := r.client.SetNX(ctx, key, hex.EncodeToString(newSalt()), 48*time.Hour).Err(); err != nil {
+		return nil, err
+	}
+	val, err := r.client.Get(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(val)
+}
+
+// localSalt returns this instance's salt for day, creating it on first use.
+func (r *RedisSalts) localSalt(day string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	salt, ok := r.local[day]
+	if !ok {
+		salt = newSalt()
+		r.local[day] = salt
+		r.prune(day)
+	}
+	return salt
+}
+
+// prune forgets salts of days before day. Callers hold r.mu.
+func (r *RedisSalts) prune(day string) {
+	for _, m := range []map[string][]byte{r.salts, r.local} {
+		for d := range m {
+			if d < day {
+				delete(m, d)
+			}
+		}
+	}
+}
+
+func newSalt() []byte {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return salt
+}
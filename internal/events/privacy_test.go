@@ -0,0 +1,186 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/cache"
+)
+
+type fakeSalts struct {
+	salts map[string][]byte
+	err   error
+}
+
+func (f *fakeSalts) Salt(ctx context.Context, day string) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.salts[day], nil
+}
+
+func newEvent() *ClickEvent {
+	return &ClickEvent{
+		Code:      "abc",
+		Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		IP:        "203.0.113.77",
+		UA:        "Mozilla/5.0",
+	}
+}
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{"::ffff:203.0.113.77", "203.0.113.0"},
+		{"2001:db8:abcd:12::1", "2001:db8:abcd::"},
+		{"not-an-ip", ""},
+	}
+	for _, tt := range tests {
+		if got := truncateIP(tt.ip); got != tt.want {
+			t.Errorf("truncateIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPrivacyModes(t *testing.T) {
+	salts := &fakeSalts{salts: map[string][]byte{
+		"2025-03-01": []byte("day-one"),
+		"2025-03-02": []byte("day-two"),
+	}}
+
+	raw := newEvent()
+	NewPrivacy(PrivacyRaw, PrivacyRaw, false, salts).Apply(context.Background(), raw)
+	if raw.IP != "203.0.113.77" || raw.UA != "Mozilla/5.0" {
+		t.Errorf("raw mode changed event: %+v", raw)
+	}
+
+	truncated := newEvent()
+	NewPrivacy(PrivacyTruncate, PrivacyDrop, false, salts).Apply(context.Background(), truncated)
+	if truncated.IP != "203.0.113.0" || truncated.UA != "" {
+		t.Errorf("truncate/drop: got IP %q UA %q", truncated.IP, truncated.UA)
+	}
+
+	p := NewPrivacy(PrivacyHash, PrivacyHash, false, salts)
+	a, b := newEvent(), newEvent()
+	p.Apply(context.Background(), a)
+	p.Apply(context.Background(), b)
+	if a.IP == "203.0.113.77" || len(a.IP) != 32 {
+		t.Errorf("expected hashed IP, got %q", a.IP)
+	}
+	if a.IP != b.IP || a.UA != b.UA {
+		t.Error("expected same hash for same input on the same day")
+	}
+	if a.IP == a.UA {
+		t.Error("expected IP and UA hashes to differ")
+	}
+
+	nextDay := newEvent()
+	nextDay.Timestamp = nextDay.Timestamp.Add(24 * time.Hour)
+	p.Apply(context.Background(), nextDay)
+	if nextDay.IP == a.IP {
+		t.Error("expected hash to change with the daily salt")
+	}
+}
+
+func TestPrivacyHashWithoutSaltDrops(t *testing.T) {
+	e := newEvent()
+	NewPrivacy(PrivacyHash, PrivacyRaw, false, &fakeSalts{err: errors.New("redis down")}).Apply(context.Background(), e)
+	if e.IP != "" {
+		t.Errorf("expected IP dropped without salt, got %q", e.IP)
+	}
+	if e.UA != "Mozilla/5.0" {
+		t.Errorf("expected raw UA kept, got %q", e.UA)
+	}
+}
+
+func TestRedisSaltsBreakerOpen(t *testing.T) {
+	b := cache.NewBreaker(1, time.Hour)
+	b.Failure()
+	// No client: an open breaker must not reach Redis
+	r := NewRedisSalts(nil, "su", b)
+
+	first, err := r.Salt(context.Background(), "2025-03-10")
+	if err != nil || len(first) != 32 {
+		t.Fatalf("expected a local salt, got %x, %v", first, err)
+	}
+	if again, _ := r.Salt(context.Background(), "2025-03-10"); !bytes.Equal(again, first) {
+		t.Error("expected the same local salt within the day")
+	}
+	if next, _ := r.Salt(context.Background(), "2025-03-11"); bytes.Equal(next, first) {
+		t.Error("expected a new local salt on the next day")
+	}
+	if _, ok := r.local["2025-03-10"]; ok {
+		t.Error("expected the previous day's local salt pruned")
+	}
+}
+
+func TestPrivacyHonorsDNT(t *testing.T) {
+	e := newEvent()
+	e.DoNotTrack = true
+	e.ClickID = NewClickIDs("secret").New(e.Code)
+	p := NewPrivacy(PrivacyRaw, PrivacyRaw, true, nil)
+	if p.Tracks(e) {
+		t.Error("expected DNT click not tracked")
+	}
+	p.Apply(context.Background(), e)
+	if e.IP != "" || e.UA != "" || e.ClickID != "" {
+		t.Errorf("expected IP, UA and click ID stripped, got %+v", e)
+	}
+	if e.Code != "abc" {
+		t.Error("expected click still recorded")
+	}
+
+	ignored := newEvent()
+	ignored.DoNotTrack = true
+	NewPrivacy(PrivacyRaw, PrivacyRaw, false, nil).Apply(context.Background(), ignored)
+	if ignored.IP == "" {
+		t.Error("expected DNT ignored when not honored")
+	}
+}
+
+func TestParsePrivacyMode(t *testing.T) {
+	if _, err := ParsePrivacyMode("truncate", false); err == nil {
+		t.Error("expected truncate rejected for UA")
+	}
+	if m, err := ParsePrivacyMode("truncate", true); err != nil || m != PrivacyTruncate {
+		t.Errorf("ParsePrivacyMode(truncate) = %q, %v", m, err)
+	}
+	if _, err := ParsePrivacyMode("sha1", true); err == nil {
+		t.Error("expected unknown mode rejected")
+	}
+}
+
+func TestPrivacyVisitorID(t *testing.T) {
+	salts := &fakeSalts{salts: map[string][]byte{"2025-03-01": []byte("day-one")}}
+	p := NewPrivacy(PrivacyDrop, PrivacyDrop, true, salts)
+
+	a, b := newEvent(), newEvent()
+	p.Apply(context.Background(), a)
+	p.Apply(context.Background(), b)
+	if a.Visitor == "" || a.Visitor != b.Visitor {
+		t.Errorf("expected stable visitor ID, got %q and %q", a.Visitor, b.Visitor)
+	}
+	if a.IP != "" || a.UA != "" {
+		t.Error("expected IP and UA dropped regardless of visitor ID")
+	}
+
+	other := newEvent()
+	other.UA = "curl/8.0"
+	p.Apply(context.Background(), other)
+	if other.Visitor == a.Visitor {
+		t.Error("expected different visitor for different UA")
+	}
+
+	dnt := newEvent()
+	dnt.DoNotTrack = true
+	p.Apply(context.Background(), dnt)
+	if dnt.Visitor != "" {
+		t.Error("expected no visitor ID for DNT clicks")
+	}
+}
@@ -3,53 +3,238 @@ package events
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/wyp0596/go2short/internal/cache"
+	"github.com/wyp0596/go2short/internal/geo"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
 )
 
+// drainChunk is the max number of buffered events sent per pipeline.
+const drainChunk = 500
+
 type ClickEvent struct {
-	Code       string    `json:"code"`
-	Timestamp  time.Time `json:"ts"`
-	IP         string    `json:"ip"`
-	UA         string    `json:"ua"`
-	DeviceType string    `json:"device_type"`
-	Browser    string    `json:"browser"`
-	OS         string    `json:"os"`
-	Referer    string    `json:"referer"`
-	ReqID      string    `json:"req_id"`
+	Code        string    `json:"code"`
+	Timestamp   time.Time `json:"ts"`
+	IP          string    `json:"ip"`
+	UA          string    `json:"ua"`
+	DeviceType  string    `json:"device_type"`
+	Browser     string    `json:"browser"`
+	OS          string    `json:"os"`
+	Referer     string    `json:"referer"`
+	RefHost     string    `json:"ref_host,omitempty"`
+	UTMSource   string    `json:"utm_source,omitempty"`
+	UTMMedium   string    `json:"utm_medium,omitempty"`
+	UTMCampaign string    `json:"utm_campaign,omitempty"`
+	ReqID       string    `json:"req_id"`
+	Bot         bool      `json:"bot,omitempty"`      // crawler, unfurler, monitor or script
+	Visitor     string    `json:"visitor,omitempty"`  // daily salted visitor ID for unique counts
+	ClickID     string    `json:"click_id,omitempty"` // appended to the long URL for conversions
+	Country     string    `json:"country,omitempty"`  // resolved from the raw IP before the privacy policy
+	Region      string    `json:"region,omitempty"`
+	City        string    `json:"city,omitempty"`
+
+	DoNotTrack bool `json:"-"` // client sent DNT: 1 or Sec-GPC: 1
 }
 
 type Producer struct {
 	client     *redis.Client
 	streamName string
+	maxLen     int64 // approximate MAXLEN, 0 = unbounded
+	breaker    *cache.Breaker
+	privacy    *Privacy      // nil stores IP and UA as received
+	geo        *geo.Resolver // nil leaves location to the consumer
+
+	mu         sync.Mutex
+	pending    []*ClickEvent // buffered while the stream is unavailable
+	maxPending int
 }
 
-func NewProducer(client *redis.Client, streamName string) *Producer {
+func NewProducer(client *redis.Client, streamName string, maxLen int64, breaker *cache.Breaker, privacy *Privacy, geo *geo.Resolver, bufferSize int) *Producer {
 	return &Producer{
 		client:     client,
 		streamName: streamName,
+		maxLen:     maxLen,
+		breaker:    breaker,
+		privacy:    privacy,
+		geo:        geo,
+		maxPending: bufferSize,
 	}
 }
 
-// Enqueue adds a click event to the stream. Non-blocking, fire-and-forget.
-func (p *Producer) Enqueue(ctx context.Context, event *ClickEvent) error {
+// Start drains locally buffered events back into the stream until ctx is done.
+func (p *Producer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if n := p.Buffered(); n > 0 {
+					logger.Error("dropping buffered click events on shutdown", logger.Extra("count", n))
+				}
+				return
+			case <-ticker.C:
+				p.drain(ctx)
+			}
+		}
+	}()
+}
+
+// enqueue adds a click event to the stream as is. Callers go through
+// EnqueueAsync, which applies the privacy policy first.
+func (p *Producer) enqueue(ctx context.Context, event *ClickEvent) error {
 	data, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
 
-	return p.client.XAdd(ctx, &redis.XAddArgs{
-		Stream: p.streamName,
-		Values: map[string]interface{}{"data": string(data)},
-	}).Err()
+	return p.client.XAdd(ctx, p.xaddArgs(data)).Err()
 }
 
 // EnqueueAsync fires event in a goroutine. Does not block caller.
+// The privacy policy is applied first, so raw values are never buffered.
+// If the stream is unavailable the event is buffered locally and retried.
 func (p *Producer) EnqueueAsync(event *ClickEvent) {
 	go func() {
+		pctx, pcancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		p.prepare(pctx, event)
+		pcancel()
+
+		if !p.breaker.Allow() {
+			p.buffer(event)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 		defer cancel()
-		_ = p.Enqueue(ctx, event) // ignore error, non-critical path
+		if err := p.enqueue(ctx, event); err != nil {
+			p.breaker.Failure()
+			p.buffer(event)
+			return
+		}
+		p.breaker.Success()
 	}()
 }
+
+// prepare resolves the location of a tracked click from its raw IP, then
+// applies the privacy policy, so every IP mode keeps the location.
+func (p *Producer) prepare(ctx context.Context, event *ClickEvent) {
+	if p.privacy.Tracks(event) {
+		event.Country, event.Region, event.City = p.geo.Lookup(event.IP)
+	}
+	p.privacy.Apply(ctx, event)
+}
+
+// Buffered returns the number of events waiting for the stream.
+func (p *Producer) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// xaddArgs adds an event payload to the click stream.
+func (p *Producer) xaddArgs(data []byte) *redis.XAddArgs {
+	return trimmedXAdd(p.streamName, p.maxLen, map[string]interface{}{"data": string(data)})
+}
+
+// trimmedXAdd trims the stream approximately (MAXLEN ~), which Redis does in
+// whole macro nodes and is much cheaper than exact trimming. maxLen 0 leaves
+// the stream unbounded.
+func trimmedXAdd(stream string, maxLen int64, values map[string]interface{}) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: maxLen > 0,
+		Values: values,
+	}
+}
+
+// buffer keeps an event in memory, dropping the oldest when full.
+func (p *Producer) buffer(event *ClickEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxPending <= 0 {
+		metrics.ClickEventsDropped.Inc()
+		return
+	}
+	if len(p.pending) >= p.maxPending {
+		p.pending = p.pending[1:]
+		metrics.ClickEventsDropped.Inc()
+	}
+	p.pending = append(p.pending, event)
+	metrics.ClickEventsBuffered.Set(float64(len(p.pending)))
+}
+
+// requeue puts unsent events back in front of newer ones.
+func (p *Producer) requeue(events []*ClickEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(events, p.pending...)
+	if over := len(p.pending) - p.maxPending; over > 0 {
+		p.pending = p.pending[over:]
+		metrics.ClickEventsDropped.Add(float64(over))
+	}
+	metrics.ClickEventsBuffered.Set(float64(len(p.pending)))
+}
+
+// unsent returns the events whose XADD in a pipeline failed. cmds holds one
+// command per event, in order; a missing command counts as failed.
+func unsent(events []*ClickEvent, cmds []redis.Cmder) []*ClickEvent {
+	var failed []*ClickEvent
+	for i, e := range events {
+		if i >= len(cmds) || cmds[i].Err() != nil {
+			failed = append(failed, e)
+		}
+	}
+	return failed
+}
+
+// drain sends buffered events to the stream in chunks.
+func (p *Producer) drain(ctx context.Context) {
+	sent := 0
+	for {
+		p.mu.Lock()
+		n := min(len(p.pending), drainChunk)
+		chunk := p.pending[:n:n]
+		p.pending = p.pending[n:]
+		p.mu.Unlock()
+
+		if n == 0 {
+			break
+		}
+		if !p.breaker.Allow() {
+			p.requeue(chunk)
+			break
+		}
+
+		pipe := p.client.Pipeline()
+		queued := make([]*ClickEvent, 0, n)
+		for _, e := range chunk {
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			pipe.XAdd(ctx, p.xaddArgs(data))
+			queued = append(queued, e)
+		}
+		cmds, err := pipe.Exec(ctx)
+		if err != nil {
+			// Only events whose own XADD failed go back; the rest are in the stream
+			failed := unsent(queued, cmds)
+			p.breaker.Failure()
+			p.requeue(failed)
+			sent += len(queued) - len(failed)
+			break
+		}
+		p.breaker.Success()
+		sent += len(queued)
+	}
+
+	metrics.ClickEventsBuffered.Set(float64(p.Buffered()))
+	if sent > 0 {
+		logger.Info("flushed buffered click events", logger.Extra("count", sent))
+	}
+}
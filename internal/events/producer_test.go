@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wyp0596/go2short/internal/geo"
+)
+
+func TestPrepareResolvesLocationBeforePrivacy(t *testing.T) {
+	resolver, err := geo.Open("../geo/testdata/GeoIP2-City-Test.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Close()
+	salts := &fakeSalts{salts: map[string][]byte{"2025-03-01": []byte("day-one")}}
+
+	for _, mode := range []PrivacyMode{PrivacyRaw, PrivacyTruncate, PrivacyHash, PrivacyDrop} {
+		p := &Producer{privacy: NewPrivacy(mode, PrivacyRaw, true, salts), geo: resolver}
+		e := newEvent()
+		e.IP = "81.2.69.142"
+		p.prepare(context.Background(), e)
+		if e.Country != "GB" || e.Region != "England" || e.City != "London" {
+			t.Errorf("%s: location = %q, %q, %q; want GB, England, London", mode, e.Country, e.Region, e.City)
+		}
+		if mode != PrivacyRaw && e.IP == "81.2.69.142" {
+			t.Errorf("%s: raw IP kept", mode)
+		}
+	}
+
+	// No location for clicks stored without IP
+	p := &Producer{privacy: NewPrivacy(PrivacyRaw, PrivacyRaw, true, salts), geo: resolver}
+	e := newEvent()
+	e.IP = "81.2.69.142"
+	e.DoNotTrack = true
+	p.prepare(context.Background(), e)
+	if e.Country != "" || e.City != "" {
+		t.Errorf("expected no location for a DNT click, got %q, %q", e.Country, e.City)
+	}
+}
+
+func TestTrimmedXAdd(t *testing.T) {
+	args := trimmedXAdd("su:clicks:dead", 1000, map[string]interface{}{"data": "{}"})
+	if args.Stream != "su:clicks:dead" || args.MaxLen != 1000 || !args.Approx {
+		t.Errorf("expected approximate trimming, got %+v", args)
+	}
+	if args := trimmedXAdd("su:clicks", 0, nil); args.MaxLen != 0 || args.Approx {
+		t.Errorf("expected an unbounded stream, got %+v", args)
+	}
+}
+
+func TestUnsent(t *testing.T) {
+	events := []*ClickEvent{{Code: "a"}, {Code: "b"}, {Code: "c"}}
+	ok := redis.NewStringCmd(context.Background())
+	failed := redis.NewStringCmd(context.Background())
+	failed.SetErr(errors.New("OOM command not allowed"))
+
+	got := unsent(events, []redis.Cmder{ok, failed})
+	if len(got) != 2 || got[0].Code != "b" || got[1].Code != "c" {
+		t.Errorf("expected b and c unsent, got %+v", got)
+	}
+	if got := unsent(events, []redis.Cmder{ok, ok, ok}); len(got) != 0 {
+		t.Errorf("expected nothing unsent, got %+v", got)
+	}
+}
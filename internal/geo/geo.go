@@ -0,0 +1,54 @@
+package geo
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Resolver looks up click locations in a local MaxMind-format (mmdb) City
+// database, e.g. GeoLite2-City. A nil Resolver resolves nothing, so callers
+// need not check whether GeoIP is configured.
+type Resolver struct {
+	db *geoip2.Reader
+}
+
+// Open loads the database at path. An empty path returns a nil Resolver.
+func Open(path string) (*Resolver, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{db: db}, nil
+}
+
+// Lookup returns the ISO country code and English region (first
+// subdivision) and city names for ip. Unknown parts are empty, as is
+// everything for IPs that are missing, hashed or not in the database.
+func (r *Resolver) Lookup(ip string) (country, region, city string) {
+	if r == nil {
+		return "", "", ""
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", "", ""
+	}
+	rec, err := r.db.City(addr)
+	if err != nil {
+		return "", "", ""
+	}
+	if len(rec.Subdivisions) > 0 {
+		region = rec.Subdivisions[0].Names["en"]
+	}
+	return rec.Country.IsoCode, region, rec.City.Names["en"]
+}
+
+func (r *Resolver) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.db.Close()
+}
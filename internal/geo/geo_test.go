@@ -0,0 +1,52 @@
+package geo
+
+import "testing"
+
+func TestOpenEmptyPath(t *testing.T) {
+	r, err := Open("")
+	if err != nil || r != nil {
+		t.Fatalf("Open(\"\") = %v, %v; want nil, nil", r, err)
+	}
+	if c, reg, city := r.Lookup("8.8.8.8"); c != "" || reg != "" || city != "" {
+		t.Errorf("nil Lookup = %q, %q, %q; want empty", c, reg, city)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("nil Close: %v", err)
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	if _, err := Open("/nonexistent/GeoLite2-City.mmdb"); err == nil {
+		t.Error("expected error for missing database")
+	}
+}
+
+// testdata/GeoIP2-City-Test.mmdb holds two IPv4 networks:
+// 81.2.69.0/24 (GB, England, London) and 89.160.20.0/24 (SE, no region or city).
+func TestLookup(t *testing.T) {
+	r, err := Open("testdata/GeoIP2-City-Test.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		ip                    string
+		country, region, city string
+	}{
+		{"81.2.69.142", "GB", "England", "London"},
+		{"81.2.69.0", "GB", "England", "London"}, // truncated to /24
+		{"89.160.20.128", "SE", "", ""},
+		{"8.8.8.8", "", "", ""},          // not in the database
+		{"2001:db8::1", "", "", ""},      // IPv6 in an IPv4 database
+		{"3f2a9c0e71b4d855", "", "", ""}, // hashed
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		country, region, city := r.Lookup(tt.ip)
+		if country != tt.country || region != tt.region || city != tt.city {
+			t.Errorf("Lookup(%q) = %q, %q, %q; want %q, %q, %q",
+				tt.ip, country, region, city, tt.country, tt.region, tt.city)
+		}
+	}
+}
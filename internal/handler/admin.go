@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/wyp0596/go2short/internal/cache"
 	"github.com/wyp0596/go2short/internal/config"
 	"github.com/wyp0596/go2short/internal/link"
+	"github.com/wyp0596/go2short/internal/logger"
 	"github.com/wyp0596/go2short/internal/middleware"
 	"github.com/wyp0596/go2short/internal/store"
 )
@@ -19,20 +21,28 @@ import (
 type AdminHandler struct {
 	store       *store.Store
 	cache       *cache.Cache
+	visitors    *cache.Visitors
 	auth        *middleware.AuthMiddleware
 	cfg         *config.Config
 	baseURL     string
 	linkService *link.Service
+	statsLoc    *time.Location // STATS_TIMEZONE
 }
 
-func NewAdminHandler(s *store.Store, c *cache.Cache, auth *middleware.AuthMiddleware, cfg *config.Config, ls *link.Service) *AdminHandler {
+func NewAdminHandler(s *store.Store, c *cache.Cache, v *cache.Visitors, auth *middleware.AuthMiddleware, cfg *config.Config, ls *link.Service) *AdminHandler {
+	statsLoc, err := loadTimezone(cfg.StatsTimezone)
+	if err != nil {
+		statsLoc = time.UTC // validated at startup
+	}
 	return &AdminHandler{
 		store:       s,
 		cache:       c,
+		visitors:    v,
 		auth:        auth,
 		cfg:         cfg,
 		baseURL:     cfg.BaseURL,
 		linkService: ls,
+		statsLoc:    statsLoc,
 	}
 }
 
@@ -89,6 +99,42 @@ func getUserID(c *gin.Context) *int {
 	return nil
 }
 
+// statsOptions reads the filters shared by all stats endpoints.
+// include_bots=true counts bot clicks; by default only humans are counted.
+// tz (IANA name) sets day boundaries, see statsLocation.
+func (h *AdminHandler) statsOptions(c *gin.Context) (store.StatsOptions, error) {
+	includeBots, _ := strconv.ParseBool(c.Query("include_bots"))
+	loc, err := h.statsLocation(c, c.Query("tz"))
+	return store.StatsOptions{IncludeBots: includeBots, Location: loc}, err
+}
+
+// statsLocation loads tz, or if empty the user's timezone preference, or
+// STATS_TIMEZONE.
+func (h *AdminHandler) statsLocation(c *gin.Context, tz string) (*time.Location, error) {
+	if tz != "" {
+		return loadTimezone(tz)
+	}
+	if userID := getUserID(c); userID != nil {
+		// Best effort: fall back to the default if unavailable
+		pref, err := h.store.GetUserTimezone(c.Request.Context(), *userID)
+		if err == nil && pref != "" {
+			if loc, err := loadTimezone(pref); err == nil {
+				return loc, nil
+			}
+		}
+	}
+	return h.statsLoc, nil
+}
+
+// loadTimezone loads an IANA timezone. "Local" is rejected since it means
+// the server's zone, which the database does not know by that name.
+func loadTimezone(tz string) (*time.Location, error) {
+	if tz == "Local" {
+		return nil, errors.New("unknown time zone Local")
+	}
+	return time.LoadLocation(tz)
+}
+
 // Logout handles admin logout.
 func (h *AdminHandler) Logout(c *gin.Context) {
 	token, _ := c.Get("token")
@@ -99,12 +145,13 @@ func (h *AdminHandler) Logout(c *gin.Context) {
 }
 
 type linkResponse struct {
-	Code       string     `json:"code"`
-	ShortURL   string     `json:"short_url"`
-	LongURL    string     `json:"long_url"`
-	CreatedAt  time.Time  `json:"created_at"`
-	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
-	IsDisabled bool       `json:"is_disabled"`
+	Code         string     `json:"code"`
+	ShortURL     string     `json:"short_url"`
+	LongURL      string     `json:"long_url"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	IsDisabled   bool       `json:"is_disabled"`
+	RedirectType int        `json:"redirect_type"` // 0 = server default
 }
 
 type linksResponse struct {
@@ -115,9 +162,10 @@ type linksResponse struct {
 }
 
 type adminCreateLinkRequest struct {
-	LongURL    string  `json:"long_url" binding:"required"`
-	ExpiresAt  *string `json:"expires_at,omitempty"`
-	CustomCode *string `json:"custom_code,omitempty"`
+	LongURL      string  `json:"long_url" binding:"required"`
+	ExpiresAt    *string `json:"expires_at,omitempty"`
+	CustomCode   *string `json:"custom_code,omitempty"`
+	RedirectType *int    `json:"redirect_type,omitempty"`
 }
 
 // CreateLink creates a new short link.
@@ -143,11 +191,17 @@ func (h *AdminHandler) CreateLink(c *gin.Context) {
 		customCode = *req.CustomCode
 	}
 
+	redirectType := 0
+	if req.RedirectType != nil {
+		redirectType = *req.RedirectType
+	}
+
 	result, err := h.linkService.Create(c.Request.Context(), &link.CreateRequest{
-		LongURL:    req.LongURL,
-		ExpiresAt:  expiresAt,
-		CustomCode: customCode,
-		UserID:     getUserID(c),
+		LongURL:      req.LongURL,
+		ExpiresAt:    expiresAt,
+		CustomCode:   customCode,
+		UserID:       getUserID(c),
+		RedirectType: redirectType,
 	})
 
 	if err != nil {
@@ -162,6 +216,8 @@ func (h *AdminHandler) CreateLink(c *gin.Context) {
 			c.JSON(http.StatusConflict, gin.H{"error": "custom code already taken"})
 		case link.ErrInvalidCode:
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid custom code (6-12 chars, base62)"})
+		case link.ErrInvalidRedirectType:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid redirect_type (301, 302, 307 or 308)"})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		}
@@ -204,11 +260,12 @@ func (h *AdminHandler) ListLinks(c *gin.Context) {
 
 	for _, l := range links {
 		lr := linkResponse{
-			Code:       l.Code,
-			ShortURL:   h.baseURL + "/" + l.Code,
-			LongURL:    l.LongURL,
-			CreatedAt:  l.CreatedAt,
-			IsDisabled: l.IsDisabled,
+			Code:         l.Code,
+			ShortURL:     h.baseURL + "/" + l.Code,
+			LongURL:      l.LongURL,
+			CreatedAt:    l.CreatedAt,
+			IsDisabled:   l.IsDisabled,
+			RedirectType: l.RedirectType,
 		}
 		if l.ExpiresAt.Valid {
 			lr.ExpiresAt = &l.ExpiresAt.Time
@@ -220,8 +277,9 @@ func (h *AdminHandler) ListLinks(c *gin.Context) {
 }
 
 type updateLinkRequest struct {
-	LongURL   string     `json:"long_url" binding:"required,url"`
-	ExpiresAt *time.Time `json:"expires_at"`
+	LongURL      string     `json:"long_url" binding:"required,url"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	RedirectType *int       `json:"redirect_type"` // nil keeps current, 0 resets to default
 }
 
 // UpdateLink updates a link.
@@ -233,7 +291,11 @@ func (h *AdminHandler) UpdateLink(c *gin.Context) {
 		return
 	}
 
-	err := h.store.UpdateLink(c.Request.Context(), code, req.LongURL, req.ExpiresAt, getUserID(c))
+	err := h.linkService.Update(c.Request.Context(), code, req.LongURL, req.ExpiresAt, req.RedirectType, getUserID(c))
+	if err == link.ErrInvalidRedirectType {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid redirect_type (301, 302, 307 or 308)"})
+		return
+	}
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
 		return
@@ -243,9 +305,6 @@ func (h *AdminHandler) UpdateLink(c *gin.Context) {
 		return
 	}
 
-	// Update cache
-	h.cache.SetURL(c.Request.Context(), code, req.LongURL)
-
 	c.JSON(http.StatusOK, gin.H{"message": "link updated"})
 }
 
@@ -253,7 +312,7 @@ func (h *AdminHandler) UpdateLink(c *gin.Context) {
 func (h *AdminHandler) DeleteLink(c *gin.Context) {
 	code := c.Param("code")
 
-	err := h.store.DeleteLink(c.Request.Context(), code, getUserID(c))
+	err := h.linkService.Delete(c.Request.Context(), code, getUserID(c))
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
 		return
@@ -279,7 +338,7 @@ func (h *AdminHandler) SetLinkDisabled(c *gin.Context) {
 		return
 	}
 
-	err := h.store.SetLinkDisabled(c.Request.Context(), code, req.Disabled, getUserID(c))
+	err := h.linkService.SetDisabled(c.Request.Context(), code, req.Disabled, getUserID(c))
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
 		return
@@ -301,33 +360,146 @@ func (h *AdminHandler) GetLinkStats(c *gin.Context) {
 	}
 
 	userID := getUserID(c)
-	stats, err := h.store.GetLinkStats(c.Request.Context(), code, days, userID)
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+	stats, err := h.store.GetLinkStats(c.Request.Context(), code, days, userID, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats"})
 		return
 	}
 
-	deviceStats, err := h.store.GetLinkDeviceStats(c.Request.Context(), code, days, userID)
+	deviceStats, err := h.store.GetLinkDeviceStats(c.Request.Context(), code, days, userID, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get device stats"})
 		return
 	}
 
+	geoStats, err := h.store.GetLinkGeoStats(c.Request.Context(), code, days, userID, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get geo stats"})
+		return
+	}
+
+	referrerStats, err := h.store.GetLinkReferrerStats(c.Request.Context(), code, days, userID, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get referrer stats"})
+		return
+	}
+
+	campaignStats, err := h.store.GetLinkCampaignStats(c.Request.Context(), code, days, userID, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get campaign stats"})
+		return
+	}
+
+	conversionStats, err := h.store.GetLinkConversionStats(c.Request.Context(), code, days, userID, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get conversion stats"})
+		return
+	}
+
+	// Unique visitors are best effort: stats still render without Redis
+	var visitorsTZ any // null like visitor_days without counts
+	scope := cache.LinkScope(code)
+	if err := h.fillDailyVisitors(c, scope, stats.DailyClicks); err != nil {
+		logger.Error("failed to count unique visitors", logger.Err(err), logger.Extra("code", code))
+	} else {
+		visitorsTZ = visitorsTimezone
+		if n, err := h.visitors.CountVisitorDays(c.Request.Context(), scope, days); err == nil {
+			stats.VisitorDays = &n
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"total_clicks": stats.TotalClicks,
-		"daily_clicks": stats.DailyClicks,
-		"device_stats": deviceStats,
+		"total_clicks":      stats.TotalClicks,
+		"visitor_days":      stats.VisitorDays,
+		"visitors_timezone": visitorsTZ,
+		"daily_clicks":      stats.DailyClicks,
+		"device_stats":      deviceStats,
+		"geo_stats":         geoStats,
+		"referrer_stats":    referrerStats,
+		"campaign_stats":    campaignStats,
+		"conversion_stats":  conversionStats,
 	})
 }
 
+// visitorsTimezone labels unique visitor counts: the sketches are per UTC
+// day whatever the stats timezone, so a local date gets the count of the UTC
+// day with the same date.
+const visitorsTimezone = "UTC"
+
+// fillDailyVisitors sets UniqueVisitors on each day from the scope's sketches.
+func (h *AdminHandler) fillDailyVisitors(c *gin.Context, scope string, days []store.DayClick) error {
+	dates := make([]time.Time, 0, len(days))
+	for _, d := range days {
+		t, err := time.Parse("2006-01-02", d.Date)
+		if err != nil {
+			return err
+		}
+		dates = append(dates, t)
+	}
+
+	counts, err := h.visitors.CountDays(c.Request.Context(), scope, dates)
+	if err != nil {
+		return err
+	}
+	for i := range days {
+		days[i].UniqueVisitors = &counts[i]
+	}
+	return nil
+}
+
 // GetOverviewStats returns overall statistics.
 func (h *AdminHandler) GetOverviewStats(c *gin.Context) {
-	stats, err := h.store.GetOverviewStats(c.Request.Context(), getUserID(c))
+	userID := getUserID(c)
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	stats, err := h.store.GetOverviewStats(c.Request.Context(), userID, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats"})
 		return
 	}
 
+	scope := cache.UserScope(userID)
+	if n, err := h.visitors.CountVisitorDays(c.Request.Context(), scope, 30); err == nil {
+		stats.VisitorDays = &n
+		stats.VisitorsTimezone = visitorsTimezone
+	}
+	if n, err := h.visitors.CountVisitorDays(c.Request.Context(), scope, 1); err == nil {
+		stats.TodayUniqueVisitors = &n // one day: distinct visitors
+		stats.VisitorsTimezone = visitorsTimezone
+	}
+
+	// Optional comparison of the last days with the days before
+	if compare, _ := strconv.ParseBool(c.Query("compare")); compare {
+		days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if days < 1 || days > 365 {
+			days = 30
+		}
+		cmp, err := h.store.ComparePeriods(c.Request.Context(), days, userID, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compare stats"})
+			return
+		}
+		// Whole UTC days up to yesterday, so both periods are complete
+		today := time.Now().UTC()
+		cur, curErr := h.visitors.CountVisitorDaysBefore(c.Request.Context(), scope, today, days)
+		prev, prevErr := h.visitors.CountVisitorDaysBefore(c.Request.Context(), scope, today.AddDate(0, 0, -days), days)
+		if curErr == nil && prevErr == nil {
+			cmp.Current.VisitorDays, cmp.Previous.VisitorDays = &cur, &prev
+			cmp.Change.VisitorDays = store.PercentChange(cur, prev)
+			cmp.VisitorsTimezone = visitorsTimezone
+		}
+		stats.Comparison = cmp
+	}
+
 	c.JSON(http.StatusOK, stats)
 }
 
@@ -342,18 +514,33 @@ func (h *AdminHandler) GetTopLinks(c *gin.Context) {
 		days = 30
 	}
 
-	links, err := h.store.GetTopLinks(c.Request.Context(), limit, days, getUserID(c))
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	userID := getUserID(c)
+	links, err := h.store.GetTopLinks(c.Request.Context(), limit, days, userID, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get top links"})
 		return
 	}
+	if compare, _ := strconv.ParseBool(c.Query("compare")); compare {
+		if err := h.store.FillTopLinksPrevious(c.Request.Context(), links, days, userID, opts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compare top links"})
+			return
+		}
+	}
 
 	// Add short_url to response
 	type topLinkResponse struct {
-		Code       string `json:"code"`
-		ShortURL   string `json:"short_url"`
-		LongURL    string `json:"long_url"`
-		ClickCount int    `json:"click_count"`
+		Code       string          `json:"code"`
+		ShortURL   string          `json:"short_url"`
+		LongURL    string          `json:"long_url"`
+		ClickCount int             `json:"click_count"`
+		Previous   *store.LinkRank `json:"previous,omitempty"`
+		Change     *float64        `json:"change,omitempty"`
 	}
 	resp := make([]topLinkResponse, 0, len(links))
 	for _, l := range links {
@@ -362,6 +549,8 @@ func (h *AdminHandler) GetTopLinks(c *gin.Context) {
 			ShortURL:   h.baseURL + "/" + l.Code,
 			LongURL:    l.LongURL,
 			ClickCount: l.ClickCount,
+			Previous:   l.Previous,
+			Change:     l.Change,
 		})
 	}
 
@@ -375,13 +564,86 @@ func (h *AdminHandler) GetClickTrend(c *gin.Context) {
 		days = 30
 	}
 
-	trend, err := h.store.GetClickTrend(c.Request.Context(), days, getUserID(c))
+	userID := getUserID(c)
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	trend, err := h.store.GetClickTrend(c.Request.Context(), days, userID, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get trend"})
 		return
 	}
+	if err := h.fillDailyVisitors(c, cache.UserScope(userID), trend); err != nil {
+		logger.Error("failed to count unique visitors", logger.Err(err))
+		c.JSON(http.StatusOK, gin.H{"trend": trend})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"trend": trend, "visitors_timezone": visitorsTimezone})
+}
+
+// heatmapWeekdays labels the rows of a click heatmap.
+var heatmapWeekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// GetClickHeatmap returns clicks by day of week and hour for the last N days.
+func (h *AdminHandler) GetClickHeatmap(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	heatmap, err := h.store.GetClickHeatmap(c.Request.Context(), days, getUserID(c), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get heatmap"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"trend": trend})
+	c.JSON(http.StatusOK, heatmapResponse(heatmap, days, opts))
+}
+
+// GetLinkClickHeatmap returns clicks of one link by day of week and hour.
+func (h *AdminHandler) GetLinkClickHeatmap(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	heatmap, err := h.store.GetLinkClickHeatmap(c.Request.Context(), c.Param("code"), days, getUserID(c), opts)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get heatmap"})
+		return
+	}
+
+	c.JSON(http.StatusOK, heatmapResponse(heatmap, days, opts))
+}
+
+func heatmapResponse(heatmap *store.Heatmap, days int, opts store.StatsOptions) gin.H {
+	return gin.H{
+		"days":     days,
+		"timezone": opts.Location.String(),
+		"weekdays": heatmapWeekdays,
+		"clicks":   heatmap.Clicks,
+		"total":    heatmap.Total,
+	}
 }
 
 // GetDeviceStats returns device/browser/OS distribution for all clicks.
@@ -391,7 +653,13 @@ func (h *AdminHandler) GetDeviceStats(c *gin.Context) {
 		days = 30
 	}
 
-	stats, err := h.store.GetDeviceStats(c.Request.Context(), days, getUserID(c))
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	stats, err := h.store.GetDeviceStats(c.Request.Context(), days, getUserID(c), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get device stats"})
 		return
@@ -400,6 +668,120 @@ func (h *AdminHandler) GetDeviceStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// GetGeoStats returns country/region/city distribution for all clicks.
+func (h *AdminHandler) GetGeoStats(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	stats, err := h.store.GetGeoStats(c.Request.Context(), days, getUserID(c), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get geo stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetReferrerStats returns referrer domain distribution for all clicks.
+func (h *AdminHandler) GetReferrerStats(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	referrers, err := h.store.GetReferrerStats(c.Request.Context(), days, getUserID(c), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get referrer stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referrers": referrers})
+}
+
+// GetCampaignStats returns UTM source/medium/campaign distribution for all clicks.
+func (h *AdminHandler) GetCampaignStats(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+
+	opts, err := h.statsOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return
+	}
+
+	stats, err := h.store.GetCampaignStats(c.Request.Context(), days, getUserID(c), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get campaign stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+type preferencesRequest struct {
+	Timezone string `json:"timezone"` // IANA name, empty for the default
+}
+
+// GetPreferences returns the user's stats timezone; empty means
+// default_timezone applies.
+func (h *AdminHandler) GetPreferences(c *gin.Context) {
+	tz := ""
+	if userID := getUserID(c); userID != nil {
+		var err error
+		tz, err = h.store.GetUserTimezone(c.Request.Context(), *userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get preferences"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"timezone": tz, "default_timezone": h.statsLoc.String()})
+}
+
+// UpdatePreferences sets the user's stats timezone.
+func (h *AdminHandler) UpdatePreferences(c *gin.Context) {
+	userID := getUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "admin account uses STATS_TIMEZONE"})
+		return
+	}
+
+	var req preferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if req.Timezone != "" {
+		if _, err := loadTimezone(req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timezone"})
+			return
+		}
+	}
+
+	if err := h.store.SetUserTimezone(c.Request.Context(), *userID, req.Timezone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"timezone": req.Timezone, "default_timezone": h.statsLoc.String()})
+}
+
 type createTokenRequest struct {
 	Name string `json:"name" binding:"required"`
 }
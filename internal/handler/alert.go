@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/alert"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+const (
+	defaultSpikeWindow = 60 // minutes
+	defaultSpikeFactor = 3
+	maxWebhookURLLen   = 2048
+	maxDeliveriesLimit = 200
+)
+
+type AlertHandler struct {
+	store *store.Store
+}
+
+func NewAlertHandler(s *store.Store) *AlertHandler {
+	return &AlertHandler{store: s}
+}
+
+type createAlertRuleRequest struct {
+	Code          string  `json:"code"` // empty = all links of the account
+	Kind          string  `json:"kind" binding:"required"`
+	Threshold     int64   `json:"threshold" binding:"required"`
+	WindowMinutes int     `json:"window_minutes"` // spike only, default 60
+	Factor        float64 `json:"factor"`         // spike only, default 3
+	URL           string  `json:"url" binding:"required"`
+}
+
+// updateAlertRuleRequest changes the fields that are set.
+type updateAlertRuleRequest struct {
+	Threshold     *int64   `json:"threshold"`
+	WindowMinutes *int     `json:"window_minutes"`
+	Factor        *float64 `json:"factor"`
+	URL           *string  `json:"url"`
+	Disabled      *bool    `json:"disabled"`
+	RotateSecret  bool     `json:"rotate_secret"`
+}
+
+// alertRuleWithSecret is returned when the secret is created or rotated,
+// the only times it is shown.
+type alertRuleWithSecret struct {
+	*store.AlertRule
+	Secret string `json:"secret"`
+}
+
+// validateAlertRule returns an error message for an invalid rule.
+func validateAlertRule(ctx context.Context, r *store.AlertRule) string {
+	switch r.Kind {
+	case store.AlertClicks:
+		r.WindowMinutes, r.Factor = 0, 0
+	case store.AlertSpike:
+		if r.WindowMinutes < 5 || r.WindowMinutes > 1440 {
+			return "window_minutes must be between 5 and 1440"
+		}
+		if math.IsNaN(r.Factor) || r.Factor < 1 || r.Factor > 1000 {
+			return "factor must be between 1 and 1000"
+		}
+	default:
+		return "kind must be clicks or spike"
+	}
+	if r.Threshold < 1 {
+		return "threshold must be positive"
+	}
+	if len(r.URL) > maxWebhookURLLen {
+		return "url too long"
+	}
+	if err := alert.CheckURL(ctx, r.URL); errors.Is(err, alert.ErrBlockedAddress) {
+		return "url points to a private address"
+	} else if err != nil {
+		return "invalid url (http or https with a resolvable host)"
+	}
+	return ""
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateAlertRule adds a rule on one link or on all links of the account.
+// The response holds the signing secret, which is not shown again.
+func (h *AlertHandler) CreateAlertRule(c *gin.Context) {
+	var req createAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind, threshold and url are required"})
+		return
+	}
+
+	rule := &store.AlertRule{
+		UserID:        getUserID(c),
+		Code:          req.Code,
+		Kind:          req.Kind,
+		Threshold:     req.Threshold,
+		WindowMinutes: req.WindowMinutes,
+		Factor:        req.Factor,
+		URL:           req.URL,
+	}
+	if rule.Kind == store.AlertSpike {
+		if rule.WindowMinutes == 0 {
+			rule.WindowMinutes = defaultSpikeWindow
+		}
+		if rule.Factor == 0 {
+			rule.Factor = defaultSpikeFactor
+		}
+	}
+	if msg := validateAlertRule(c.Request.Context(), rule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+	rule.Secret = secret
+
+	err = h.store.CreateAlertRule(c.Request.Context(), rule)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create alert rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, alertRuleWithSecret{AlertRule: rule, Secret: secret})
+}
+
+// ListAlertRules returns alert rules (without secrets).
+func (h *AlertHandler) ListAlertRules(c *gin.Context) {
+	rules, err := h.store.ListAlertRules(c.Request.Context(), getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list alert rules"})
+		return
+	}
+	if rules == nil {
+		rules = []store.AlertRule{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// UpdateAlertRule changes a rule. Changing the threshold, window or factor
+// re-arms it; rotate_secret returns a new secret.
+func (h *AlertHandler) UpdateAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule id"})
+		return
+	}
+	var req updateAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID := getUserID(c)
+	rule, err := h.store.GetAlertRule(c.Request.Context(), id, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get alert rule"})
+		return
+	}
+
+	if req.Threshold != nil {
+		rule.Threshold = *req.Threshold
+	}
+	if req.WindowMinutes != nil {
+		rule.WindowMinutes = *req.WindowMinutes
+	}
+	if req.Factor != nil {
+		rule.Factor = *req.Factor
+	}
+	if req.URL != nil {
+		rule.URL = *req.URL
+	}
+	if req.Disabled != nil {
+		rule.Disabled = *req.Disabled
+	}
+	if msg := validateAlertRule(c.Request.Context(), rule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if req.RotateSecret {
+		if rule.Secret, err = newWebhookSecret(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+			return
+		}
+	}
+
+	err = h.store.UpdateAlertRule(c.Request.Context(), *rule, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update alert rule"})
+		return
+	}
+
+	// Re-read for the re-armed state
+	if updated, err := h.store.GetAlertRule(c.Request.Context(), id, userID); err == nil {
+		rule.LastFiredAt = updated.LastFiredAt
+	}
+	if req.RotateSecret {
+		c.JSON(http.StatusOK, alertRuleWithSecret{AlertRule: rule, Secret: rule.Secret})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteAlertRule removes a rule and its delivery log.
+func (h *AlertHandler) DeleteAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule id"})
+		return
+	}
+
+	err = h.store.DeleteAlertRule(c.Request.Context(), id, getUserID(c))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete alert rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "alert rule deleted"})
+}
+
+// ListDeliveries returns the webhook delivery log of a rule, newest first.
+// Query: limit (default 50, max 200).
+func (h *AlertHandler) ListDeliveries(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule id"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > maxDeliveriesLimit {
+		limit = 50
+	}
+
+	deliveries, err := h.store.ListWebhookDeliveries(c.Request.Context(), id, getUserID(c), limit)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// RetryDelivery queues a delivered or failed delivery again.
+func (h *AlertHandler) RetryDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule id"})
+		return
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("delivery"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+		return
+	}
+
+	err = h.store.RetryWebhookDelivery(c.Request.Context(), id, deliveryID, getUserID(c))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found or still pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retry delivery"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "delivery queued"})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/events"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+const (
+	maxConversionValue = 1e12 // NUMERIC(14, 2)
+	maxTxnIDLen        = 100
+)
+
+// pixelGIF is a transparent 1x1 GIF.
+var pixelGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+type ConversionHandler struct {
+	store    *store.Store
+	clickIDs *events.ClickIDs // nil = click IDs disabled
+}
+
+func NewConversionHandler(s *store.Store, clickIDs *events.ClickIDs) *ConversionHandler {
+	return &ConversionHandler{store: s, clickIDs: clickIDs}
+}
+
+type conversionRequest struct {
+	ClickID string  `json:"click_id" form:"click_id"`
+	Value   float64 `json:"value" form:"value"`
+	TxnID   string  `json:"txn_id" form:"txn_id"` // optional, one conversion per click and txn_id
+}
+
+// conversion validates req. The link comes from the click ID, which must
+// carry a valid signature.
+func (req conversionRequest) conversion(ids *events.ClickIDs) (store.Conversion, string) {
+	code, ok := ids.Parse(req.ClickID)
+	if !ok {
+		return store.Conversion{}, "invalid click_id"
+	}
+	if math.IsNaN(req.Value) || req.Value < 0 || req.Value >= maxConversionValue {
+		return store.Conversion{}, "invalid value"
+	}
+	if utf8.RuneCountInString(req.TxnID) > maxTxnIDLen {
+		return store.Conversion{}, "txn_id too long (max 100)"
+	}
+	return store.Conversion{ClickID: req.ClickID, Code: code, TxnID: req.TxnID, Value: req.Value}, ""
+}
+
+// Postback records a conversion sent server to server with an API token of
+// the link's owner (or a global token). Accepts a JSON body or, as many ad
+// networks send postbacks, query parameters.
+func (h *ConversionHandler) Postback(c *gin.Context) {
+	var req conversionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	conv, msg := req.conversion(h.clickIDs)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	recorded, err := h.store.RecordConversion(c.Request.Context(), conv, getUserID(c))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record conversion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recorded": recorded})
+}
+
+// Pixel records a conversion from an image on the landing site's
+// confirmation page; the signed click ID is the only credential, which every
+// visitor holds. So it records at most one conversion per click, without
+// value: txn_id and value are only taken from the authenticated postback. It
+// always returns the image so pages never show a broken one, unless rate
+// limited.
+func (h *ConversionHandler) Pixel(c *gin.Context) {
+	clickID := c.Query("click_id")
+	if code, ok := h.clickIDs.Parse(clickID); ok {
+		_, err := h.store.RecordPixelConversion(c.Request.Context(), clickID, code)
+		if err != nil && err != sql.ErrNoRows {
+			logger.Error("failed to record pixel conversion", logger.Err(err), logger.Extra("click_id", clickID))
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/gif", pixelGIF)
+}
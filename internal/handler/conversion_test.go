@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/events"
+)
+
+func TestConversionRequest(t *testing.T) {
+	ids := events.NewClickIDs("secret")
+	id := ids.New("abc123")
+	forged := "abc123.0123456789abcdef01234567.0123456789abcdef01234567"
+
+	tests := []struct {
+		name string
+		req  conversionRequest
+		ids  *events.ClickIDs
+		want string
+	}{
+		{"valid", conversionRequest{ClickID: id, Value: 9.99, TxnID: "t1"}, ids, ""},
+		{"forged", conversionRequest{ClickID: forged}, ids, "invalid click_id"},
+		{"other secret", conversionRequest{ClickID: id}, events.NewClickIDs("other"), "invalid click_id"},
+		{"click IDs disabled", conversionRequest{ClickID: id}, nil, "invalid click_id"},
+		{"negative value", conversionRequest{ClickID: id, Value: -1}, ids, "invalid value"},
+		{"long txn_id", conversionRequest{ClickID: id, TxnID: strings.Repeat("x", maxTxnIDLen+1)}, ids, "txn_id too long (max 100)"},
+	}
+	for _, tt := range tests {
+		conv, msg := tt.req.conversion(tt.ids)
+		if msg != tt.want {
+			t.Errorf("%s: msg = %q, want %q", tt.name, msg, tt.want)
+		}
+		if msg == "" && (conv.Code != "abc123" || conv.ClickID != id || conv.TxnID != "t1" || conv.Value != 9.99) {
+			t.Errorf("%s: unexpected conversion %+v", tt.name, conv)
+		}
+	}
+}
+
+func TestPixelIgnoresForgedClickID(t *testing.T) {
+	// No store: a forged ID must be rejected before it is used
+	h := NewConversionHandler(nil, events.NewClickIDs("secret"))
+	forged := events.NewClickIDs("guess").New("abc123")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/conversions/pixel.gif?click_id="+url.QueryEscape(forged), nil)
+	h.Pixel(c)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" || !bytes.Equal(w.Body.Bytes(), pixelGIF) {
+		t.Errorf("expected the pixel, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/events"
+)
+
+type DeadLetterHandler struct {
+	deadLetters *events.DeadLetters
+}
+
+func NewDeadLetterHandler(dl *events.DeadLetters) *DeadLetterHandler {
+	return &DeadLetterHandler{deadLetters: dl}
+}
+
+// List returns the newest dead-lettered click events.
+func (h *DeadLetterHandler) List(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 1000 {
+		limit = 50
+	}
+
+	letters, total, err := h.deadLetters.List(c.Request.Context(), int64(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dead_letters": letters, "total": total})
+}
+
+type replayRequest struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"`
+}
+
+// Replay moves dead-lettered events back to the click stream.
+// Body: {"ids": [...]} for specific entries, or {"all": true} for up to 1000 of the oldest.
+func (h *DeadLetterHandler) Replay(c *gin.Context) {
+	var req replayRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (len(req.IDs) == 0 && !req.All) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids or all is required"})
+		return
+	}
+	if req.All {
+		req.IDs = nil
+	}
+
+	n, err := h.deadLetters.Replay(c.Request.Context(), req.IDs, 1000)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to replay dead letters", "replayed": n})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replayed": n})
+}
+
+// Delete discards a dead-lettered event.
+func (h *DeadLetterHandler) Delete(c *gin.Context) {
+	n, err := h.deadLetters.Delete(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete dead letter"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "dead letter deleted"})
+}
@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// clickExportColumns returns the CSV header of a click export. IP and UA
+// are only exported to admins (identity).
+func clickExportColumns(identity bool) []string {
+	cols := []string{"ts", "code"}
+	if identity {
+		cols = append(cols, "ip", "ua")
+	}
+	return append(cols, "referer", "referer_host", "utm_source", "utm_medium", "utm_campaign",
+		"device_type", "browser", "os", "country", "region", "city", "is_bot", "click_id")
+}
+
+type exportedClick struct {
+	Timestamp   time.Time `json:"ts"`
+	Code        string    `json:"code"`
+	IP          *string   `json:"ip,omitempty"` // admins only
+	UA          *string   `json:"ua,omitempty"`
+	Referer     string    `json:"referer"`
+	RefererHost string    `json:"referer_host"`
+	UTMSource   string    `json:"utm_source"`
+	UTMMedium   string    `json:"utm_medium"`
+	UTMCampaign string    `json:"utm_campaign"`
+	DeviceType  string    `json:"device_type"`
+	Browser     string    `json:"browser"`
+	OS          string    `json:"os"`
+	Country     string    `json:"country"`
+	Region      string    `json:"region"`
+	City        string    `json:"city"`
+	IsBot       bool      `json:"is_bot"`
+	ClickID     string    `json:"click_id"`
+}
+
+type exportedStat struct {
+	Time   *time.Time        `json:"time,omitempty"`
+	Group  map[string]string `json:"group,omitempty"`
+	Clicks int64             `json:"clicks"`
+}
+
+// ExportClicks streams the raw clicks of all (or the user's) links.
+func (h *AdminHandler) ExportClicks(c *gin.Context) {
+	h.exportClicks(c, "")
+}
+
+// ExportLinkClicks streams the raw clicks of one link.
+func (h *AdminHandler) ExportLinkClicks(c *gin.Context) {
+	h.exportClicks(c, c.Param("code"))
+}
+
+// exportClicks streams raw clicks as CSV or NDJSON, ordered by code and time.
+// Query: format (csv, ndjson), from, to, tz, include_bots.
+func (h *AdminHandler) exportClicks(c *gin.Context, code string) {
+	e, from, to, ok := h.startExport(c, "clicks", code)
+	if !ok {
+		return
+	}
+	includeBots, _ := strconv.ParseBool(c.Query("include_bots"))
+	loc := from.Location()
+	userID := getUserID(c)
+	identity := userID == nil
+
+	e.columns = clickExportColumns(identity)
+	err := h.store.ExportClicks(c.Request.Context(), store.ClickExport{
+		From:   from,
+		To:     to,
+		Code:   code,
+		UserID: userID,
+		Opts:   store.StatsOptions{IncludeBots: includeBots},
+	}, func(ev store.ClickEvent) error {
+		return e.write(clickRow(ev, loc, identity))
+	})
+	e.finish(err)
+}
+
+// clickRow returns a click as a CSV record and an NDJSON object, with its
+// time in loc and IP and UA only if identity is set.
+func clickRow(ev store.ClickEvent, loc *time.Location, identity bool) ([]string, exportedClick) {
+	ts := ev.Timestamp.In(loc)
+	record := []string{ts.Format(time.RFC3339), ev.Code}
+	if identity {
+		record = append(record, csvCell(ev.IP), csvCell(ev.UA))
+	}
+	record = append(record, csvCell(ev.Referer),
+		csvCell(ev.RefererHost), csvCell(ev.UTMSource), csvCell(ev.UTMMedium), csvCell(ev.UTMCampaign),
+		csvCell(ev.DeviceType), csvCell(ev.Browser), csvCell(ev.OS),
+		csvCell(ev.Country), csvCell(ev.Region), csvCell(ev.City), strconv.FormatBool(ev.IsBot), ev.ClickID)
+
+	click := exportedClick{
+		Timestamp:   ts,
+		Code:        ev.Code,
+		Referer:     ev.Referer,
+		RefererHost: ev.RefererHost,
+		UTMSource:   ev.UTMSource,
+		UTMMedium:   ev.UTMMedium,
+		UTMCampaign: ev.UTMCampaign,
+		DeviceType:  ev.DeviceType,
+		Browser:     ev.Browser,
+		OS:          ev.OS,
+		Country:     ev.Country,
+		Region:      ev.Region,
+		City:        ev.City,
+		IsBot:       ev.IsBot,
+		ClickID:     ev.ClickID,
+	}
+	if identity {
+		click.IP, click.UA = &ev.IP, &ev.UA
+	}
+	return record, click
+}
+
+// ExportStats streams aggregated clicks of all (or the user's) links.
+func (h *AdminHandler) ExportStats(c *gin.Context) {
+	h.exportStats(c, "")
+}
+
+// ExportLinkStats streams aggregated clicks of one link.
+func (h *AdminHandler) ExportLinkStats(c *gin.Context) {
+	h.exportStats(c, c.Param("code"))
+}
+
+// exportStats streams a click query as CSV or NDJSON without the row limit of
+// QueryStats. Query: format, from, to, tz, include_bots, bucket,
+// group_by (comma-separated), filter (dimension:value, repeatable).
+func (h *AdminHandler) exportStats(c *gin.Context, code string) {
+	e, from, to, ok := h.startExport(c, "stats", code)
+	if !ok {
+		return
+	}
+	if to.Sub(from) > maxQueryRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range too long (max 3 years)"})
+		return
+	}
+	includeBots, _ := strconv.ParseBool(c.Query("include_bots"))
+	bucket := c.Query("bucket")
+
+	var groupBy []string
+	for _, d := range strings.Split(c.Query("group_by"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			groupBy = append(groupBy, d)
+		}
+	}
+	filters := make(map[string][]string)
+	for _, f := range c.QueryArray("filter") {
+		dim, value, ok := strings.Cut(f, ":")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter (dimension:value)"})
+			return
+		}
+		filters[dim] = append(filters[dim], value)
+	}
+	if code != "" {
+		filters["code"] = []string{code}
+	}
+
+	if bucket != "" {
+		e.columns = append(e.columns, "time")
+	}
+	e.columns = append(e.columns, groupBy...)
+	e.columns = append(e.columns, "clicks")
+	loc := from.Location()
+
+	err := h.store.ExportClickQuery(c.Request.Context(), store.ClickQuery{
+		From:     from,
+		To:       to,
+		Location: loc,
+		Bucket:   bucket,
+		GroupBy:  groupBy,
+		Filters:  filters,
+		UserID:   getUserID(c),
+		Opts:     store.StatsOptions{IncludeBots: includeBots},
+	}, func(row store.ClickQueryRow) error {
+		record := make([]string, 0, len(e.columns))
+		stat := exportedStat{Clicks: row.Clicks}
+		if bucket != "" {
+			record = append(record, row.Time.Format(time.RFC3339))
+			stat.Time = &row.Time
+		}
+		if len(groupBy) > 0 {
+			stat.Group = make(map[string]string, len(groupBy))
+		}
+		for i, d := range groupBy {
+			record = append(record, csvCell(row.Group[i]))
+			stat.Group[d] = row.Group[i]
+		}
+		record = append(record, strconv.FormatInt(row.Clicks, 10))
+		return e.write(record, stat)
+	})
+	e.finish(err)
+}
+
+// startExport parses the format and time range shared by all exports. It
+// writes the error response and returns false if they are invalid.
+func (h *AdminHandler) startExport(c *gin.Context, kind, code string) (*exporter, time.Time, time.Time, bool) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format (csv or ndjson)"})
+		return nil, time.Time{}, time.Time{}, false
+	}
+	loc, err := h.statsLocation(c, c.Query("tz"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+		return nil, time.Time{}, time.Time{}, false
+	}
+
+	to := time.Now().In(loc)
+	if s := c.Query("to"); s != "" {
+		t, err := parseQueryTime(s, loc, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to (RFC 3339 or YYYY-MM-DD)"})
+			return nil, time.Time{}, time.Time{}, false
+		}
+		to = t.In(loc)
+	}
+	from := to.AddDate(0, 0, -30)
+	if s := c.Query("from"); s != "" {
+		t, err := parseQueryTime(s, loc, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from (RFC 3339 or YYYY-MM-DD)"})
+			return nil, time.Time{}, time.Time{}, false
+		}
+		from = t.In(loc)
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return nil, time.Time{}, time.Time{}, false
+	}
+
+	scope := code
+	if scope == "" {
+		scope = "all"
+	}
+	// Named after the last day covered: to is exclusive
+	name := kind + "-" + scope + "-" + from.Format("20060102") + "-" + to.Add(-time.Nanosecond).Format("20060102")
+	return &exporter{c: c, format: format, filename: name}, from, to, true
+}
+
+// exporter writes rows as CSV or NDJSON. Headers go out with the first row, so
+// errors before any output still get a JSON error response.
+type exporter struct {
+	c        *gin.Context
+	format   string
+	filename string
+	columns  []string // CSV header
+
+	started bool
+	csv     *csv.Writer
+	json    *json.Encoder
+}
+
+func (e *exporter) start() {
+	e.started = true
+	if e.format == "ndjson" {
+		e.c.Header("Content-Type", "application/x-ndjson")
+		e.c.Header("Content-Disposition", `attachment; filename="`+e.filename+`.ndjson"`)
+		e.c.Status(http.StatusOK)
+		e.json = json.NewEncoder(e.c.Writer)
+		e.json.SetEscapeHTML(false)
+		return
+	}
+	e.c.Header("Content-Type", "text/csv; charset=utf-8")
+	e.c.Header("Content-Disposition", `attachment; filename="`+e.filename+`.csv"`)
+	e.c.Status(http.StatusOK)
+	e.csv = csv.NewWriter(e.c.Writer)
+	e.csv.Write(e.columns)
+}
+
+// write sends one row: record in CSV, v in NDJSON.
+func (e *exporter) write(record []string, v any) error {
+	if !e.started {
+		e.start()
+	}
+	if e.csv != nil {
+		return e.csv.Write(record)
+	}
+	return e.json.Encode(v)
+}
+
+// finish completes the export. Once rows have been sent an error can no
+// longer change the status, so the connection is closed instead to keep
+// clients from taking a truncated file for a complete one.
+func (e *exporter) finish(err error) {
+	if err == nil {
+		if !e.started {
+			e.start()
+		}
+		if e.csv != nil {
+			e.csv.Flush()
+		}
+		return
+	}
+
+	if !e.started {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			e.c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+		case errors.Is(err, store.ErrInvalidQuery):
+			e.c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			e.c.JSON(http.StatusInternalServerError, gin.H{"error": "export failed"})
+		}
+		return
+	}
+	if e.c.Request.Context().Err() == nil {
+		logger.Error("export failed", logger.Err(err), logger.Extra("file", e.filename))
+	}
+	if conn, _, err := e.c.Writer.Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+// csvCell keeps spreadsheet apps from running client-supplied values such as
+// User-Agents or UTM tags as formulas.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newExportContext(query string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/export?"+query, nil)
+	return c, w
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"google.com", "google.com"},
+		{"=HYPERLINK(\"x\")", "'=HYPERLINK(\"x\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+		{"\rx", "'\rx"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		if got := csvCell(tt.in); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClickRow(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	ev := store.ClickEvent{
+		Code:      "abc",
+		Timestamp: time.Date(2026, 3, 1, 15, 30, 0, 0, time.UTC),
+		IP:        "1.2.3.4",
+		UA:        "=cmd",
+		Referer:   "https://example.com/",
+		UTMSource: "@news",
+		IsBot:     true,
+		ClickID:   "cid",
+	}
+
+	record, click := clickRow(ev, loc, true)
+	if cols := clickExportColumns(true); len(record) != len(cols) || cols[2] != "ip" || cols[3] != "ua" {
+		t.Fatalf("record does not match the columns: %v, %v", record, cols)
+	}
+	if record[0] != "2026-03-02T00:30:00+09:00" || record[2] != "1.2.3.4" || record[3] != "'=cmd" {
+		t.Errorf("unexpected record %v", record)
+	}
+	if click.IP == nil || *click.IP != "1.2.3.4" || click.UA == nil || *click.UA != "=cmd" {
+		t.Errorf("expected IP and UA in the object, got %+v", click)
+	}
+	if click.UTMSource != "@news" {
+		t.Errorf("NDJSON values must not be escaped, got %q", click.UTMSource)
+	}
+
+	record, click = clickRow(ev, loc, false)
+	cols := clickExportColumns(false)
+	if len(record) != len(cols) {
+		t.Fatalf("record does not match the columns: %v, %v", record, cols)
+	}
+	for i, col := range cols {
+		if col == "ip" || col == "ua" {
+			t.Errorf("column %q exported without identity", col)
+		}
+		if record[i] == "1.2.3.4" || record[i] == "'=cmd" {
+			t.Errorf("column %q holds IP or UA: %q", col, record[i])
+		}
+	}
+	b, _ := json.Marshal(click)
+	if strings.Contains(string(b), `"ip"`) || strings.Contains(string(b), `"ua"`) {
+		t.Errorf("expected no IP or UA in the object, got %s", b)
+	}
+}
+
+func TestExporterCSV(t *testing.T) {
+	c, w := newExportContext("")
+	e := &exporter{c: c, format: "csv", filename: "clicks-all", columns: []string{"code", "clicks"}}
+	e.write([]string{"abc", "1"}, nil)
+	e.write([]string{"a,b", "2"}, nil)
+	e.finish(nil)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if d := w.Header().Get("Content-Disposition"); d != `attachment; filename="clicks-all.csv"` {
+		t.Errorf("Content-Disposition = %q", d)
+	}
+	if want := "code,clicks\nabc,1\n\"a,b\",2\n"; w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestExporterNDJSON(t *testing.T) {
+	c, w := newExportContext("")
+	e := &exporter{c: c, format: "ndjson", filename: "stats-abc"}
+	e.write(nil, exportedStat{Clicks: 1})
+	e.write(nil, exportedStat{Group: map[string]string{"referer": "<a&b>"}, Clicks: 2})
+	e.finish(nil)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	want := `{"clicks":1}` + "\n" + `{"group":{"referer":"<a&b>"},"clicks":2}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestExporterEmpty(t *testing.T) {
+	c, w := newExportContext("")
+	e := &exporter{c: c, format: "csv", columns: []string{"code", "clicks"}}
+	e.finish(nil)
+
+	if w.Code != http.StatusOK || w.Body.String() != "code,clicks\n" {
+		t.Errorf("expected only the header, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestExporterErrorBeforeStart(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{sql.ErrNoRows, http.StatusNotFound},
+		{fmt.Errorf("%w: bad dimension", store.ErrInvalidQuery), http.StatusBadRequest},
+		{errors.New("db down"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		c, w := newExportContext("")
+		e := &exporter{c: c, format: "csv", columns: []string{"code"}}
+		e.finish(tt.err)
+
+		if w.Code != tt.want {
+			t.Errorf("%v: status = %d, want %d", tt.err, w.Code, tt.want)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("%v: expected a JSON error, got %q", tt.err, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestStartExport(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+		name  string
+	}{
+		{"tz=UTC&from=2026-03-01&to=2026-03-31", true, "clicks-abc-20260301-20260331"},
+		{"tz=UTC&format=ndjson&from=2026-03-01&to=2026-03-02", true, "clicks-abc-20260301-20260302"},
+		{"tz=UTC&format=xlsx", false, ""},
+		{"tz=Mars/Base", false, ""},
+		{"tz=UTC&from=yesterday", false, ""},
+		{"tz=UTC&from=2026-03-02&to=2026-03-01", false, ""},
+		{"tz=UTC&from=2026-03-01T00:00:00Z&to=2026-03-01T00:00:00Z", false, ""},
+	}
+	h := &AdminHandler{}
+	for _, tt := range tests {
+		c, w := newExportContext(tt.query)
+		e, _, _, ok := h.startExport(c, "clicks", "abc")
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.query, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", tt.query, w.Code)
+			}
+			continue
+		}
+		if e.filename != tt.name {
+			t.Errorf("%s: filename = %q, want %q", tt.query, e.filename, tt.name)
+		}
+	}
+}
@@ -23,9 +23,10 @@ func NewLinkHandler(s *link.Service, baseURL string) *LinkHandler {
 }
 
 type createRequest struct {
-	LongURL    string  `json:"long_url" binding:"required"`
-	ExpiresAt  *string `json:"expires_at,omitempty"`
-	CustomCode *string `json:"custom_code,omitempty"`
+	LongURL      string  `json:"long_url" binding:"required"`
+	ExpiresAt    *string `json:"expires_at,omitempty"`
+	CustomCode   *string `json:"custom_code,omitempty"`
+	RedirectType *int    `json:"redirect_type,omitempty"`
 }
 
 type createResponse struct {
@@ -58,6 +59,11 @@ func (h *LinkHandler) Create(c *gin.Context) {
 		customCode = *req.CustomCode
 	}
 
+	redirectType := 0
+	if req.RedirectType != nil {
+		redirectType = *req.RedirectType
+	}
+
 	// Get userID from API token (set by api_token middleware)
 	var userID *int
 	if uid, ok := c.Get("userID"); ok {
@@ -67,10 +73,11 @@ func (h *LinkHandler) Create(c *gin.Context) {
 	}
 
 	result, err := h.service.Create(c.Request.Context(), &link.CreateRequest{
-		LongURL:    req.LongURL,
-		ExpiresAt:  expiresAt,
-		CustomCode: customCode,
-		UserID:     userID,
+		LongURL:      req.LongURL,
+		ExpiresAt:    expiresAt,
+		CustomCode:   customCode,
+		UserID:       userID,
+		RedirectType: redirectType,
 	})
 
 	if err != nil {
@@ -85,6 +92,8 @@ func (h *LinkHandler) Create(c *gin.Context) {
 			c.JSON(http.StatusConflict, gin.H{"error": "custom code already taken"})
 		case link.ErrInvalidCode:
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid custom code (6-12 chars, base62)"})
+		case link.ErrInvalidRedirectType:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid redirect_type (301, 302, 307 or 308)"})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		}
@@ -147,9 +156,10 @@ func (h *LinkHandler) Preview(c *gin.Context) {
 }
 
 type batchCreateItem struct {
-	LongURL    string  `json:"long_url" binding:"required"`
-	ExpiresAt  *string `json:"expires_at,omitempty"`
-	CustomCode *string `json:"custom_code,omitempty"`
+	LongURL      string  `json:"long_url" binding:"required"`
+	ExpiresAt    *string `json:"expires_at,omitempty"`
+	CustomCode   *string `json:"custom_code,omitempty"`
+	RedirectType *int    `json:"redirect_type,omitempty"`
 }
 
 type batchCreateRequest struct {
@@ -198,11 +208,16 @@ func (h *LinkHandler) BatchCreate(c *gin.Context) {
 		if item.CustomCode != nil {
 			customCode = *item.CustomCode
 		}
+		redirectType := 0
+		if item.RedirectType != nil {
+			redirectType = *item.RedirectType
+		}
 		serviceReqs[i] = link.BatchCreateRequest{
-			LongURL:    item.LongURL,
-			ExpiresAt:  expiresAt,
-			CustomCode: customCode,
-			UserID:     userID,
+			LongURL:      item.LongURL,
+			ExpiresAt:    expiresAt,
+			CustomCode:   customCode,
+			UserID:       userID,
+			RedirectType: redirectType,
 		}
 	}
 
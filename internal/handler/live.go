@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/events"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+const (
+	liveHeartbeat = 15 * time.Second // keeps idle streams open through proxies
+	maxLiveOwners = 10000            // link owners cached per stream
+)
+
+type LiveHandler struct {
+	feed  *events.Feed
+	store *store.Store
+}
+
+func NewLiveHandler(feed *events.Feed, s *store.Store) *LiveHandler {
+	return &LiveHandler{feed: feed, store: s}
+}
+
+// liveClick is what the live feed shows of a click: no IP, UA or visitor ID.
+type liveClick struct {
+	Code        string    `json:"code"`
+	Timestamp   time.Time `json:"ts"`
+	DeviceType  string    `json:"device_type"`
+	Browser     string    `json:"browser"`
+	OS          string    `json:"os"`
+	Referrer    string    `json:"referrer,omitempty"`
+	UTMSource   string    `json:"utm_source,omitempty"`
+	UTMMedium   string    `json:"utm_medium,omitempty"`
+	UTMCampaign string    `json:"utm_campaign,omitempty"`
+	Bot         bool      `json:"bot,omitempty"`
+}
+
+// Stream sends the caller's clicks as Server-Sent Events ("click") as they
+// reach the click stream. Query: code to follow one link, include_bots.
+func (h *LiveHandler) Stream(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := getUserID(c)
+	code := c.Query("code")
+	includeBots, _ := strconv.ParseBool(c.Query("include_bots"))
+
+	if code != "" && userID != nil {
+		owners, err := h.store.LinkOwners(ctx, []string{code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check link"})
+			return
+		}
+		if owner, ok := owners[code]; !ok || owner != *userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+			return
+		}
+	}
+
+	clicks, unsubscribe, err := h.feed.Subscribe()
+	if errors.Is(err, events.ErrFeedFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many live feed connections"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "live feed unavailable"})
+		return
+	}
+	defer unsubscribe()
+
+	// Users only see clicks on their own links
+	owned := make(map[string]bool)
+	visible := func(code string) bool {
+		if userID == nil {
+			return true
+		}
+		if v, ok := owned[code]; ok {
+			return v
+		}
+		owners, err := h.store.LinkOwners(ctx, []string{code})
+		if err != nil {
+			logger.Error("failed to load link owner for live feed", logger.Err(err), logger.Extra("code", code))
+			return false
+		}
+		if len(owned) >= maxLiveOwners {
+			owned = make(map[string]bool)
+		}
+		owner, ok := owners[code]
+		owned[code] = ok && owner == *userID
+		return owned[code]
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // nginx: do not buffer the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case e, ok := <-clicks:
+			if !ok {
+				return false // feed closed on shutdown
+			}
+			if (e.Bot && !includeBots) || (code != "" && e.Code != code) || !visible(e.Code) {
+				return true
+			}
+			c.SSEvent("click", liveClick{
+				Code:        e.Code,
+				Timestamp:   e.Timestamp,
+				DeviceType:  e.DeviceType,
+				Browser:     e.Browser,
+				OS:          e.OS,
+				Referrer:    e.RefHost,
+				UTMSource:   e.UTMSource,
+				UTMMedium:   e.UTMMedium,
+				UTMCampaign: e.UTMCampaign,
+				Bot:         e.Bot,
+			})
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+		}
+		return true
+	})
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// maxQueryRange bounds the time range of a stats query.
+const maxQueryRange = 3 * 366 * 24 * time.Hour
+
+type statsQueryRequest struct {
+	From        string              `json:"from"`     // RFC 3339, or YYYY-MM-DD in timezone; default to - 30 days
+	To          string              `json:"to"`       // exclusive; a date includes that day; default now
+	Timezone    string              `json:"timezone"` // IANA name, default the user's preference
+	Bucket      string              `json:"bucket"`   // hour, day, week, month; empty for totals
+	GroupBy     []string            `json:"group_by"`
+	Filters     map[string][]string `json:"filters"`
+	IncludeBots bool                `json:"include_bots"`
+}
+
+// QueryStats answers a flexible click query: any time range, bucketed in a
+// timezone and grouped and filtered by dimensions.
+func (h *AdminHandler) QueryStats(c *gin.Context) {
+	var req statsQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	loc, err := h.statsLocation(c, req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timezone"})
+		return
+	}
+
+	to := time.Now()
+	if req.To != "" {
+		t, err := parseQueryTime(req.To, loc, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to (RFC 3339 or YYYY-MM-DD)"})
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if req.From != "" {
+		t, err := parseQueryTime(req.From, loc, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from (RFC 3339 or YYYY-MM-DD)"})
+			return
+		}
+		from = t
+	}
+	if to.Sub(from) > maxQueryRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range too long (max 3 years)"})
+		return
+	}
+
+	result, err := h.store.QueryClicks(c.Request.Context(), store.ClickQuery{
+		From:     from,
+		To:       to,
+		Location: loc,
+		Bucket:   req.Bucket,
+		GroupBy:  req.GroupBy,
+		Filters:  req.Filters,
+		UserID:   getUserID(c),
+		Opts:     store.StatsOptions{IncludeBots: req.IncludeBots},
+	})
+	if errors.Is(err, store.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":      result.From,
+		"to":        result.To,
+		"timezone":  loc.String(),
+		"bucket":    req.Bucket,
+		"group_by":  req.GroupBy,
+		"source":    result.Source,
+		"truncated": result.Truncated,
+		"series":    result.Series,
+	})
+}
+
+// parseQueryTime parses RFC 3339 or a date in loc. A date as the end of a
+// range means the end of that day.
+func parseQueryTime(s string, loc *time.Location, end bool) (time.Time, error) {
+	if strings.Contains(s, "T") {
+		return time.Parse(time.RFC3339, s)
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err == nil && end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, err
+}
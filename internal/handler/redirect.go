@@ -13,14 +13,20 @@ import (
 )
 
 type RedirectHandler struct {
-	service  *redirect.Service
-	producer *events.Producer
+	service      *redirect.Service
+	producer     *events.Producer
+	privacy      *events.Privacy
+	clickIDParam string // "" = no click IDs
+	clickIDs     *events.ClickIDs
 }
 
-func NewRedirectHandler(s *redirect.Service, p *events.Producer) *RedirectHandler {
+func NewRedirectHandler(s *redirect.Service, p *events.Producer, privacy *events.Privacy, clickIDParam string, clickIDs *events.ClickIDs) *RedirectHandler {
 	return &RedirectHandler{
-		service:  s,
-		producer: p,
+		service:      s,
+		producer:     p,
+		privacy:      privacy,
+		clickIDParam: clickIDParam,
+		clickIDs:     clickIDs,
 	}
 }
 
@@ -47,7 +53,7 @@ func (h *RedirectHandler) Handle(c *gin.Context) {
 	metrics.RedirectRequests.WithLabelValues(strconv.Itoa(result.StatusCode)).Inc()
 
 	switch result.StatusCode {
-	case 302:
+	case 301, 302, 307, 308:
 		// Parse User-Agent
 		uaStr := c.GetHeader("User-Agent")
 		ua := useragent.New(uaStr)
@@ -57,20 +63,36 @@ func (h *RedirectHandler) Handle(c *gin.Context) {
 			deviceType = "mobile"
 		}
 
+		referer := c.GetHeader("Referer")
+		event := &events.ClickEvent{
+			Code:        code,
+			Timestamp:   time.Now().UTC(),
+			IP:          c.ClientIP(),
+			UA:          uaStr,
+			DeviceType:  deviceType,
+			Browser:     browserName,
+			OS:          ua.OS(),
+			Referer:     referer,
+			RefHost:     events.RefererHost(referer),
+			UTMSource:   events.CampaignValue(c.Query("utm_source")),
+			UTMMedium:   events.CampaignValue(c.Query("utm_medium")),
+			UTMCampaign: events.CampaignValue(c.Query("utm_campaign")),
+			ReqID:       c.GetHeader("X-Request-ID"),
+			Bot:         ua.Bot() || events.IsBot(uaStr, c.GetHeader("Accept-Language")),
+			DoNotTrack:  c.GetHeader("DNT") == "1" || c.GetHeader("Sec-GPC") == "1",
+		}
+
+		// Tag the destination so conversions can be attributed to the click
+		target := result.URL
+		if h.clickIDParam != "" && h.privacy.Tracks(event) {
+			event.ClickID = h.clickIDs.New(code)
+			target = events.AppendQueryParam(target, h.clickIDParam, event.ClickID)
+		}
+
 		// Async enqueue click event
-		h.producer.EnqueueAsync(&events.ClickEvent{
-			Code:       code,
-			Timestamp:  time.Now().UTC(),
-			IP:         c.ClientIP(),
-			UA:         uaStr,
-			DeviceType: deviceType,
-			Browser:    browserName,
-			OS:         ua.OS(),
-			Referer:    c.GetHeader("Referer"),
-			ReqID:      c.GetHeader("X-Request-ID"),
-		})
+		h.producer.EnqueueAsync(event)
 		metrics.ClickEventsEnqueued.Inc()
-		c.Redirect(http.StatusFound, result.URL)
+		c.Redirect(result.StatusCode, target)
 
 	case 404:
 		c.Status(http.StatusNotFound)
@@ -10,11 +10,14 @@ import (
 // Storer defines the store operations needed by link service.
 type Storer interface {
 	GetLink(ctx context.Context, code string) (*store.Link, error)
-	CreateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, userID *int) error
+	CreateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, userID *int, redirectType int) error
+	UpdateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, redirectType *int, userID *int) error
+	SetLinkDisabled(ctx context.Context, code string, disabled bool, userID *int) error
+	DeleteLink(ctx context.Context, code string, userID *int) error
 }
 
 // Cacher defines the cache operations needed by link service.
 type Cacher interface {
-	GetURL(ctx context.Context, code string) (string, error)
-	SetURL(ctx context.Context, code, url string) error
+	SetLink(ctx context.Context, link *store.Link) error
+	Invalidate(ctx context.Context, code string) error
 }
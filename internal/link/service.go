@@ -2,12 +2,16 @@ package link
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"math/rand"
 	"net"
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/store"
 )
 
 const charset = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -19,6 +23,8 @@ var (
 	ErrCodeTaken   = errors.New("custom code already taken")
 	ErrInvalidCode = errors.New("invalid custom code")
 	ErrMaxRetries  = errors.New("failed to generate unique code")
+
+	ErrInvalidRedirectType = errors.New("invalid redirect type")
 )
 
 type Service struct {
@@ -36,10 +42,11 @@ func NewService(c Cacher, s Storer, codeLength int) *Service {
 }
 
 type CreateRequest struct {
-	LongURL    string
-	ExpiresAt  *time.Time
-	CustomCode string
-	UserID     *int
+	LongURL      string
+	ExpiresAt    *time.Time
+	CustomCode   string
+	UserID       *int
+	RedirectType int // 0 = configured default
 }
 
 type CreateResult struct {
@@ -52,6 +59,9 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*CreateResult
 	if err := s.validateURL(req.LongURL); err != nil {
 		return nil, err
 	}
+	if req.RedirectType != 0 && !IsValidRedirectType(req.RedirectType) {
+		return nil, ErrInvalidRedirectType
+	}
 
 	// Determine code
 	code := req.CustomCode
@@ -77,19 +87,76 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*CreateResult
 	}
 
 	// Create link
-	if err := s.store.CreateLink(ctx, code, req.LongURL, req.ExpiresAt, req.UserID); err != nil {
+	if err := s.store.CreateLink(ctx, code, req.LongURL, req.ExpiresAt, req.UserID, req.RedirectType); err != nil {
 		return nil, err
 	}
 
-	// Pre-warm cache
-	_ = s.cache.SetURL(ctx, code, req.LongURL)
+	now := time.Now()
+	created := &store.Link{
+		Code:         code,
+		LongURL:      req.LongURL,
+		CreatedAt:    now,
+		RedirectType: req.RedirectType,
+	}
+	if req.ExpiresAt != nil {
+		created.ExpiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+	}
+	if req.UserID != nil {
+		created.UserID = sql.NullInt32{Int32: int32(*req.UserID), Valid: true}
+	}
+
+	// Drop any negative entry, then pre-warm cache
+	s.invalidate(ctx, code)
+	_ = s.cache.SetLink(ctx, created)
 
 	return &CreateResult{
 		Code:      code,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
 	}, nil
 }
 
+// Update changes a link's target, expiry and redirect type.
+// redirectType nil keeps the current value.
+// userID nil means admin, otherwise only user's own links.
+func (s *Service) Update(ctx context.Context, code, longURL string, expiresAt *time.Time, redirectType *int, userID *int) error {
+	if redirectType != nil && *redirectType != 0 && !IsValidRedirectType(*redirectType) {
+		return ErrInvalidRedirectType
+	}
+	if err := s.store.UpdateLink(ctx, code, longURL, expiresAt, redirectType, userID); err != nil {
+		return err
+	}
+	s.invalidate(ctx, code)
+	return nil
+}
+
+// SetDisabled enables or disables a link.
+// userID nil means admin, otherwise only user's own links.
+func (s *Service) SetDisabled(ctx context.Context, code string, disabled bool, userID *int) error {
+	if err := s.store.SetLinkDisabled(ctx, code, disabled, userID); err != nil {
+		return err
+	}
+	s.invalidate(ctx, code)
+	return nil
+}
+
+// Delete removes a link.
+// userID nil means admin, otherwise only user's own links.
+func (s *Service) Delete(ctx context.Context, code string, userID *int) error {
+	if err := s.store.DeleteLink(ctx, code, userID); err != nil {
+		return err
+	}
+	s.invalidate(ctx, code)
+	return nil
+}
+
+// invalidate drops cached state for code on every instance.
+// The database is already updated, so failures are logged, not returned.
+func (s *Service) invalidate(ctx context.Context, code string) {
+	if err := s.cache.Invalidate(ctx, code); err != nil {
+		logger.Error("cache invalidation failed", logger.Code(code), logger.Err(err))
+	}
+}
+
 func (s *Service) validateURL(rawURL string) error {
 	if len(rawURL) > 2048 {
 		return ErrURLTooLong
@@ -148,6 +215,15 @@ func isValidCode(code string) bool {
 	return true
 }
 
+// IsValidRedirectType reports whether code is a supported redirect status.
+func IsValidRedirectType(code int) bool {
+	switch code {
+	case 301, 302, 307, 308:
+		return true
+	}
+	return false
+}
+
 func isPrivateHost(host string) bool {
 	ip := net.ParseIP(host)
 	if ip == nil {
@@ -183,10 +259,11 @@ func isPrivateHost(host string) bool {
 
 // BatchCreateRequest holds a single item in batch create.
 type BatchCreateRequest struct {
-	LongURL    string
-	ExpiresAt  *time.Time
-	CustomCode string
-	UserID     *int
+	LongURL      string
+	ExpiresAt    *time.Time
+	CustomCode   string
+	UserID       *int
+	RedirectType int
 }
 
 // BatchCreateResult holds the result for a single item.
@@ -201,10 +278,11 @@ func (s *Service) BatchCreate(ctx context.Context, requests []BatchCreateRequest
 	results := make([]BatchCreateResult, len(requests))
 	for i, req := range requests {
 		result, err := s.Create(ctx, &CreateRequest{
-			LongURL:    req.LongURL,
-			ExpiresAt:  req.ExpiresAt,
-			CustomCode: req.CustomCode,
-			UserID:     req.UserID,
+			LongURL:      req.LongURL,
+			ExpiresAt:    req.ExpiresAt,
+			CustomCode:   req.CustomCode,
+			UserID:       req.UserID,
+			RedirectType: req.RedirectType,
 		})
 		if err != nil {
 			results[i] = BatchCreateResult{Index: i, Error: err}
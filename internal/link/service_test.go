@@ -2,6 +2,7 @@ package link
 
 import (
 	"context"
+	"database/sql"
 	"strings"
 	"testing"
 	"time"
@@ -23,31 +24,72 @@ func (m *mockStore) GetLink(_ context.Context, code string) (*store.Link, error)
 	return m.links[code], nil
 }
 
-func (m *mockStore) CreateLink(_ context.Context, code, longURL string, expiresAt *time.Time, _ *int) error {
+func (m *mockStore) CreateLink(_ context.Context, code, longURL string, expiresAt *time.Time, _ *int, redirectType int) error {
 	if m.err != nil {
 		return m.err
 	}
-	m.links[code] = &store.Link{Code: code, LongURL: longURL}
+	m.links[code] = &store.Link{Code: code, LongURL: longURL, RedirectType: redirectType}
+	return nil
+}
+
+func (m *mockStore) UpdateLink(_ context.Context, code, longURL string, _ *time.Time, redirectType *int, _ *int) error {
+	if m.err != nil {
+		return m.err
+	}
+	l := m.links[code]
+	if l == nil {
+		return sql.ErrNoRows
+	}
+	l.LongURL = longURL
+	if redirectType != nil {
+		l.RedirectType = *redirectType
+	}
+	return nil
+}
+
+func (m *mockStore) SetLinkDisabled(_ context.Context, code string, disabled bool, _ *int) error {
+	if m.err != nil {
+		return m.err
+	}
+	l := m.links[code]
+	if l == nil {
+		return sql.ErrNoRows
+	}
+	l.IsDisabled = disabled
+	return nil
+}
+
+func (m *mockStore) DeleteLink(_ context.Context, code string, _ *int) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.links[code] == nil {
+		return sql.ErrNoRows
+	}
+	delete(m.links, code)
 	return nil
 }
 
 type mockCache struct {
-	urls map[string]string
-	err  error
+	urls        map[string]string
+	invalidated []string // track Invalidate calls
+	err         error
 }
 
-func (m *mockCache) GetURL(_ context.Context, code string) (string, error) {
+func (m *mockCache) SetLink(_ context.Context, link *store.Link) error {
 	if m.err != nil {
-		return "", m.err
+		return m.err
 	}
-	return m.urls[code], nil
+	m.urls[link.Code] = link.LongURL
+	return nil
 }
 
-func (m *mockCache) SetURL(_ context.Context, code, url string) error {
+func (m *mockCache) Invalidate(_ context.Context, code string) error {
 	if m.err != nil {
 		return m.err
 	}
-	m.urls[code] = url
+	delete(m.urls, code)
+	m.invalidated = append(m.invalidated, code)
 	return nil
 }
 
@@ -238,6 +280,31 @@ func TestCreate(t *testing.T) {
 			t.Errorf("expected ErrInvalidURL, got %v", err)
 		}
 	})
+
+	t.Run("custom redirect type", func(t *testing.T) {
+		ms := &mockStore{links: make(map[string]*store.Link)}
+		mc := &mockCache{urls: make(map[string]string)}
+		svc := NewService(mc, ms, 8)
+
+		result, err := svc.Create(ctx, &CreateRequest{LongURL: "https://example.com", RedirectType: 301})
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if ms.links[result.Code].RedirectType != 301 {
+			t.Errorf("expected redirect type 301, got %d", ms.links[result.Code].RedirectType)
+		}
+	})
+
+	t.Run("invalid redirect type", func(t *testing.T) {
+		ms := &mockStore{links: make(map[string]*store.Link)}
+		mc := &mockCache{urls: make(map[string]string)}
+		svc := NewService(mc, ms, 8)
+
+		_, err := svc.Create(ctx, &CreateRequest{LongURL: "https://example.com", RedirectType: 303})
+		if err != ErrInvalidRedirectType {
+			t.Errorf("expected ErrInvalidRedirectType, got %v", err)
+		}
+	})
 }
 
 func TestBatchCreate(t *testing.T) {
@@ -303,3 +370,88 @@ func TestGetLongURL(t *testing.T) {
 		}
 	})
 }
+
+func TestMutationsInvalidateCache(t *testing.T) {
+	ctx := context.Background()
+
+	newSvc := func() (*Service, *mockStore, *mockCache) {
+		ms := &mockStore{links: map[string]*store.Link{
+			"abc123": {Code: "abc123", LongURL: "https://example.com"},
+		}}
+		mc := &mockCache{urls: map[string]string{"abc123": "https://example.com"}}
+		return NewService(mc, ms, 8), ms, mc
+	}
+
+	t.Run("create clears negative cache", func(t *testing.T) {
+		svc, _, mc := newSvc()
+		if _, err := svc.Create(ctx, &CreateRequest{LongURL: "https://example.com", CustomCode: "fresh1"}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if len(mc.invalidated) != 1 || mc.invalidated[0] != "fresh1" {
+			t.Errorf("expected fresh1 invalidated, got %v", mc.invalidated)
+		}
+		if mc.urls["fresh1"] != "https://example.com" {
+			t.Error("cache should be pre-warmed after invalidation")
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		svc, ms, mc := newSvc()
+		if err := svc.Update(ctx, "abc123", "https://new.example.com", nil, nil, nil); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if ms.links["abc123"].LongURL != "https://new.example.com" {
+			t.Error("link not updated")
+		}
+		if _, ok := mc.urls["abc123"]; ok {
+			t.Error("cache entry should be invalidated")
+		}
+	})
+
+	t.Run("update invalid redirect type", func(t *testing.T) {
+		svc, _, mc := newSvc()
+		rt := 200
+		if err := svc.Update(ctx, "abc123", "https://new.example.com", nil, &rt, nil); err != ErrInvalidRedirectType {
+			t.Errorf("expected ErrInvalidRedirectType, got %v", err)
+		}
+		if len(mc.invalidated) != 0 {
+			t.Error("failed update should not invalidate")
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		svc, ms, mc := newSvc()
+		if err := svc.SetDisabled(ctx, "abc123", true, nil); err != nil {
+			t.Fatalf("SetDisabled failed: %v", err)
+		}
+		if !ms.links["abc123"].IsDisabled {
+			t.Error("link not disabled")
+		}
+		if _, ok := mc.urls["abc123"]; ok {
+			t.Error("cache entry should be invalidated")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		svc, ms, mc := newSvc()
+		if err := svc.Delete(ctx, "abc123", nil); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if ms.links["abc123"] != nil {
+			t.Error("link not deleted")
+		}
+		if _, ok := mc.urls["abc123"]; ok {
+			t.Error("cache entry should be invalidated")
+		}
+	})
+
+	t.Run("delete not found", func(t *testing.T) {
+		svc, _, mc := newSvc()
+		if err := svc.Delete(ctx, "nolink", nil); err != sql.ErrNoRows {
+			t.Errorf("expected sql.ErrNoRows, got %v", err)
+		}
+		if len(mc.invalidated) != 0 {
+			t.Error("failed delete should not invalidate")
+		}
+	})
+}
@@ -24,6 +24,13 @@ var (
 		[]string{},
 	)
 
+	RedirectCoalesced = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "redirect_coalesced_total",
+			Help: "Redirect cache misses served by another request's in-flight DB lookup",
+		},
+	)
+
 	// Cache metrics
 	CacheHits = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -39,6 +46,44 @@ var (
 		},
 	)
 
+	CacheTierHits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_tier_hits_total",
+			Help: "Link cache hits by tier (local, redis)",
+		},
+		[]string{"tier"},
+	)
+
+	CacheTierMisses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_tier_misses_total",
+			Help: "Link cache misses by tier (local, redis)",
+		},
+		[]string{"tier"},
+	)
+
+	CacheErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_errors_total",
+			Help: "Redis cache errors on the redirect path by operation",
+		},
+		[]string{"operation"},
+	)
+
+	RedisDegraded = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "redis_degraded",
+			Help: "1 while the Redis circuit breaker is open (serving from Postgres)",
+		},
+	)
+
+	CacheInvalidations = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_invalidations_total",
+			Help: "Total link cache invalidations published",
+		},
+	)
+
 	// Database metrics
 	DBQueries = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -65,17 +110,112 @@ var (
 		},
 	)
 
+	ClickEventsBuffered = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "click_events_buffered",
+			Help: "Click events held in memory while the stream is unavailable",
+		},
+	)
+
+	ClickEventsDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "click_events_dropped_total",
+			Help: "Click events dropped because the local buffer was full",
+		},
+	)
+
 	ClickEventsProcessed = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "click_events_processed_total",
-			Help: "Total click events processed",
+			Help: "Total click events persisted and acknowledged",
+		},
+	)
+
+	ClickEventsFailed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "click_events_failed_total",
+			Help: "Total click events whose insert failed (left pending or dead-lettered)",
+		},
+	)
+
+	ClickEventsReclaimed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "click_events_reclaimed_total",
+			Help: "Total pending click events reclaimed from idle consumers",
+		},
+	)
+
+	ClickEventsDeadLettered = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "click_events_dead_lettered_total",
+			Help: "Total click events moved to the dead-letter stream",
+		},
+	)
+
+	ClickRollupHours = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "click_rollup_hours_total",
+			Help: "Total hours of click events rolled up (including late-click recomputes)",
 		},
 	)
 
 	StreamLag = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "stream_lag_messages",
-			Help: "Number of pending messages in stream",
+			Help: "Stream entries not yet delivered to the consumer group",
 		},
 	)
+
+	StreamPending = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stream_pending_messages",
+			Help: "Stream entries delivered but not yet acknowledged",
+		},
+	)
+
+	StreamLength = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stream_length_messages",
+			Help: "Entries in the click stream (bounded by STREAM_MAX_LEN)",
+		},
+	)
+
+	StreamOldestPendingAge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stream_oldest_pending_age_seconds",
+			Help: "Age of the oldest unacknowledged stream entry",
+		},
+	)
+
+	// Live feed metrics
+	LiveFeedSubscribers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "live_feed_subscribers",
+			Help: "Clients connected to the live click feed",
+		},
+	)
+
+	LiveFeedDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "live_feed_dropped_total",
+			Help: "Live click events dropped for subscribers that fell behind",
+		},
+	)
+
+	// Alert metrics
+	AlertsFired = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alerts_fired_total",
+			Help: "Alert rules fired by kind",
+		},
+		[]string{"kind"},
+	)
+
+	WebhookDeliveries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Alert webhook delivery attempts by resulting status (delivered, pending = will retry, failed)",
+		},
+		[]string{"status"},
+	)
 )
@@ -0,0 +1,12 @@
+package partition
+
+import (
+	"context"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// Storer defines the store operations needed by the partition job.
+type Storer interface {
+	MaintainClickPartitions(ctx context.Context, policy store.PartitionPolicy) (created, removed []string, err error)
+}
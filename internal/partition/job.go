@@ -0,0 +1,54 @@
+package partition
+
+import (
+	"context"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+// Job keeps click_events partitions ahead of time and enforces retention.
+// Every consumer process runs one; the store's advisory lock lets only one
+// of them work at a time.
+type Job struct {
+	store    Storer
+	interval time.Duration
+	policy   store.PartitionPolicy
+}
+
+func NewJob(s Storer, interval time.Duration, policy store.PartitionPolicy) *Job {
+	return &Job{store: s, interval: interval, policy: policy}
+}
+
+// Start runs the job until ctx is done.
+func (j *Job) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			j.run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *Job) run(ctx context.Context) {
+	created, removed, err := j.store.MaintainClickPartitions(ctx, j.policy)
+	if err != nil {
+		logger.Error("click partition maintenance failed", logger.Err(err))
+		return
+	}
+	for _, name := range created {
+		logger.Info("created click partition", logger.Extra("partition", name))
+	}
+	for _, name := range removed {
+		// Detached partitions are reported under their archived name
+		logger.Info("removed expired click partition", logger.Extra("partition", name),
+			logger.Extra("detached", j.policy.Detach))
+	}
+}
@@ -0,0 +1,45 @@
+package partition
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wyp0596/go2short/internal/store"
+)
+
+type mockStore struct {
+	policies []store.PartitionPolicy
+	created  []string
+	removed  []string
+	err      error
+}
+
+func (m *mockStore) MaintainClickPartitions(ctx context.Context, policy store.PartitionPolicy) ([]string, []string, error) {
+	m.policies = append(m.policies, policy)
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	return m.created, m.removed, nil
+}
+
+func TestRunPassesPolicy(t *testing.T) {
+	policy := store.PartitionPolicy{Ahead: 2, RetentionMonths: 12, Detach: true}
+	s := &mockStore{created: []string{"click_events_y2026m12"}, removed: []string{"click_events_y2025m09"}}
+	NewJob(s, 0, policy).run(context.Background())
+
+	if len(s.policies) != 1 || s.policies[0] != policy {
+		t.Errorf("expected one run with %+v, got %+v", policy, s.policies)
+	}
+}
+
+func TestRunToleratesErrors(t *testing.T) {
+	s := &mockStore{err: errors.New("db down")}
+	j := NewJob(s, 0, store.PartitionPolicy{Ahead: 2})
+	j.run(context.Background())
+	j.run(context.Background())
+
+	if len(s.policies) != 2 {
+		t.Errorf("expected the job to keep running after errors, got %d runs", len(s.policies))
+	}
+}
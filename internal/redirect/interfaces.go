@@ -13,8 +13,8 @@ type Storer interface {
 
 // Cacher defines the cache operations needed by redirect service.
 type Cacher interface {
-	GetURL(ctx context.Context, code string) (string, error)
-	SetURL(ctx context.Context, code, url string) error
+	GetLink(ctx context.Context, code string) (*store.Link, error)
+	SetLink(ctx context.Context, link *store.Link) error
 	IsMiss(ctx context.Context, code string) (bool, error)
 	SetMiss(ctx context.Context, code string) error
 }
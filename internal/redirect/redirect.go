@@ -4,80 +4,142 @@ import (
 	"context"
 	"regexp"
 	"time"
+
+	"github.com/wyp0596/go2short/internal/metrics"
+	"github.com/wyp0596/go2short/internal/store"
+	"golang.org/x/sync/singleflight"
 )
 
 var base62Regex = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
 
+// loadTimeout bounds a coalesced database lookup, which runs detached from
+// the request that started it.
+const loadTimeout = 5 * time.Second
+
 type Result struct {
 	URL        string
-	StatusCode int // 302, 404, 410
+	StatusCode int // 301, 302, 307, 308, 404, 410
 	CacheHit   bool
 }
 
 type Service struct {
-	cache      Cacher
-	store      Storer
-	codeLength int
+	cache         Cacher
+	store         Storer
+	codeLength    int
+	defaultStatus int
+	group         singleflight.Group // coalesces concurrent misses per code
 }
 
-func NewService(c Cacher, s Storer, codeLength int) *Service {
+// NewService creates a redirect service. defaultStatus is used for links
+// without a per-link redirect type; invalid values fall back to 302.
+func NewService(c Cacher, s Storer, codeLength, defaultStatus int) *Service {
+	if !IsRedirect(defaultStatus) {
+		defaultStatus = 302
+	}
 	return &Service{
-		cache:      c,
-		store:      s,
-		codeLength: codeLength,
+		cache:         c,
+		store:         s,
+		codeLength:    codeLength,
+		defaultStatus: defaultStatus,
+	}
+}
+
+// IsRedirect reports whether status is a redirect status served by Resolve.
+func IsRedirect(status int) bool {
+	switch status {
+	case 301, 302, 307, 308:
+		return true
+	}
+	return false
+}
+
+// statusFor returns the redirect status for a link's redirect type.
+func (s *Service) statusFor(redirectType int) int {
+	if IsRedirect(redirectType) {
+		return redirectType
 	}
+	return s.defaultStatus
 }
 
 // Resolve looks up a short code and returns the target URL.
 // Flow: cache -> negative cache -> database -> backfill cache
+// Cache errors are not fatal: lookups fall back to the database.
 func (s *Service) Resolve(ctx context.Context, code string) (*Result, error) {
 	// 1. Validate code format
 	if !s.isValidCode(code) {
 		return &Result{StatusCode: 404}, nil
 	}
 
-	// 2. Check Redis cache
-	url, err := s.cache.GetURL(ctx, code)
-	if err != nil {
-		return nil, err
-	}
-	if url != "" {
-		return &Result{URL: url, StatusCode: 302, CacheHit: true}, nil
+	// 2. Check Redis cache (fail open: on error fall through to the database)
+	link, err := s.cache.GetLink(ctx, code)
+	if err == nil {
+		if link != nil {
+			return s.result(link, true), nil
+		}
+
+		// 3. Check negative cache
+		if isMiss, err := s.cache.IsMiss(ctx, code); err == nil && isMiss {
+			return &Result{StatusCode: 404, CacheHit: true}, nil
+		}
 	}
 
-	// 3. Check negative cache
-	isMiss, err := s.cache.IsMiss(ctx, code)
+	// 4-6. Query database and backfill, once per code for concurrent misses
+	link, err = s.load(ctx, code)
 	if err != nil {
 		return nil, err
 	}
-	if isMiss {
-		return &Result{StatusCode: 404, CacheHit: true}, nil
+	if link == nil {
+		return &Result{StatusCode: 404}, nil
 	}
+	return s.result(link, false), nil
+}
+
+// load queries the database and backfills the cache. Concurrent calls for
+// the same code share one store lookup. The lookup does not inherit the
+// cancellation of the call that starts it, so a client disconnecting does
+// not fail the others waiting on it; loadTimeout bounds it instead.
+func (s *Service) load(ctx context.Context, code string) (*store.Link, error) {
+	leader := false
+	v, err, shared := s.group.Do(code, func() (any, error) {
+		leader = true
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+
+		// 4. Query database
+		link, err := s.store.GetLink(ctx, code)
+		if err != nil {
+			return nil, err
+		}
 
-	// 4. Query database
-	link, err := s.store.GetLink(ctx, code)
+		// 5. Not found -> set negative cache
+		if link == nil {
+			_ = s.cache.SetMiss(ctx, code) // ignore error, non-critical
+			return nil, nil
+		}
+
+		// 6. Backfill cache (disabled/expired records too, so 410 is served from cache)
+		_ = s.cache.SetLink(ctx, link) // ignore error, non-critical
+		return link, nil
+	})
+	if shared && !leader {
+		metrics.RedirectCoalesced.Inc()
+	}
 	if err != nil {
 		return nil, err
 	}
+	link, _ := v.(*store.Link)
+	return link, nil
+}
 
-	// 5. Not found -> set negative cache
-	if link == nil {
-		_ = s.cache.SetMiss(ctx, code) // ignore error, non-critical
-		return &Result{StatusCode: 404}, nil
-	}
-
-	// 6. Check disabled/expired
+// result maps a link record to a redirect result, enforcing disabled/expired state.
+func (s *Service) result(link *store.Link, cacheHit bool) *Result {
 	if link.IsDisabled {
-		return &Result{StatusCode: 410}, nil
+		return &Result{StatusCode: 410, CacheHit: cacheHit}
 	}
 	if link.ExpiresAt.Valid && link.ExpiresAt.Time.Before(time.Now()) {
-		return &Result{StatusCode: 410}, nil
+		return &Result{StatusCode: 410, CacheHit: cacheHit}
 	}
-
-	// 7. Backfill cache
-	_ = s.cache.SetURL(ctx, code, link.LongURL) // ignore error, non-critical
-
-	return &Result{URL: link.LongURL, StatusCode: 302}, nil
+	return &Result{URL: link.LongURL, StatusCode: s.statusFor(link.RedirectType), CacheHit: cacheHit}
 }
 
 func (s *Service) isValidCode(code string) bool {
@@ -3,6 +3,9 @@ package redirect
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,11 +15,28 @@ import (
 // --- Mock implementations ---
 
 type mockStore struct {
-	links map[string]*store.Link
-	err   error
+	links    map[string]*store.Link
+	err      error
+	calls    atomic.Int32
+	started  chan struct{} // if set, receives a value when GetLink is called
+	release  chan struct{} // if set, GetLink blocks until closed
+	deadline atomic.Bool   // GetLink was called with a deadline
 }
 
-func (m *mockStore) GetLink(_ context.Context, code string) (*store.Link, error) {
+func (m *mockStore) GetLink(ctx context.Context, code string) (*store.Link, error) {
+	m.calls.Add(1)
+	if _, ok := ctx.Deadline(); ok {
+		m.deadline.Store(true)
+	}
+	if m.started != nil {
+		m.started <- struct{}{}
+	}
+	if m.release != nil {
+		<-m.release
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if m.err != nil {
 		return nil, m.err
 	}
@@ -24,29 +44,40 @@ func (m *mockStore) GetLink(_ context.Context, code string) (*store.Link, error)
 }
 
 type mockCache struct {
-	urls    map[string]string
-	misses  map[string]bool
-	err     error
-	setURLs []string // track SetURL calls
+	mu       sync.Mutex
+	links    map[string]*store.Link
+	misses   map[string]bool
+	err      error
+	setLinks []string      // track SetLink calls
+	arrived  chan struct{} // if set, receives a value when IsMiss is called
 }
 
-func (m *mockCache) GetURL(_ context.Context, code string) (string, error) {
+func (m *mockCache) GetLink(_ context.Context, code string) (*store.Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
-		return "", m.err
+		return nil, m.err
 	}
-	return m.urls[code], nil
+	return m.links[code], nil
 }
 
-func (m *mockCache) SetURL(_ context.Context, code, url string) error {
+func (m *mockCache) SetLink(_ context.Context, link *store.Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
 		return m.err
 	}
-	m.urls[code] = url
-	m.setURLs = append(m.setURLs, code)
+	m.links[link.Code] = link
+	m.setLinks = append(m.setLinks, link.Code)
 	return nil
 }
 
 func (m *mockCache) IsMiss(_ context.Context, code string) (bool, error) {
+	if m.arrived != nil {
+		m.arrived <- struct{}{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
 		return false, m.err
 	}
@@ -54,6 +85,8 @@ func (m *mockCache) IsMiss(_ context.Context, code string) (bool, error) {
 }
 
 func (m *mockCache) SetMiss(_ context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.err != nil {
 		return m.err
 	}
@@ -100,11 +133,11 @@ func TestResolve(t *testing.T) {
 
 	t.Run("cache hit", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   map[string]string{"abc123": "https://example.com"},
+			links:  map[string]*store.Link{"abc123": {Code: "abc123", LongURL: "https://example.com"}},
 			misses: make(map[string]bool),
 		}
 		ms := &mockStore{links: make(map[string]*store.Link)}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "abc123")
 		if err != nil {
@@ -123,11 +156,11 @@ func TestResolve(t *testing.T) {
 
 	t.Run("negative cache hit", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: map[string]bool{"notfnd": true},
 		}
 		ms := &mockStore{links: make(map[string]*store.Link)}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "notfnd")
 		if err != nil {
@@ -143,13 +176,13 @@ func TestResolve(t *testing.T) {
 
 	t.Run("db hit and cache backfill", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: make(map[string]bool),
 		}
 		ms := &mockStore{links: map[string]*store.Link{
 			"dbcode": {Code: "dbcode", LongURL: "https://db.example.com"},
 		}}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "dbcode")
 		if err != nil {
@@ -165,18 +198,18 @@ func TestResolve(t *testing.T) {
 			t.Errorf("expected https://db.example.com, got %q", result.URL)
 		}
 		// Verify cache was backfilled
-		if mc.urls["dbcode"] != "https://db.example.com" {
+		if mc.links["dbcode"] == nil || mc.links["dbcode"].LongURL != "https://db.example.com" {
 			t.Error("cache should be backfilled")
 		}
 	})
 
 	t.Run("not found sets negative cache", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: make(map[string]bool),
 		}
 		ms := &mockStore{links: make(map[string]*store.Link)}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "nolink")
 		if err != nil {
@@ -193,13 +226,13 @@ func TestResolve(t *testing.T) {
 
 	t.Run("disabled link returns 410", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: make(map[string]bool),
 		}
 		ms := &mockStore{links: map[string]*store.Link{
 			"disabled": {Code: "disabled", LongURL: "https://example.com", IsDisabled: true},
 		}}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "disabled")
 		if err != nil {
@@ -212,7 +245,7 @@ func TestResolve(t *testing.T) {
 
 	t.Run("expired link returns 410", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: make(map[string]bool),
 		}
 		pastTime := time.Now().Add(-24 * time.Hour)
@@ -223,7 +256,7 @@ func TestResolve(t *testing.T) {
 				ExpiresAt: sql.NullTime{Time: pastTime, Valid: true},
 			},
 		}}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "expired")
 		if err != nil {
@@ -236,11 +269,11 @@ func TestResolve(t *testing.T) {
 
 	t.Run("invalid code format returns 404", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: make(map[string]bool),
 		}
 		ms := &mockStore{links: make(map[string]*store.Link)}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "bad-code")
 		if err != nil {
@@ -253,11 +286,11 @@ func TestResolve(t *testing.T) {
 
 	t.Run("too short code returns 404", func(t *testing.T) {
 		mc := &mockCache{
-			urls:   make(map[string]string),
+			links:  make(map[string]*store.Link),
 			misses: make(map[string]bool),
 		}
 		ms := &mockStore{links: make(map[string]*store.Link)}
-		svc := NewService(mc, ms, 8)
+		svc := NewService(mc, ms, 8, 302)
 
 		result, err := svc.Resolve(ctx, "abc")
 		if err != nil {
@@ -267,4 +300,276 @@ func TestResolve(t *testing.T) {
 			t.Errorf("expected 404, got %d", result.StatusCode)
 		}
 	})
+	t.Run("per-link redirect type", func(t *testing.T) {
+		mc := &mockCache{
+			links:  make(map[string]*store.Link),
+			misses: make(map[string]bool),
+		}
+		ms := &mockStore{links: map[string]*store.Link{
+			"perm01": {Code: "perm01", LongURL: "https://example.com", RedirectType: 301},
+		}}
+		svc := NewService(mc, ms, 8, 302)
+
+		result, err := svc.Resolve(ctx, "perm01")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if result.StatusCode != 301 {
+			t.Errorf("expected 301 from DB, got %d", result.StatusCode)
+		}
+
+		// Second lookup is served from cache and keeps the type
+		result, err = svc.Resolve(ctx, "perm01")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if !result.CacheHit || result.StatusCode != 301 {
+			t.Errorf("expected cached 301, got %d (hit=%v)", result.StatusCode, result.CacheHit)
+		}
+	})
+
+	t.Run("configured default status", func(t *testing.T) {
+		mc := &mockCache{
+			links:  map[string]*store.Link{"abc123": {Code: "abc123", LongURL: "https://example.com"}},
+			misses: make(map[string]bool),
+		}
+		ms := &mockStore{links: make(map[string]*store.Link)}
+
+		result, err := NewService(mc, ms, 8, 308).Resolve(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if result.StatusCode != 308 {
+			t.Errorf("expected 308, got %d", result.StatusCode)
+		}
+
+		// Invalid default falls back to 302
+		result, err = NewService(mc, ms, 8, 200).Resolve(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if result.StatusCode != 302 {
+			t.Errorf("expected 302 fallback, got %d", result.StatusCode)
+		}
+	})
+	t.Run("cached disabled link returns 410", func(t *testing.T) {
+		mc := &mockCache{
+			links: map[string]*store.Link{
+				"disabled": {Code: "disabled", LongURL: "https://example.com", IsDisabled: true},
+			},
+			misses: make(map[string]bool),
+		}
+		ms := &mockStore{err: errors.New("store should not be called")}
+		svc := NewService(mc, ms, 8, 302)
+
+		result, err := svc.Resolve(ctx, "disabled")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if result.StatusCode != 410 || !result.CacheHit {
+			t.Errorf("expected cached 410, got %d (hit=%v)", result.StatusCode, result.CacheHit)
+		}
+	})
+
+	t.Run("cached expired link returns 410", func(t *testing.T) {
+		mc := &mockCache{
+			links: map[string]*store.Link{
+				"expired": {
+					Code:      "expired",
+					LongURL:   "https://example.com",
+					ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true},
+				},
+			},
+			misses: make(map[string]bool),
+		}
+		ms := &mockStore{err: errors.New("store should not be called")}
+		svc := NewService(mc, ms, 8, 302)
+
+		result, err := svc.Resolve(ctx, "expired")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if result.StatusCode != 410 || !result.CacheHit {
+			t.Errorf("expected cached 410, got %d (hit=%v)", result.StatusCode, result.CacheHit)
+		}
+	})
+
+	t.Run("disabled link from db is cached", func(t *testing.T) {
+		mc := &mockCache{
+			links:  make(map[string]*store.Link),
+			misses: make(map[string]bool),
+		}
+		ms := &mockStore{links: map[string]*store.Link{
+			"disabled": {Code: "disabled", LongURL: "https://example.com", IsDisabled: true},
+		}}
+		svc := NewService(mc, ms, 8, 302)
+
+		if _, err := svc.Resolve(ctx, "disabled"); err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if mc.links["disabled"] == nil || !mc.links["disabled"].IsDisabled {
+			t.Error("disabled record should be backfilled into cache")
+		}
+	})
+	t.Run("cache error falls back to db", func(t *testing.T) {
+		mc := &mockCache{
+			links:  make(map[string]*store.Link),
+			misses: make(map[string]bool),
+			err:    errors.New("redis down"),
+		}
+		ms := &mockStore{links: map[string]*store.Link{
+			"dbcode": {Code: "dbcode", LongURL: "https://db.example.com"},
+		}}
+		svc := NewService(mc, ms, 8, 302)
+
+		result, err := svc.Resolve(ctx, "dbcode")
+		if err != nil {
+			t.Fatalf("Resolve should fail open, got %v", err)
+		}
+		if result.StatusCode != 302 || result.URL != "https://db.example.com" {
+			t.Errorf("expected 302 to db URL, got %d %q", result.StatusCode, result.URL)
+		}
+
+		result, err = svc.Resolve(ctx, "nolink")
+		if err != nil {
+			t.Fatalf("Resolve should fail open, got %v", err)
+		}
+		if result.StatusCode != 404 {
+			t.Errorf("expected 404, got %d", result.StatusCode)
+		}
+	})
+}
+
+// resolveInFlight calls Resolve n times concurrently, releasing the store
+// only once every call has missed the cache and the lookup has started.
+func resolveInFlight(svc *Service, ms *mockStore, mc *mockCache, n int, code string) ([]*Result, []error) {
+	ms.started = make(chan struct{}, n)
+	ms.release = make(chan struct{})
+	mc.arrived = make(chan struct{}, n)
+
+	results := make([]*Result, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = svc.Resolve(context.Background(), code)
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		<-mc.arrived
+	}
+	<-ms.started
+	close(ms.release)
+	wg.Wait()
+	return results, errs
+}
+
+func TestResolveCoalescesConcurrentMisses(t *testing.T) {
+	const n = 50
+
+	t.Run("found", func(t *testing.T) {
+		ms := &mockStore{links: map[string]*store.Link{"viral1": {Code: "viral1", LongURL: "https://example.com"}}}
+		mc := &mockCache{links: make(map[string]*store.Link), misses: make(map[string]bool)}
+
+		results, errs := resolveInFlight(NewService(mc, ms, 8, 302), ms, mc, n, "viral1")
+		for i, r := range results {
+			if errs[i] != nil {
+				t.Fatalf("Resolve[%d] failed: %v", i, errs[i])
+			}
+			if r.StatusCode != 302 || r.URL != "https://example.com" {
+				t.Errorf("result[%d] = %d %q", i, r.StatusCode, r.URL)
+			}
+		}
+		if calls := ms.calls.Load(); calls != 1 {
+			t.Errorf("expected 1 store call, got %d", calls)
+		}
+		if len(mc.setLinks) != 1 {
+			t.Errorf("expected 1 cache backfill, got %d", len(mc.setLinks))
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		ms := &mockStore{links: make(map[string]*store.Link)}
+		mc := &mockCache{links: make(map[string]*store.Link), misses: make(map[string]bool)}
+
+		results, errs := resolveInFlight(NewService(mc, ms, 8, 302), ms, mc, n, "nolink")
+		for i, r := range results {
+			if errs[i] != nil {
+				t.Fatalf("Resolve[%d] failed: %v", i, errs[i])
+			}
+			if r.StatusCode != 404 {
+				t.Errorf("result[%d] = %d, want 404", i, r.StatusCode)
+			}
+		}
+		if calls := ms.calls.Load(); calls != 1 {
+			t.Errorf("expected 1 store call, got %d", calls)
+		}
+		if !mc.misses["nolink"] {
+			t.Error("negative cache should be set")
+		}
+	})
+
+	t.Run("error is shared", func(t *testing.T) {
+		ms := &mockStore{err: errors.New("db down")}
+		mc := &mockCache{links: make(map[string]*store.Link), misses: make(map[string]bool)}
+
+		_, errs := resolveInFlight(NewService(mc, ms, 8, 302), ms, mc, n, "broken")
+		for i, err := range errs {
+			if err == nil {
+				t.Errorf("Resolve[%d] should fail", i)
+			}
+		}
+		if calls := ms.calls.Load(); calls != 1 {
+			t.Errorf("expected 1 store call, got %d", calls)
+		}
+	})
+}
+
+func TestResolveLeaderCancelDoesNotFailWaiters(t *testing.T) {
+	ms := &mockStore{
+		links:   map[string]*store.Link{"viral1": {Code: "viral1", LongURL: "https://example.com"}},
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	mc := &mockCache{links: make(map[string]*store.Link), misses: make(map[string]bool), arrived: make(chan struct{}, 2)}
+	svc := NewService(mc, ms, 8, 302)
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		svc.Resolve(leaderCtx, "viral1")
+	}()
+	<-mc.arrived
+	<-ms.started
+
+	var result *Result
+	var err error
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		result, err = svc.Resolve(context.Background(), "viral1")
+	}()
+	<-mc.arrived
+
+	// The first client goes away while the lookup is in flight
+	cancel()
+	close(ms.release)
+	<-leaderDone
+	<-waiterDone
+
+	if err != nil {
+		t.Fatalf("waiter failed with the leader's cancellation: %v", err)
+	}
+	if result.StatusCode != 302 || result.URL != "https://example.com" {
+		t.Errorf("result = %d %q", result.StatusCode, result.URL)
+	}
+	if !ms.deadline.Load() {
+		t.Error("expected the lookup to be bounded by a timeout")
+	}
+	if len(mc.setLinks) != 1 {
+		t.Errorf("expected the cache to be backfilled, got %d", len(mc.setLinks))
+	}
 }
@@ -0,0 +1,8 @@
+package rollup
+
+import "context"
+
+// Storer defines the store operations needed by the rollup job.
+type Storer interface {
+	RollupClicks(ctx context.Context, maxHours int) (int, error)
+}
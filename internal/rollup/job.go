@@ -0,0 +1,54 @@
+package rollup
+
+import (
+	"context"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
+)
+
+// Job periodically folds closed hours of click_events into the rollup
+// tables. Every consumer process runs one; the store's advisory lock lets
+// only one of them work at a time.
+type Job struct {
+	store    Storer
+	interval time.Duration
+	maxHours int // hours per run, bounds backfill transactions
+}
+
+func NewJob(s Storer, interval time.Duration, maxHours int) *Job {
+	return &Job{store: s, interval: interval, maxHours: maxHours}
+}
+
+// Start runs the job until ctx is done.
+func (j *Job) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			j.run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// run rolls up until caught up, so a backfill does not wait for the ticker.
+func (j *Job) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		hours, err := j.store.RollupClicks(ctx, j.maxHours)
+		if err != nil {
+			logger.Error("click rollup failed", logger.Err(err))
+			return
+		}
+		metrics.ClickRollupHours.Add(float64(hours))
+		if j.maxHours <= 0 || hours < j.maxHours {
+			return
+		}
+		logger.Info("click rollup backfilling", logger.Extra("hours", hours))
+	}
+}
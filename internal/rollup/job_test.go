@@ -0,0 +1,55 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mockStore struct {
+	results []int // hours returned per call; then 0
+	err     error
+	calls   int
+}
+
+func (m *mockStore) RollupClicks(ctx context.Context, maxHours int) (int, error) {
+	m.calls++
+	if m.err != nil {
+		return 0, m.err
+	}
+	if len(m.results) == 0 {
+		return 0, nil
+	}
+	h := m.results[0]
+	m.results = m.results[1:]
+	return h, nil
+}
+
+func TestRunBackfillsUntilCaughtUp(t *testing.T) {
+	s := &mockStore{results: []int{24, 24, 5}}
+	NewJob(s, 0, 24).run(context.Background())
+
+	if s.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", s.calls)
+	}
+}
+
+func TestRunStopsOnError(t *testing.T) {
+	s := &mockStore{err: errors.New("db down")}
+	NewJob(s, 0, 24).run(context.Background())
+
+	if s.calls != 1 {
+		t.Errorf("expected 1 call, got %d", s.calls)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	s := &mockStore{results: []int{24, 24, 24}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewJob(s, 0, 24).run(ctx)
+
+	if s.calls != 0 {
+		t.Errorf("expected no calls, got %d", s.calls)
+	}
+}
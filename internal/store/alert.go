@@ -0,0 +1,405 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Alert rule kinds.
+const (
+	AlertClicks = "clicks" // total clicks reach Threshold; fires once
+	AlertSpike  = "spike"  // clicks in the window reach Threshold and Factor times the usual rate
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// AlertRule sends a webhook when clicks on a link, or on all links of an
+// account, cross a threshold.
+type AlertRule struct {
+	ID            int        `json:"id"`
+	UserID        *int       `json:"user_id,omitempty"` // nil = admin rule
+	Code          string     `json:"code,omitempty"`    // "" = all links of the account (admin: all links)
+	Kind          string     `json:"kind"`
+	Threshold     int64      `json:"threshold"`
+	WindowMinutes int        `json:"window_minutes,omitempty"` // spike only
+	Factor        float64    `json:"factor,omitempty"`         // spike only
+	URL           string     `json:"url"`
+	Secret        string     `json:"-"` // HMAC key for signatures
+	Disabled      bool       `json:"disabled"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastFiredAt   *time.Time `json:"last_fired_at,omitempty"`
+}
+
+const alertRuleColumns = `id, user_id, COALESCE(code, ''), kind, threshold, window_minutes, factor,
+	url, secret, disabled, created_at, last_fired_at`
+
+func scanAlertRule(row interface{ Scan(...any) error }) (AlertRule, error) {
+	var r AlertRule
+	var uid sql.NullInt32
+	var fired sql.NullTime
+	err := row.Scan(&r.ID, &uid, &r.Code, &r.Kind, &r.Threshold, &r.WindowMinutes, &r.Factor,
+		&r.URL, &r.Secret, &r.Disabled, &r.CreatedAt, &fired)
+	if uid.Valid {
+		id := int(uid.Int32)
+		r.UserID = &id
+	}
+	if fired.Valid {
+		r.LastFiredAt = &fired.Time
+	}
+	return r, err
+}
+
+// CreateAlertRule inserts r and sets its ID and CreatedAt. A rule on one
+// link returns sql.ErrNoRows unless the link exists and, for users, is theirs.
+func (s *Store) CreateAlertRule(ctx context.Context, r *AlertRule) error {
+	if r.Code != "" {
+		if err := s.checkLinkExists(ctx, r.Code, r.UserID); err != nil {
+			return err
+		}
+	}
+	var uid sql.NullInt32
+	if r.UserID != nil {
+		uid = sql.NullInt32{Int32: int32(*r.UserID), Valid: true}
+	}
+	return s.db.QueryRowContext(ctx,
+		`INSERT INTO alert_rules (user_id, code, kind, threshold, window_minutes, factor, url, secret)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		uid, r.Code, r.Kind, r.Threshold, r.WindowMinutes, r.Factor, r.URL, r.Secret,
+	).Scan(&r.ID, &r.CreatedAt)
+}
+
+// GetAlertRule returns a rule, or sql.ErrNoRows if it does not exist.
+// userID nil means admin (any rule), otherwise only the user's own rules.
+func (s *Store) GetAlertRule(ctx context.Context, id int, userID *int) (*AlertRule, error) {
+	query := `SELECT ` + alertRuleColumns + ` FROM alert_rules WHERE id = $1`
+	args := []any{id}
+	if userID != nil {
+		query += ` AND user_id = $2`
+		args = append(args, *userID)
+	}
+	r, err := scanAlertRule(s.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListAlertRules returns alert rules, newest first.
+// userID nil means admin (all), otherwise filter by user.
+func (s *Store) ListAlertRules(ctx context.Context, userID *int) ([]AlertRule, error) {
+	query := `SELECT ` + alertRuleColumns + ` FROM alert_rules`
+	var args []any
+	if userID != nil {
+		query += ` WHERE user_id = $1`
+		args = append(args, *userID)
+	}
+	return s.queryAlertRules(ctx, query+` ORDER BY id DESC`, args...)
+}
+
+// ActiveAlertRules returns all enabled rules, for evaluation.
+func (s *Store) ActiveAlertRules(ctx context.Context) ([]AlertRule, error) {
+	return s.queryAlertRules(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE NOT disabled`)
+}
+
+func (s *Store) queryAlertRules(ctx context.Context, query string, args ...any) ([]AlertRule, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []AlertRule
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// UpdateAlertRule saves the threshold, window, factor, URL, secret and
+// disabled flag of r. Changing the threshold, window or factor re-arms the
+// rule. userID nil means admin (any rule), otherwise only the user's own.
+func (s *Store) UpdateAlertRule(ctx context.Context, r AlertRule, userID *int) error {
+	query := `UPDATE alert_rules SET threshold = $2, window_minutes = $3, factor = $4, url = $5,
+		        secret = $6, disabled = $7,
+		        last_fired_at = CASE WHEN (threshold, window_minutes, factor) IS DISTINCT FROM
+		                                  ($2::BIGINT, $3::INT, $4::DOUBLE PRECISION)
+		                             THEN NULL ELSE last_fired_at END
+		 WHERE id = $1`
+	args := []any{r.ID, r.Threshold, r.WindowMinutes, r.Factor, r.URL, r.Secret, r.Disabled}
+	if userID != nil {
+		query += ` AND user_id = $8`
+		args = append(args, *userID)
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAlertRule removes a rule and its delivery log.
+// userID nil means admin (can delete any), otherwise only user's own rules.
+func (s *Store) DeleteAlertRule(ctx context.Context, id int, userID *int) error {
+	var result sql.Result
+	var err error
+	if userID == nil {
+		result, err = s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = $1`, id)
+	} else {
+		result, err = s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = $1 AND user_id = $2`, id, *userID)
+	}
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// alertScope returns a WHERE condition selecting the links watched by r,
+// with its parameter numbered n.
+func alertScope(r AlertRule, n int) (string, []any) {
+	p := "$" + strconv.Itoa(n)
+	switch {
+	case r.Code != "":
+		return "code = " + p, []any{r.Code}
+	case r.UserID != nil:
+		return "code IN (SELECT code FROM links WHERE user_id = " + p + ")", []any{*r.UserID}
+	}
+	return "TRUE", nil
+}
+
+// AlertRuleClicks returns human clicks on r's links in [from, to) from the
+// rollups, with from rounded down to the hour. Zero from counts all clicks.
+func (s *Store) AlertRuleClicks(ctx context.Context, r AlertRule, from, to time.Time) (int64, error) {
+	defer observeDB("alert_rule_clicks", time.Now())
+
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return 0, err
+	}
+	filter, args := alertScope(r, 6)
+	var clicks int64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(StatsOptions{})+` c
+		 WHERE bucket < $5 AND `+filter,
+		newClickRanges(from, wm).until(to).args(append([]any{to}, args...)...)...).Scan(&clicks)
+	return clicks, err
+}
+
+// AlertRuleRecentClicks returns human clicks on r's links since since,
+// counted exactly from click_events.
+func (s *Store) AlertRuleRecentClicks(ctx context.Context, r AlertRule, since time.Time) (int64, error) {
+	defer observeDB("alert_rule_recent_clicks", time.Now())
+
+	filter, args := alertScope(r, 2)
+	var clicks int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM click_events WHERE ts >= $1 AND NOT is_bot AND `+filter,
+		append([]any{since}, args...)...).Scan(&clicks)
+	return clicks, err
+}
+
+// FireAlert marks r as fired and queues a webhook delivery of payload,
+// unless r already fired: ever for cooldown 0, otherwise within cooldown.
+// The check and the mark are one statement, so concurrent consumers
+// evaluating the same rule queue a single delivery. Reports whether it fired.
+func (s *Store) FireAlert(ctx context.Context, r AlertRule, cooldown time.Duration, event string, payload []byte) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	cond := `last_fired_at IS NULL`
+	args := []any{r.ID}
+	if cooldown > 0 {
+		cond += ` OR last_fired_at < NOW() - make_interval(secs => $2)`
+		args = append(args, cooldown.Seconds())
+	}
+	result, err := tx.ExecContext(ctx,
+		`UPDATE alert_rules SET last_fired_at = NOW() WHERE id = $1 AND NOT disabled AND (`+cond+`)`, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (rule_id, event, payload) VALUES ($1, $2, $3)`,
+		r.ID, event, string(payload)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// WebhookDelivery is one webhook call queued by a fired rule, with the
+// outcome of its latest attempt.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	RuleID         int             `json:"rule_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"` // pending only
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// Target, set by ClaimWebhookDeliveries
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// DeliveryResult is the outcome of a delivery attempt.
+type DeliveryResult struct {
+	Status         string    // DeliveryDelivered, DeliveryFailed, or DeliveryPending to retry
+	ResponseStatus int       // 0 without a response
+	Error          string    // "" on success
+	NextAttempt    time.Time // pending only
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries with their
+// rule's URL and secret, counting the attempt. Claimed deliveries are due
+// again after lease, so a sender that dies does not lose them; concurrent
+// senders skip each other's claims.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	defer observeDB("claim_webhook_deliveries", time.Now())
+
+	rows, err := s.db.QueryContext(ctx,
+		`UPDATE webhook_deliveries d
+		 SET attempts = d.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		 FROM alert_rules r
+		 WHERE r.id = d.rule_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING d.id, d.rule_id, d.event, d.payload, d.attempts, d.created_at, r.url, r.secret`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d := WebhookDelivery{Status: DeliveryPending}
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.RuleID, &d.Event, &payload, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// FinishWebhookDelivery records the outcome of a claimed delivery.
+func (s *Store) FinishWebhookDelivery(ctx context.Context, id int64, res DeliveryResult) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = $2, response_status = NULLIF($3, 0), last_error = NULLIF($4, ''),
+		     next_attempt_at = CASE WHEN $2 = 'pending' THEN $5 ELSE next_attempt_at END,
+		     delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() END
+		 WHERE id = $1`,
+		id, res.Status, res.ResponseStatus, res.Error, res.NextAttempt)
+	return err
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a rule, newest
+// first, or sql.ErrNoRows if the rule is not visible to userID.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, ruleID int, userID *int, limit int) ([]WebhookDelivery, error) {
+	if _, err := s.GetAlertRule(ctx, ruleID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, rule_id, event, payload, status, attempts, response_status, COALESCE(last_error, ''),
+		        next_attempt_at, created_at, delivered_at
+		 FROM webhook_deliveries WHERE rule_id = $1 ORDER BY id DESC LIMIT $2`, ruleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload []byte
+		var status sql.NullInt32
+		var next time.Time
+		var delivered sql.NullTime
+		if err := rows.Scan(&d.ID, &d.RuleID, &d.Event, &payload, &d.Status, &d.Attempts, &status, &d.LastError,
+			&next, &d.CreatedAt, &delivered); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		if status.Valid {
+			code := int(status.Int32)
+			d.ResponseStatus = &code
+		}
+		if d.Status == DeliveryPending {
+			d.NextAttemptAt = &next
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RetryWebhookDelivery queues a finished (delivered or failed) delivery
+// again with a fresh set of attempts. Returns sql.ErrNoRows if the rule is
+// not visible to userID or the delivery is not finished.
+func (s *Store) RetryWebhookDelivery(ctx context.Context, ruleID int, id int64, userID *int) error {
+	if _, err := s.GetAlertRule(ctx, ruleID, userID); err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = 'pending', attempts = 0, next_attempt_at = NOW(), last_error = NULL
+		 WHERE id = $1 AND rule_id = $2 AND status <> 'pending'`, id, ruleID)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PruneWebhookDeliveries deletes finished deliveries created before before.
+func (s *Store) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
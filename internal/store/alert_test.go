@@ -0,0 +1,22 @@
+package store
+
+import "testing"
+
+func TestAlertScope(t *testing.T) {
+	user := 7
+	tests := []struct {
+		rule     AlertRule
+		filter   string
+		wantArgs int
+	}{
+		{AlertRule{Code: "abc", UserID: &user}, "code = $6", 1},
+		{AlertRule{UserID: &user}, "code IN (SELECT code FROM links WHERE user_id = $6)", 1},
+		{AlertRule{}, "TRUE", 0},
+	}
+	for _, tt := range tests {
+		filter, args := alertScope(tt.rule, 6)
+		if filter != tt.filter || len(args) != tt.wantArgs {
+			t.Errorf("alertScope(%+v) = %q, %v; want %q with %d args", tt.rule, filter, args, tt.filter, tt.wantArgs)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wyp0596/go2short/internal/config"
+)
+
+// Ingestion benchmarks against a real Postgres with migrations applied:
+//
+//	BENCH_DATABASE_URL=postgres://... go test -run '^$' -bench ClickEvents ./internal/store
+//
+// Rows are written under codes prefixed "bench-" and deleted afterwards.
+func benchStore(b *testing.B, copyClicks bool) *Store {
+	url := os.Getenv("BENCH_DATABASE_URL")
+	if url == "" {
+		b.Skip("BENCH_DATABASE_URL not set")
+	}
+	s, err := New(&config.Config{DatabaseURL: url, DBMaxOpenConns: 20, DBMaxIdleConns: 20})
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.copyClicks = copyClicks
+	b.Cleanup(func() {
+		s.db.Exec(`DELETE FROM click_events WHERE code LIKE 'bench-%'`)
+		s.Close()
+	})
+	return s
+}
+
+func benchEvents(n int) []ClickEvent {
+	events := make([]ClickEvent, n)
+	now := time.Now()
+	for i := range events {
+		events[i] = ClickEvent{
+			Code:       fmt.Sprintf("bench-%d", i%50),
+			Timestamp:  now,
+			IP:         "203.0.113.7",
+			UA:         "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36",
+			DeviceType: "desktop",
+			Browser:    "Chrome",
+			OS:         "macOS",
+			Referer:    "https://news.ycombinator.com/",
+		}
+	}
+	return events
+}
+
+func BenchmarkInsertClickEvents(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		copy bool
+	}{{"copy", true}, {"insert", false}} {
+		for _, size := range []int{100, 500, 2000} {
+			b.Run(fmt.Sprintf("%s/batch=%d", mode.name, size), func(b *testing.B) {
+				s := benchStore(b, mode.copy)
+				events := benchEvents(size)
+				ctx := context.Background()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := s.InsertClickEvents(ctx, events); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "events/s")
+			})
+		}
+	}
+}
+
+// BenchmarkInsertClickEventsParallel simulates several workers flushing
+// 500-event batches at once.
+func BenchmarkInsertClickEventsParallel(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		copy bool
+	}{{"copy", true}, {"insert", false}} {
+		b.Run(mode.name, func(b *testing.B) {
+			s := benchStore(b, mode.copy)
+			events := benchEvents(500)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := s.InsertClickEvents(ctx, events); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N*len(events))/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Period is a stats window [From, To).
+type Period struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// shiftDays moves p by n calendar days in its timezone.
+func (p Period) shiftDays(n int) Period {
+	return Period{From: p.From.AddDate(0, 0, n), To: p.To.AddDate(0, 0, n)}
+}
+
+// PeriodStats summarizes the clicks of a period.
+type PeriodStats struct {
+	Period
+	Clicks       int    `json:"clicks"`
+	ClickedLinks int    `json:"clicked_links"`          // links with at least one click
+	VisitorDays  *int64 `json:"visitor_days,omitempty"` // filled by the handler, see PeriodComparison
+}
+
+// PeriodChange holds percent changes from the previous period, nil when the
+// previous value is 0.
+type PeriodChange struct {
+	Clicks       *float64 `json:"clicks"`
+	ClickedLinks *float64 `json:"clicked_links"`
+	VisitorDays  *float64 `json:"visitor_days,omitempty"`
+}
+
+// PeriodComparison compares a period with the one before it. Visitor
+// sketches are per UTC day and cannot be cut at a time of day, so
+// VisitorDays compare the last days whole UTC days before today with the
+// days before them, labeled by VisitorsTimezone.
+type PeriodComparison struct {
+	Current          PeriodStats  `json:"current"`
+	Previous         PeriodStats  `json:"previous"`
+	Change           PeriodChange `json:"change"`
+	VisitorsTimezone string       `json:"visitors_timezone,omitempty"`
+}
+
+// PercentChange returns the change from prev to cur in percent, rounded to
+// one decimal, or nil if prev is 0.
+func PercentChange(cur, prev int64) *float64 {
+	if prev == 0 {
+		return nil
+	}
+	v := math.Round(float64(cur-prev)/float64(prev)*1000) / 10
+	return &v
+}
+
+// comparedPeriods returns the last days calendar days in loc, today up to
+// now, and the days before them up to the same time of day.
+func comparedPeriods(days int, now time.Time, loc *time.Location) (cur, prev Period) {
+	cur = Period{From: localDaysStart(days, now, loc), To: now.In(loc)}
+	return cur, cur.shiftDays(-days)
+}
+
+// periodRanges is the click ranges of p, ending at p.To. Like statsRanges,
+// the partial hours at either end are read from click_events.
+func periodRanges(p Period, watermark time.Time) clickRanges {
+	return exactRanges(p.From, watermark).until(p.To)
+}
+
+// ComparePeriods compares the last days calendar days in opts' timezone,
+// today up to now, with the days before them up to the same time of day.
+// userID nil means admin (all), otherwise filter by user.
+func (s *Store) ComparePeriods(ctx context.Context, days int, userID *int, opts StatsOptions) (*PeriodComparison, error) {
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cur, prev := comparedPeriods(days, time.Now(), opts.location())
+
+	var cmp PeriodComparison
+	if cmp.Current, err = s.periodStats(ctx, cur, wm, userID, opts); err != nil {
+		return nil, err
+	}
+	if cmp.Previous, err = s.periodStats(ctx, prev, wm, userID, opts); err != nil {
+		return nil, err
+	}
+	cmp.Change.Clicks = PercentChange(int64(cmp.Current.Clicks), int64(cmp.Previous.Clicks))
+	cmp.Change.ClickedLinks = PercentChange(int64(cmp.Current.ClickedLinks), int64(cmp.Previous.ClickedLinks))
+	return &cmp, nil
+}
+
+// periodStats counts clicks and clicked links in p.
+func (s *Store) periodStats(ctx context.Context, p Period, wm time.Time, userID *int, opts StatsOptions) (PeriodStats, error) {
+	r := periodRanges(p, wm)
+	query := `SELECT COALESCE(SUM(clicks), 0), COUNT(DISTINCT code) FROM ` + deviceRollup.source(opts) + ` c
+		 WHERE bucket < $5`
+	args := r.args(p.To)
+	if userID != nil {
+		query += ` AND code IN (SELECT code FROM links WHERE user_id = $6)`
+		args = append(args, *userID)
+	}
+
+	stats := PeriodStats{Period: p}
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&stats.Clicks, &stats.ClickedLinks)
+	return stats, err
+}
+
+// LinkRank is a link's place in the previous period.
+type LinkRank struct {
+	Rank       int `json:"rank"`
+	ClickCount int `json:"click_count"`
+}
+
+// FillTopLinksPrevious sets Previous and Change on links returned by
+// GetTopLinks for the same days: their clicks and rank among all links in
+// scope in the previous period of ComparePeriods. Links without clicks then
+// keep Previous nil.
+func (s *Store) FillTopLinksPrevious(ctx context.Context, links []TopLink, days int, userID *int, opts StatsOptions) error {
+	if len(links) == 0 {
+		return nil
+	}
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return err
+	}
+	_, p := comparedPeriods(days, time.Now(), opts.location())
+	r := periodRanges(p, wm)
+
+	codes := make([]string, len(links))
+	for i, l := range links {
+		codes[i] = l.Code
+	}
+	filter := "TRUE"
+	args := r.args(p.To, pq.Array(codes))
+	if userID != nil {
+		filter = "code IN (SELECT code FROM links WHERE user_id = $7)"
+		args = append(args, *userID)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT code, clicks, rank FROM (
+			SELECT code, SUM(clicks) AS clicks, RANK() OVER (ORDER BY SUM(clicks) DESC) AS rank
+			FROM `+deviceRollup.source(opts)+` c
+			WHERE bucket < $5 AND `+filter+`
+			GROUP BY code
+		 ) r
+		 WHERE code = ANY($6)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	prev := make(map[string]LinkRank, len(links))
+	for rows.Next() {
+		var code string
+		var lr LinkRank
+		if err := rows.Scan(&code, &lr.ClickCount, &lr.Rank); err != nil {
+			return err
+		}
+		prev[code] = lr
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range links {
+		if lr, ok := prev[links[i].Code]; ok {
+			links[i].Previous = &lr
+			links[i].Change = PercentChange(int64(links[i].ClickCount), int64(lr.ClickCount))
+		}
+	}
+	return nil
+}
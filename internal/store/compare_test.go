@@ -0,0 +1,101 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentChange(t *testing.T) {
+	tests := []struct {
+		cur, prev int64
+		want      *float64
+	}{
+		{150, 100, ptr(50.0)},
+		{50, 200, ptr(-75.0)},
+		{1, 3, ptr(-66.7)},
+		{10, 0, nil},
+	}
+	for _, tt := range tests {
+		got := PercentChange(tt.cur, tt.prev)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("PercentChange(%d, %d) = %v, want %v", tt.cur, tt.prev, fmtPtr(got), fmtPtr(tt.want))
+		}
+	}
+}
+
+func TestPeriodShiftDays(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	// The previous week crosses the switch to summer time (2025-03-30)
+	p := Period{
+		From: time.Date(2025, 3, 31, 0, 0, 0, 0, berlin),
+		To:   time.Date(2025, 4, 6, 15, 30, 0, 0, berlin),
+	}
+	prev := p.shiftDays(-7)
+	if want := time.Date(2025, 3, 24, 0, 0, 0, 0, berlin); !prev.From.Equal(want) {
+		t.Errorf("From = %v, want local midnight %v", prev.From, want)
+	}
+	if want := time.Date(2025, 3, 30, 15, 30, 0, 0, berlin); !prev.To.Equal(want) {
+		t.Errorf("To = %v, want same time of day %v", prev.To, want)
+	}
+}
+
+func TestComparedPeriods(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	now := ts("2025-03-10T20:00:00Z") // 04:00 on March 11th in Shanghai
+
+	cur, prev := comparedPeriods(7, now, shanghai)
+	if !cur.From.Equal(ts("2025-03-04T16:00:00Z")) || !cur.To.Equal(now) {
+		t.Errorf("current = %+v, want [March 5th 00:00 local, now)", cur)
+	}
+	if !prev.From.Equal(ts("2025-02-25T16:00:00Z")) || !prev.To.Equal(ts("2025-03-03T20:00:00Z")) {
+		t.Errorf("previous = %+v, want the 7 days before, to the same time of day", prev)
+	}
+}
+
+func TestPeriodRanges(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	wm := ts("2025-03-10T19:00:00Z")
+	now := ts("2025-03-10T20:00:00Z")
+
+	// The previous period ends mid-day, before the watermark
+	_, prev := comparedPeriods(7, now, time.UTC)
+	r := periodRanges(prev, wm)
+	if !r.since.Equal(ts("2025-02-25T00:00:00Z")) || !r.dayEnd.Equal(ts("2025-03-03T00:00:00Z")) {
+		t.Errorf("daily range = [%v, %v), want [since, 2025-03-03)", r.since, r.dayEnd)
+	}
+	if !r.watermark.Equal(ts("2025-03-03T20:00:00Z")) {
+		t.Errorf("watermark = %v, want the end of the period", r.watermark)
+	}
+
+	// Local midnight falls inside a rollup hour: exact start and end, whole
+	// hours and days still from rollups
+	_, prev = comparedPeriods(7, now, kolkata)
+	r = periodRanges(prev, wm)
+	if !r.since.Equal(ts("2025-02-25T18:30:00Z")) {
+		t.Errorf("since = %v, want local midnight", r.since)
+	}
+	if !r.dayStart.Equal(ts("2025-02-26T00:00:00Z")) || !r.dayEnd.Equal(ts("2025-03-03T00:00:00Z")) {
+		t.Errorf("daily range = [%v, %v), want [2025-02-26, 2025-03-03)", r.dayStart, r.dayEnd)
+	}
+	if !r.watermark.Equal(ts("2025-03-03T20:00:00Z")) {
+		t.Errorf("watermark = %v, want the end of the period", r.watermark)
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
+
+func fmtPtr(v *float64) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Conversion is an outcome reported for a click.
+type Conversion struct {
+	ClickID string
+	Code    string // link of the click
+	TxnID   string // "" = at most one conversion per click
+	Value   float64
+}
+
+// RecordConversion stores a conversion. It returns false if the click
+// already has a conversion with the same TxnID, and sql.ErrNoRows if the
+// link does not exist or userID is not nil and does not own it.
+func (s *Store) RecordConversion(ctx context.Context, c Conversion, userID *int) (bool, error) {
+	defer observeDB("record_conversion", time.Now())
+
+	if err := s.checkLinkExists(ctx, c.Code, userID); err != nil {
+		return false, err
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO conversions (click_id, code, txn_id, value) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (click_id, txn_id) DO NOTHING`, c.ClickID, c.Code, c.TxnID, c.Value)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecordPixelConversion stores a conversion without transaction ID or value
+// unless the click already has a conversion, so anyone holding a click ID
+// can report it once. It returns false if nothing was stored, and
+// sql.ErrNoRows if the link does not exist.
+func (s *Store) RecordPixelConversion(ctx context.Context, clickID, code string) (bool, error) {
+	defer observeDB("record_pixel_conversion", time.Now())
+
+	if err := s.checkLinkExists(ctx, code, nil); err != nil {
+		return false, err
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO conversions (click_id, code)
+		 SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM conversions WHERE click_id = $1)
+		 ON CONFLICT (click_id, txn_id) DO NOTHING`, clickID, code)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ConversionStats holds the conversions of a link.
+type ConversionStats struct {
+	Conversions int      `json:"conversions"`
+	Value       float64  `json:"value"`
+	Rate        *float64 `json:"conversion_rate"` // percent of clicks, nil without clicks
+}
+
+// GetLinkConversionStats returns conversions in the last N days and their
+// rate per click over the same days. userID nil means admin, otherwise
+// verifies link belongs to user.
+func (s *Store) GetLinkConversionStats(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (*ConversionStats, error) {
+	filter, args, err := s.linkStatsScope(ctx, code, days, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var clicks int
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(opts)+` c WHERE `+filter, args...).Scan(&clicks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Conversions since the same start as the clicks: $1 of the scope
+	var stats ConversionStats
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(value), 0) FROM conversions WHERE code = $1 AND ts >= $2`,
+		code, args[0]).Scan(&stats.Conversions, &stats.Value)
+	if err != nil {
+		return nil, err
+	}
+	if clicks > 0 {
+		rate := math.Round(float64(stats.Conversions)/float64(clicks)*10000) / 100
+		stats.Rate = &rate
+	}
+	return &stats, nil
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ClickExport selects raw clicks in [From, To) for export.
+type ClickExport struct {
+	From   time.Time
+	To     time.Time
+	Code   string // "" = every link in scope
+	UserID *int   // nil = all links
+	Opts   StatsOptions
+}
+
+// ExportClicks calls fn for each raw click matching q, ordered by code and
+// time, streaming rows instead of loading them. Raw clicks only cover the
+// retention window. With Code set, userID nil means admin, otherwise the link
+// must belong to the user (sql.ErrNoRows if not). fn errors stop the export.
+func (s *Store) ExportClicks(ctx context.Context, q ClickExport, fn func(ClickEvent) error) error {
+	defer observeDB("export_clicks", time.Now())
+
+	query := `SELECT code, ts, COALESCE(ip, ''), COALESCE(ua, ''), COALESCE(referer, ''),
+		        COALESCE(referer_host, ''), COALESCE(utm_source, ''), COALESCE(utm_medium, ''),
+		        COALESCE(utm_campaign, ''), COALESCE(device_type, ''), COALESCE(browser, ''),
+		        COALESCE(os, ''), COALESCE(country, ''), COALESCE(region, ''), COALESCE(city, ''), is_bot,
+		        COALESCE(click_id, '')
+		 FROM click_events
+		 WHERE ts >= $1 AND ts < $2`
+	args := []any{q.From, q.To}
+	if !q.Opts.IncludeBots {
+		query += ` AND NOT is_bot`
+	}
+	if q.Code != "" {
+		if err := s.checkLinkOwner(ctx, q.Code, q.UserID); err != nil {
+			return err
+		}
+		query += ` AND code = $3`
+		args = append(args, q.Code)
+	} else if q.UserID != nil {
+		query += ` AND code IN (SELECT code FROM links WHERE user_id = $3)`
+		args = append(args, *q.UserID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY code, ts`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ClickEvent
+		err := rows.Scan(&e.Code, &e.Timestamp, &e.IP, &e.UA, &e.Referer,
+			&e.RefererHost, &e.UTMSource, &e.UTMMedium,
+			&e.UTMCampaign, &e.DeviceType, &e.Browser,
+			&e.OS, &e.Country, &e.Region, &e.City, &e.IsBot,
+			&e.ClickID)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ClickQueryRow is one (bucket, group) row of an exported click query.
+type ClickQueryRow struct {
+	Time   time.Time // zero without a bucket
+	Group  []string  // values in GroupBy order
+	Clicks int64
+}
+
+// ExportClickQuery runs a click query without the row limit of QueryClicks
+// and calls fn for each row, ordered by group, then time. Filtering on a
+// code the user does not own returns sql.ErrNoRows.
+func (s *Store) ExportClickQuery(ctx context.Context, q ClickQuery, fn func(ClickQueryRow) error) error {
+	defer observeDB("export_click_query", time.Now())
+
+	for _, code := range q.Filters["code"] {
+		if err := s.checkLinkOwner(ctx, code, q.UserID); err != nil {
+			return err
+		}
+	}
+
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return err
+	}
+	query, args, _, err := buildClickQuery(q, wm)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	for rows.Next() {
+		row := ClickQueryRow{Group: make([]string, len(q.GroupBy))}
+		dest := make([]any, 0, len(row.Group)+2)
+		if q.Bucket != "" {
+			dest = append(dest, &row.Time)
+		}
+		for i := range row.Group {
+			dest = append(dest, &row.Group[i])
+		}
+		dest = append(dest, &row.Clicks)
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if q.Bucket != "" {
+			row.Time = row.Time.In(loc)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Heatmap holds clicks by day of week (Monday first) and hour of day.
+type Heatmap struct {
+	Clicks [7][24]int `json:"clicks"`
+	Total  int        `json:"total"`
+}
+
+// add counts clicks at an ISO day of week (1 = Monday) and hour.
+func (h *Heatmap) add(isoDow, hour, clicks int) {
+	h.Clicks[isoDow-1][hour] += clicks
+	h.Total += clicks
+}
+
+// GetClickHeatmap returns clicks of the last N calendar days by local day of
+// week and hour. userID nil means admin (all), otherwise filter by user.
+func (s *Store) GetClickHeatmap(ctx context.Context, days int, userID *int, opts StatsOptions) (*Heatmap, error) {
+	return s.clickHeatmap(ctx, days, "", userID, opts)
+}
+
+// GetLinkClickHeatmap is GetClickHeatmap for one link. userID nil means
+// admin, otherwise verifies link belongs to user.
+func (s *Store) GetLinkClickHeatmap(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (*Heatmap, error) {
+	if err := s.checkLinkOwner(ctx, code, userID); err != nil {
+		return nil, err
+	}
+	return s.clickHeatmap(ctx, days, code, nil, opts)
+}
+
+func (s *Store) clickHeatmap(ctx context.Context, days int, code string, userID *int, opts StatsOptions) (*Heatmap, error) {
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query, args := buildHeatmapQuery(days, time.Now(), wm, code, userID, opts)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var h Heatmap
+	for rows.Next() {
+		var dow, hour, clicks int
+		if err := rows.Scan(&dow, &hour, &clicks); err != nil {
+			return nil, err
+		}
+		h.add(dow, hour, clicks)
+	}
+	return &h, rows.Err()
+}
+
+// buildHeatmapQuery returns the heatmap SQL of the last days calendar days up
+// to now, grouped by ISO day of week and hour in opts' timezone. It reads
+// hourly rollups, never daily ones, or only click_events in timezones that
+// are not a whole number of hours from UTC, where rollup hours straddle two
+// local hours. code, else userID, filters the links.
+func buildHeatmapQuery(days int, now, watermark time.Time, code string, userID *int, opts StatsOptions) (string, []any) {
+	loc := opts.location()
+	r := statsRanges(days, now, watermark, opts).hourlyOnly()
+	if since := localDaysStart(days, now, loc); !wholeHourOffset(loc, since) || !wholeHourOffset(loc, now) {
+		r = rawRanges(since)
+	}
+	args := r.args()
+	param := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	filter := "TRUE"
+	if code != "" {
+		filter = "code = " + param(code)
+	} else if userID != nil {
+		filter = "code IN (SELECT code FROM links WHERE user_id = " + param(*userID) + ")"
+	}
+	tz := param(opts.location().String())
+	query := `SELECT EXTRACT(ISODOW FROM bucket AT TIME ZONE ` + tz + `)::int AS dow,
+	        EXTRACT(HOUR FROM bucket AT TIME ZONE ` + tz + `)::int AS hour, SUM(clicks)
+	 FROM ` + deviceRollup.source(opts) + ` c
+	 WHERE ` + filter + `
+	 GROUP BY dow, hour`
+	return query, args
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildHeatmapQuery(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	uid := 7
+	now := ts("2025-03-10T20:00:00Z")
+	wm := ts("2025-03-10T19:00:00Z")
+
+	tests := []struct {
+		name      string
+		code      string
+		userID    *int
+		opts      StatsOptions
+		wantSince string
+		wantRaw   bool
+		wantSQL   []string
+		wantArgs  []any // after $1-$4
+	}{
+		{
+			name:      "utc rollups",
+			wantSince: "2025-03-04T00:00:00Z",
+			wantSQL:   []string{"FROM click_rollups_hourly", "WHERE TRUE", "AT TIME ZONE $5", "NOT is_bot"},
+			wantArgs:  []any{"UTC"},
+		},
+		{
+			name:      "local days from rollups",
+			code:      "abc",
+			opts:      StatsOptions{Location: shanghai, IncludeBots: true},
+			wantSince: "2025-03-04T16:00:00Z", // March 5th, 00:00 in Shanghai
+			wantSQL:   []string{"code = $5", "ISODOW FROM bucket AT TIME ZONE $6", "HOUR FROM bucket AT TIME ZONE $6"},
+			wantArgs:  []any{"abc", "Asia/Shanghai"},
+		},
+		{
+			name:      "half-hour timezone reads raw",
+			userID:    &uid,
+			opts:      StatsOptions{Location: kolkata},
+			wantSince: "2025-03-04T18:30:00Z", // March 5th, 00:00 in Kolkata
+			wantRaw:   true,
+			wantSQL:   []string{"user_id = $5", "AT TIME ZONE $6"},
+			wantArgs:  []any{7, "Asia/Kolkata"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := buildHeatmapQuery(7, now, wm, tt.code, tt.userID, tt.opts)
+			for _, want := range tt.wantSQL {
+				if !strings.Contains(query, want) {
+					t.Errorf("query missing %q:\n%s", want, query)
+				}
+			}
+			if strings.Contains(query, "NOT is_bot") == tt.opts.IncludeBots {
+				t.Errorf("bot filter does not match IncludeBots=%v", tt.opts.IncludeBots)
+			}
+			if len(args) != 4+len(tt.wantArgs) {
+				t.Fatalf("args = %v, want 4 ranges and %v", args, tt.wantArgs)
+			}
+			for i, want := range tt.wantArgs {
+				if args[4+i] != want {
+					t.Errorf("$%d = %v, want %v", 5+i, args[4+i], want)
+				}
+			}
+
+			since, dayStart, dayEnd, watermark := args[0].(time.Time), args[1].(time.Time), args[2].(time.Time), args[3].(time.Time)
+			if !since.Equal(ts(tt.wantSince)) {
+				t.Errorf("since = %v, want %s", since, tt.wantSince)
+			}
+			if !dayStart.Equal(dayEnd) {
+				t.Errorf("daily range [%v, %v) read: hours are needed", dayStart, dayEnd)
+			}
+			if raw := watermark.Equal(since); raw != tt.wantRaw {
+				t.Errorf("watermark = %v, want raw clicks only = %v", watermark, tt.wantRaw)
+			}
+		})
+	}
+}
+
+func TestHeatmapAdd(t *testing.T) {
+	var h Heatmap
+	h.add(1, 0, 2) // Monday 00:00
+	h.add(7, 23, 3)
+	h.add(7, 23, 1)
+	if h.Clicks[0][0] != 2 || h.Clicks[6][23] != 4 || h.Total != 6 {
+		t.Errorf("unexpected heatmap %+v", h)
+	}
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// click_events is range partitioned by month (UTC), one table per month
+// named click_events_yYYYYmMM. See migrations/007_partition_click_events.sql.
+
+const partitionLockKey = 0x676f3270 // pg advisory lock: one partition run at a time
+
+// partitionName returns the partition holding the month that starts at m.
+func partitionName(m time.Time) string {
+	return fmt.Sprintf("click_events_y%04dm%02d", m.Year(), int(m.Month()))
+}
+
+// parsePartitionName returns the month start of a partition created by
+// partitionName; false for any other table.
+func parsePartitionName(name string) (time.Time, bool) {
+	var y, m int
+	if n, err := fmt.Sscanf(name, "click_events_y%04dm%02d", &y, &m); err != nil || n != 2 || m < 1 || m > 12 {
+		return time.Time{}, false
+	}
+	if partitionName(time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.UTC)) != name {
+		return time.Time{}, false
+	}
+	return time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.UTC), true
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionRange returns the bounds of the partition holding t: the start
+// of its UTC month (inclusive) and of the next one (exclusive).
+func partitionRange(t time.Time) (from, to time.Time) {
+	from = monthStart(t)
+	return from, from.AddDate(0, 1, 0)
+}
+
+// eventMonths returns the distinct months of the events, oldest first.
+func eventMonths(events []ClickEvent) []time.Time {
+	seen := make(map[time.Time]bool)
+	var months []time.Time
+	for _, e := range events {
+		m := monthStart(e.Timestamp)
+		if !seen[m] {
+			seen[m] = true
+			months = append(months, m)
+		}
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+	return months
+}
+
+// isMissingPartition reports whether err is Postgres rejecting a row that
+// no click_events partition accepts.
+func isMissingPartition(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23514" && strings.HasPrefix(pqErr.Message, "no partition of relation")
+}
+
+// retentionCutoff returns the start of the oldest month kept when keeping
+// retentionMonths full months before the current one.
+func retentionCutoff(now time.Time, retentionMonths int) time.Time {
+	return monthStart(now).AddDate(0, -retentionMonths, 0)
+}
+
+// PartitionPolicy controls click_events partition maintenance.
+type PartitionPolicy struct {
+	Ahead           int  // months to create beyond the current one
+	RetentionMonths int  // full months kept before the current one, 0 = keep forever
+	Detach          bool // detach expired partitions (archive) instead of dropping them
+}
+
+// ParseRetentionMode parses CLICK_RETENTION_MODE: "drop" or "detach".
+// Reports whether expired partitions are detached.
+func ParseRetentionMode(s string) (bool, error) {
+	switch s {
+	case "drop":
+		return false, nil
+	case "detach":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid click retention mode %q", s)
+}
+
+// MaintainClickPartitions creates missing partitions through policy.Ahead
+// months and drops or detaches partitions older than the retention window.
+// A partition is only removed once the rollup watermark has passed it, so
+// rollups never lose data. Returns the tables created and removed (under
+// their archived names when detached); nothing if another instance holds the
+// partition lock.
+func (s *Store) MaintainClickPartitions(ctx context.Context, policy PartitionPolicy) (created, removed []string, err error) {
+	defer observeDB("maintain_click_partitions", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, partitionLockKey).Scan(&locked); err != nil {
+		return nil, nil, err
+	}
+	if !locked {
+		return nil, nil, nil
+	}
+
+	existing, err := clickPartitions(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	for i := 0; i <= policy.Ahead; i++ {
+		m := monthStart(now).AddDate(0, i, 0)
+		name := partitionName(m)
+		if existing[name] {
+			continue
+		}
+		if err := createClickPartition(ctx, tx, m); err != nil {
+			return nil, nil, err
+		}
+		created = append(created, name)
+	}
+
+	if policy.RetentionMonths > 0 {
+		var watermark time.Time
+		err := tx.QueryRowContext(ctx,
+			`SELECT watermark FROM rollup_state WHERE name = $1`, rollupName).Scan(&watermark)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+
+		cutoff := retentionCutoff(now, policy.RetentionMonths)
+		for name := range existing {
+			m, _ := parsePartitionName(name)
+			end := m.AddDate(0, 1, 0)
+			if end.After(cutoff) || end.After(watermark) {
+				continue // in retention window, or not rolled up yet
+			}
+
+			if !policy.Detach {
+				if _, err := tx.ExecContext(ctx, `DROP TABLE `+pq.QuoteIdentifier(name)); err != nil {
+					return nil, nil, err
+				}
+				removed = append(removed, name)
+				continue
+			}
+			archived, err := detachClickPartition(ctx, tx, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			removed = append(removed, archived)
+		}
+	}
+
+	return created, removed, tx.Commit()
+}
+
+// ensureClickPartitions creates the missing partitions for the months of
+// events, for clicks the partition job has not created ahead (clock skew,
+// replays of old events). It waits for a running partition job. A month
+// already removed by retention is created again and removed on the next run.
+func (s *Store) ensureClickPartitions(ctx context.Context, events []ClickEvent) (created []string, err error) {
+	defer observeDB("ensure_click_partitions", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, partitionLockKey); err != nil {
+		return nil, err
+	}
+	existing, err := clickPartitions(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range eventMonths(events) {
+		name := partitionName(m)
+		if existing[name] {
+			continue
+		}
+		if err := createClickPartition(ctx, tx, m); err != nil {
+			return nil, err
+		}
+		created = append(created, name)
+	}
+	return created, tx.Commit()
+}
+
+// archivedPartitionName returns the name a detached partition is renamed
+// to; n > 0 numbers archives of a month detached more than once.
+func archivedPartitionName(name string, n int) string {
+	if n == 0 {
+		return name + "_archived"
+	}
+	return fmt.Sprintf("%s_archived_%d", name, n+1)
+}
+
+// detachClickPartition detaches a partition and renames it to a free
+// archived name, so the month's name can be used by a new partition (see
+// ensureClickPartitions) instead of CREATE TABLE IF NOT EXISTS finding the
+// archive. Returns the archived name.
+func detachClickPartition(ctx context.Context, tx *sql.Tx, name string) (string, error) {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE click_events DETACH PARTITION `+pq.QuoteIdentifier(name)); err != nil {
+		return "", err
+	}
+	for n := 0; ; n++ {
+		archived := archivedPartitionName(name, n)
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, pq.QuoteIdentifier(archived)).Scan(&taken); err != nil {
+			return "", err
+		}
+		if taken {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `ALTER TABLE `+pq.QuoteIdentifier(name)+` RENAME TO `+pq.QuoteIdentifier(archived))
+		return archived, err
+	}
+}
+
+// createClickPartition creates the partition holding the month of m.
+func createClickPartition(ctx context.Context, tx *sql.Tx, m time.Time) error {
+	from, to := partitionRange(m)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF click_events FOR VALUES FROM (%s) TO (%s)`,
+		pq.QuoteIdentifier(partitionName(from)),
+		pq.QuoteLiteral(from.Format(time.RFC3339)),
+		pq.QuoteLiteral(to.Format(time.RFC3339))))
+	return err
+}
+
+// clickPartitions returns the monthly partitions attached to click_events.
+func clickPartitions(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT c.relname FROM pg_inherits i
+		 JOIN pg_class c ON c.oid = i.inhrelid
+		 WHERE i.inhparent = 'click_events'::regclass`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if _, ok := parsePartitionName(name); ok {
+			partitions[name] = true
+		}
+	}
+	return partitions, rows.Err()
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestPartitionName(t *testing.T) {
+	m := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	name := partitionName(m)
+	if name != "click_events_y2025m03" {
+		t.Errorf("partitionName() = %q", name)
+	}
+
+	got, ok := parsePartitionName(name)
+	if !ok || !got.Equal(m) {
+		t.Errorf("parsePartitionName(%q) = %v, %v", name, got, ok)
+	}
+}
+
+func TestParsePartitionNameRejectsOtherTables(t *testing.T) {
+	for _, name := range []string{
+		"click_events",
+		"click_events_y2025m13",
+		"click_events_y2025m3",
+		"click_events_y2025m03_old",
+		"click_rollups_daily",
+	} {
+		if _, ok := parsePartitionName(name); ok {
+			t.Errorf("parsePartitionName(%q) should fail", name)
+		}
+	}
+}
+
+func TestArchivedPartitionName(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "click_events_y2025m03_archived"},
+		{1, "click_events_y2025m03_archived_2"},
+		{2, "click_events_y2025m03_archived_3"},
+	}
+	for _, tt := range tests {
+		got := archivedPartitionName("click_events_y2025m03", tt.n)
+		if got != tt.want {
+			t.Errorf("archivedPartitionName(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+		if _, ok := parsePartitionName(got); ok {
+			t.Errorf("%q must not be taken for a partition", got)
+		}
+	}
+}
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		months int
+		want   time.Time
+	}{
+		{1, time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)},
+		{12, time.Date(2025, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		{13, time.Date(2025, time.September, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := retentionCutoff(now, tt.months); !got.Equal(tt.want) {
+			t.Errorf("retentionCutoff(%d) = %v, want %v", tt.months, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionRange(t *testing.T) {
+	tests := []struct {
+		t        time.Time
+		from, to time.Time
+	}{
+		{time.Date(2026, time.March, 15, 10, 0, 0, 0, time.UTC),
+			time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, time.December, 31, 23, 59, 59, 0, time.UTC),
+			time.Date(2025, time.December, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// UTC months: 01:00 on April 1st in UTC+2 is still March
+		{time.Date(2026, time.April, 1, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*3600)),
+			time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		from, to := partitionRange(tt.t)
+		if !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("partitionRange(%v) = %v, %v; want %v, %v", tt.t, from, to, tt.from, tt.to)
+		}
+		if name := partitionName(from); name != partitionName(monthStart(tt.t)) {
+			t.Errorf("partitionName(%v) = %q", from, name)
+		}
+	}
+}
+
+func TestEventMonths(t *testing.T) {
+	events := []ClickEvent{
+		{Timestamp: time.Date(2026, time.March, 2, 0, 0, 0, 0, time.UTC)},
+		{Timestamp: time.Date(2026, time.January, 31, 0, 0, 0, 0, time.UTC)},
+		{Timestamp: time.Date(2026, time.March, 30, 0, 0, 0, 0, time.UTC)},
+	}
+	got := eventMonths(events)
+	want := []time.Time{
+		time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("eventMonths() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("eventMonths()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestIsMissingPartition(t *testing.T) {
+	missing := &pq.Error{Code: "23514", Message: `no partition of relation "click_events" found for row`}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{missing, true},
+		{fmt.Errorf("copy: %w", missing), true},
+		{&pq.Error{Code: "23514", Message: `new row for relation "links" violates check constraint`}, false},
+		{errors.New("no partition of relation"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isMissingPartition(tt.err); got != tt.want {
+			t.Errorf("isMissingPartition(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	for s, want := range map[string]bool{"drop": false, "detach": true} {
+		if got, err := ParseRetentionMode(s); err != nil || got != want {
+			t.Errorf("ParseRetentionMode(%q) = %v, %v", s, got, err)
+		}
+	}
+	for s, want := range map[string]bool{"copy": true, "insert": false} {
+		if got, err := ParseInsertMode(s); err != nil || got != want {
+			t.Errorf("ParseInsertMode(%q) = %v, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "archive", "Detach", "detatch"} {
+		if _, err := ParseRetentionMode(s); err == nil {
+			t.Errorf("ParseRetentionMode(%q) accepted", s)
+		}
+	}
+	for _, s := range []string{"", "COPY", "inserts"} {
+		if _, err := ParseInsertMode(s); err == nil {
+			t.Errorf("ParseInsertMode(%q) accepted", s)
+		}
+	}
+}
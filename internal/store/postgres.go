@@ -6,17 +6,20 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/wyp0596/go2short/internal/config"
+	"github.com/wyp0596/go2short/internal/logger"
+	"github.com/wyp0596/go2short/internal/metrics"
 )
 
 type Link struct {
-	Code       string
-	LongURL    string
-	CreatedAt  time.Time
-	ExpiresAt  sql.NullTime
-	IsDisabled bool
-	UserID     sql.NullInt32
+	Code         string
+	LongURL      string
+	CreatedAt    time.Time
+	ExpiresAt    sql.NullTime
+	IsDisabled   bool
+	UserID       sql.NullInt32
+	RedirectType int // 0 means use the configured default
 }
 
 type User struct {
@@ -30,10 +33,27 @@ type User struct {
 }
 
 type Store struct {
-	db *sql.DB
+	db         *sql.DB
+	copyClicks bool // bulk insert click events with COPY
+}
+
+// ParseInsertMode parses CLICK_INSERT_MODE: "copy" (COPY FROM STDIN) or
+// "insert" (prepared INSERT per row). Reports whether COPY is used.
+func ParseInsertMode(s string) (bool, error) {
+	switch s {
+	case "copy":
+		return true, nil
+	case "insert":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid click insert mode %q", s)
 }
 
 func New(cfg *config.Config) (*Store, error) {
+	copyClicks, err := ParseInsertMode(cfg.ClickInsertMode)
+	if err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
@@ -49,17 +69,17 @@ func New(cfg *config.Config) (*Store, error) {
 		return nil, fmt.Errorf("ping db: %w", err)
 	}
 
-	return &Store{db: db}, nil
+	return &Store{db: db, copyClicks: copyClicks}, nil
 }
 
 // GetLink fetches a link by code. Returns nil if not found.
 func (s *Store) GetLink(ctx context.Context, code string) (*Link, error) {
 	var link Link
 	err := s.db.QueryRowContext(ctx,
-		`SELECT code, long_url, created_at, expires_at, is_disabled
+		`SELECT code, long_url, created_at, expires_at, is_disabled, user_id, redirect_type
 		 FROM links WHERE code = $1`,
 		code,
-	).Scan(&link.Code, &link.LongURL, &link.CreatedAt, &link.ExpiresAt, &link.IsDisabled)
+	).Scan(&link.Code, &link.LongURL, &link.CreatedAt, &link.ExpiresAt, &link.IsDisabled, &link.UserID, &link.RedirectType)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -72,7 +92,8 @@ func (s *Store) GetLink(ctx context.Context, code string) (*Link, error) {
 
 // CreateLink inserts a new link. Returns error if code already exists.
 // userID can be nil for system/admin created links.
-func (s *Store) CreateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, userID *int) error {
+// redirectType 0 means use the configured default status code.
+func (s *Store) CreateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, userID *int, redirectType int) error {
 	var exp sql.NullTime
 	if expiresAt != nil {
 		exp = sql.NullTime{Time: *expiresAt, Valid: true}
@@ -83,18 +104,51 @@ func (s *Store) CreateLink(ctx context.Context, code, longURL string, expiresAt
 	}
 
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO links (code, long_url, expires_at, user_id) VALUES ($1, $2, $3, $4)`,
-		code, longURL, exp, uid,
+		`INSERT INTO links (code, long_url, expires_at, user_id, redirect_type) VALUES ($1, $2, $3, $4, $5)`,
+		code, longURL, exp, uid, redirectType,
 	)
 	return err
 }
 
-// InsertClickEvents bulk inserts click events.
+// InsertClickEvents bulk inserts click events in one transaction, using COPY
+// unless CLICK_INSERT_MODE=insert. A failed COPY is retried with INSERTs.
+// Events outside the existing partitions create their months' partitions.
 func (s *Store) InsertClickEvents(ctx context.Context, events []ClickEvent) error {
 	if len(events) == 0 {
 		return nil
 	}
 
+	err := s.writeClickEvents(ctx, events)
+	if !isMissingPartition(err) {
+		return err
+	}
+	created, perr := s.ensureClickPartitions(ctx, events)
+	if perr != nil {
+		logger.Error("failed to create missing click partitions", logger.Err(perr))
+		return err
+	}
+	for _, name := range created {
+		logger.Info("created missing click partition", logger.Extra("partition", name))
+	}
+	return s.writeClickEvents(ctx, events)
+}
+
+// writeClickEvents writes events with COPY or INSERT.
+func (s *Store) writeClickEvents(ctx context.Context, events []ClickEvent) error {
+	if s.copyClicks {
+		err := s.copyClickEvents(ctx, events)
+		if err == nil || ctx.Err() != nil || isMissingPartition(err) {
+			return err
+		}
+		logger.Error("COPY click events failed, falling back to INSERT", logger.Err(err), logger.Extra("count", len(events)))
+	}
+	return s.insertClickEvents(ctx, events)
+}
+
+// copyClickEvents streams events with COPY FROM STDIN.
+func (s *Store) copyClickEvents(ctx context.Context, events []ClickEvent) error {
+	defer observeDB("copy_click_events", time.Now())
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -102,36 +156,100 @@ func (s *Store) InsertClickEvents(ctx context.Context, events []ClickEvent) erro
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx,
-		`INSERT INTO click_events (code, ts, ip, ua, device_type, browser, os, referer) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+		pq.CopyIn("click_events", "code", "ts", "ip", "ua", "device_type", "browser", "os", "referer", "country", "region", "city",
+			"referer_host", "utm_source", "utm_medium", "utm_campaign", "is_bot", "click_id"))
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, e := range events {
-		if _, err := stmt.ExecContext(ctx, e.Code, e.Timestamp, e.IP, e.UA, e.DeviceType, e.Browser, e.OS, e.Referer); err != nil {
+		if _, err := stmt.ExecContext(ctx, e.Code, e.Timestamp, e.IP, e.UA, e.DeviceType, e.Browser, e.OS, e.Referer,
+			e.Country, e.Region, e.City, e.RefererHost, e.UTMSource, e.UTMMedium, e.UTMCampaign, e.IsBot, e.ClickID); err != nil {
 			return err
 		}
 	}
+	// Flush buffered rows; errors from the COPY surface here
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
 
+	if err := markDirtyHours(ctx, tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertClickEvents executes one prepared INSERT per event.
+func (s *Store) insertClickEvents(ctx context.Context, events []ClickEvent) error {
+	defer observeDB("insert_click_events", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO click_events (code, ts, ip, ua, device_type, browser, os, referer, country, region, city,
+		 referer_host, utm_source, utm_medium, utm_campaign, is_bot, click_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		if _, err := stmt.ExecContext(ctx, e.Code, e.Timestamp, e.IP, e.UA, e.DeviceType, e.Browser, e.OS, e.Referer,
+			e.Country, e.Region, e.City, e.RefererHost, e.UTMSource, e.UTMMedium, e.UTMCampaign, e.IsBot, e.ClickID); err != nil {
+			return err
+		}
+	}
+
+	if err := markDirtyHours(ctx, tx, events); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
+func observeDB(operation string, start time.Time) {
+	metrics.DBQueries.WithLabelValues(operation).Inc()
+	metrics.DBLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
 type ClickEvent struct {
-	Code       string
-	Timestamp  time.Time
-	IP         string
-	UA         string
-	DeviceType string
-	Browser    string
-	OS         string
-	Referer    string
+	Code        string
+	Timestamp   time.Time
+	IP          string
+	UA          string
+	DeviceType  string
+	Browser     string
+	OS          string
+	Referer     string
+	RefererHost string // Referer host without "www.", empty for direct
+	UTMSource   string
+	UTMMedium   string
+	UTMCampaign string
+	Country     string // ISO 3166-1 alpha-2, empty if unknown
+	Region      string
+	City        string
+	IsBot       bool
+	ClickID     string // empty unless click IDs are appended to long URLs
+	VisitorID   string // not persisted; feeds unique visitor sketches
 }
 
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Ping checks that the database is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 // CreateUser creates a new user and returns the ID.
 func (s *Store) CreateUser(ctx context.Context, email, passwordHash, provider, providerID string) (int, error) {
 	var pwHash sql.NullString
@@ -205,6 +323,22 @@ func (s *Store) UpdateUserLastLogin(ctx context.Context, id int) error {
 	return err
 }
 
+// GetUserTimezone returns the user's stats timezone, or "" if unset.
+func (s *Store) GetUserTimezone(ctx context.Context, id int) (string, error) {
+	var tz sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT timezone FROM users WHERE id = $1`, id).Scan(&tz)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return tz.String, err
+}
+
+// SetUserTimezone stores the user's stats timezone; "" clears it.
+func (s *Store) SetUserTimezone(ctx context.Context, id int, tz string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET timezone = NULLIF($2, '') WHERE id = $1`, id, tz)
+	return err
+}
+
 // ListLinks returns paginated links with optional search.
 // userID nil means all links (admin), otherwise filter by user.
 func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int, userID *int) ([]Link, int, error) {
@@ -222,7 +356,7 @@ func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int,
 				return nil, 0, err
 			}
 			rows, err = s.db.QueryContext(ctx,
-				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id FROM links
+				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id, redirect_type FROM links
 				 WHERE code ILIKE $1 OR long_url ILIKE $1
 				 ORDER BY created_at DESC LIMIT $2 OFFSET $3`, pattern, limit, offset)
 		} else {
@@ -231,7 +365,7 @@ func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int,
 				return nil, 0, err
 			}
 			rows, err = s.db.QueryContext(ctx,
-				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id FROM links
+				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id, redirect_type FROM links
 				 ORDER BY created_at DESC LIMIT $1 OFFSET $2`, limit, offset)
 		}
 	} else {
@@ -244,7 +378,7 @@ func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int,
 				return nil, 0, err
 			}
 			rows, err = s.db.QueryContext(ctx,
-				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id FROM links
+				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id, redirect_type FROM links
 				 WHERE user_id = $1 AND (code ILIKE $2 OR long_url ILIKE $2)
 				 ORDER BY created_at DESC LIMIT $3 OFFSET $4`, *userID, pattern, limit, offset)
 		} else {
@@ -253,7 +387,7 @@ func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int,
 				return nil, 0, err
 			}
 			rows, err = s.db.QueryContext(ctx,
-				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id FROM links
+				`SELECT code, long_url, created_at, expires_at, is_disabled, user_id, redirect_type FROM links
 				 WHERE user_id = $1
 				 ORDER BY created_at DESC LIMIT $2 OFFSET $3`, *userID, limit, offset)
 		}
@@ -266,7 +400,7 @@ func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int,
 	var links []Link
 	for rows.Next() {
 		var l Link
-		if err := rows.Scan(&l.Code, &l.LongURL, &l.CreatedAt, &l.ExpiresAt, &l.IsDisabled, &l.UserID); err != nil {
+		if err := rows.Scan(&l.Code, &l.LongURL, &l.CreatedAt, &l.ExpiresAt, &l.IsDisabled, &l.UserID, &l.RedirectType); err != nil {
 			return nil, 0, err
 		}
 		links = append(links, l)
@@ -274,23 +408,28 @@ func (s *Store) ListLinks(ctx context.Context, search string, limit, offset int,
 	return links, total, rows.Err()
 }
 
-// UpdateLink updates a link's long_url and expires_at.
+// UpdateLink updates a link's long_url, expires_at and redirect_type.
+// redirectType nil keeps the current value.
 // userID nil means admin (can update any), otherwise only user's own links.
-func (s *Store) UpdateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, userID *int) error {
+func (s *Store) UpdateLink(ctx context.Context, code, longURL string, expiresAt *time.Time, redirectType *int, userID *int) error {
 	var exp sql.NullTime
 	if expiresAt != nil {
 		exp = sql.NullTime{Time: *expiresAt, Valid: true}
 	}
+	var rt sql.NullInt16
+	if redirectType != nil {
+		rt = sql.NullInt16{Int16: int16(*redirectType), Valid: true}
+	}
 	var result sql.Result
 	var err error
 	if userID == nil {
 		result, err = s.db.ExecContext(ctx,
-			`UPDATE links SET long_url = $1, expires_at = $2 WHERE code = $3`,
-			longURL, exp, code)
+			`UPDATE links SET long_url = $1, expires_at = $2, redirect_type = COALESCE($3, redirect_type) WHERE code = $4`,
+			longURL, exp, rt, code)
 	} else {
 		result, err = s.db.ExecContext(ctx,
-			`UPDATE links SET long_url = $1, expires_at = $2 WHERE code = $3 AND user_id = $4`,
-			longURL, exp, code, *userID)
+			`UPDATE links SET long_url = $1, expires_at = $2, redirect_type = COALESCE($3, redirect_type) WHERE code = $4 AND user_id = $5`,
+			longURL, exp, rt, code, *userID)
 	}
 	if err != nil {
 		return err
@@ -344,20 +483,43 @@ func (s *Store) DeleteLink(ctx context.Context, code string, userID *int) error
 	return nil
 }
 
+// LinkOwners returns the owner of each given code that has one.
+func (s *Store) LinkOwners(ctx context.Context, codes []string) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT code, user_id FROM links WHERE code = ANY($1) AND user_id IS NOT NULL`, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[string]int)
+	for rows.Next() {
+		var code string
+		var uid int
+		if err := rows.Scan(&code, &uid); err != nil {
+			return nil, err
+		}
+		owners[code] = uid
+	}
+	return owners, rows.Err()
+}
+
 // LinkClickStats holds click statistics for a link.
 type LinkClickStats struct {
-	TotalClicks int       `json:"total_clicks"`
+	TotalClicks int        `json:"total_clicks"`
+	VisitorDays *int64     `json:"visitor_days,omitempty"` // sum of daily unique visitors over the window, filled by the handler
 	DailyClicks []DayClick `json:"daily_clicks"`
 }
 
 type DayClick struct {
-	Date   string `json:"date"`
-	Clicks int    `json:"clicks"`
+	Date           string `json:"date"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors *int64 `json:"unique_visitors,omitempty"` // of the UTC day with this date, filled by the handler
 }
 
 // GetLinkStats returns click statistics for a link.
 // userID nil means admin, otherwise verifies link belongs to user.
-func (s *Store) GetLinkStats(ctx context.Context, code string, days int, userID *int) (*LinkClickStats, error) {
+func (s *Store) GetLinkStats(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (*LinkClickStats, error) {
 	// Verify link ownership if not admin
 	if userID != nil {
 		var count int
@@ -371,17 +533,24 @@ func (s *Store) GetLinkStats(ctx context.Context, code string, days int, userID
 		}
 	}
 
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var total int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM click_events WHERE code = $1`, code).Scan(&total)
+	all := newClickRanges(time.Time{}, wm)
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(opts)+` c WHERE code = $5`, all.args(code)...).Scan(&total)
 	if err != nil {
 		return nil, err
 	}
 
+	recent := dayRanges(days, time.Now(), wm, opts)
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT DATE(ts) as day, COUNT(*) as clicks FROM click_events
-		 WHERE code = $1 AND ts >= NOW() - INTERVAL '1 day' * $2
-		 GROUP BY DATE(ts) ORDER BY day DESC`, code, days)
+		`SELECT DATE(bucket AT TIME ZONE $6) as day, SUM(clicks) as clicks FROM `+deviceRollup.source(opts)+` c
+		 WHERE code = $5
+		 GROUP BY day ORDER BY day DESC`, recent.args(code, opts.location().String())...)
 	if err != nil {
 		return nil, err
 	}
@@ -402,15 +571,27 @@ func (s *Store) GetLinkStats(ctx context.Context, code string, days int, userID
 
 // OverviewStats holds overall statistics.
 type OverviewStats struct {
-	TotalLinks       int `json:"total_links"`
-	ActiveLinks      int `json:"active_links"`
-	TotalClicks      int `json:"total_clicks"`
-	TodayClicks      int `json:"today_clicks"`
+	TotalLinks          int    `json:"total_links"`
+	ActiveLinks         int    `json:"active_links"`
+	TotalClicks         int    `json:"total_clicks"`
+	TodayClicks         int    `json:"today_clicks"`
+	VisitorDays         *int64 `json:"visitor_days,omitempty"` // sum of daily unique visitors over the last 30 UTC days, filled by the handler
+	TodayUniqueVisitors *int64 `json:"today_unique_visitors,omitempty"`
+	VisitorsTimezone    string `json:"visitors_timezone,omitempty"` // days of the visitor counts: UTC
+
+	Comparison *PeriodComparison `json:"comparison,omitempty"` // set by the handler on request
 }
 
 // GetOverviewStats returns overall statistics.
 // userID nil means admin (all), otherwise filter by user.
-func (s *Store) GetOverviewStats(ctx context.Context, userID *int) (*OverviewStats, error) {
+func (s *Store) GetOverviewStats(ctx context.Context, userID *int, opts StatsOptions) (*OverviewStats, error) {
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all := newClickRanges(time.Time{}, wm)
+	today := dayRanges(1, time.Now(), wm, opts)
+
 	var stats OverviewStats
 	if userID == nil {
 		// Admin: global stats
@@ -421,11 +602,12 @@ func (s *Store) GetOverviewStats(ctx context.Context, userID *int) (*OverviewSta
 			`SELECT COUNT(*) FROM links WHERE is_disabled = false AND (expires_at IS NULL OR expires_at > NOW())`).Scan(&stats.ActiveLinks); err != nil {
 			return nil, err
 		}
-		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM click_events`).Scan(&stats.TotalClicks); err != nil {
+		if err := s.db.QueryRowContext(ctx,
+			`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(opts)+` c`, all.args()...).Scan(&stats.TotalClicks); err != nil {
 			return nil, err
 		}
 		if err := s.db.QueryRowContext(ctx,
-			`SELECT COUNT(*) FROM click_events WHERE ts >= DATE_TRUNC('day', NOW())`).Scan(&stats.TodayClicks); err != nil {
+			`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(opts)+` c`, today.args()...).Scan(&stats.TodayClicks); err != nil {
 			return nil, err
 		}
 	} else {
@@ -438,11 +620,13 @@ func (s *Store) GetOverviewStats(ctx context.Context, userID *int) (*OverviewSta
 			return nil, err
 		}
 		if err := s.db.QueryRowContext(ctx,
-			`SELECT COUNT(*) FROM click_events WHERE code IN (SELECT code FROM links WHERE user_id = $1)`, *userID).Scan(&stats.TotalClicks); err != nil {
+			`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(opts)+` c
+			 WHERE code IN (SELECT code FROM links WHERE user_id = $5)`, all.args(*userID)...).Scan(&stats.TotalClicks); err != nil {
 			return nil, err
 		}
 		if err := s.db.QueryRowContext(ctx,
-			`SELECT COUNT(*) FROM click_events WHERE code IN (SELECT code FROM links WHERE user_id = $1) AND ts >= DATE_TRUNC('day', NOW())`, *userID).Scan(&stats.TodayClicks); err != nil {
+			`SELECT COALESCE(SUM(clicks), 0) FROM `+deviceRollup.source(opts)+` c
+			 WHERE code IN (SELECT code FROM links WHERE user_id = $5)`, today.args(*userID)...).Scan(&stats.TodayClicks); err != nil {
 			return nil, err
 		}
 	}
@@ -451,33 +635,39 @@ func (s *Store) GetOverviewStats(ctx context.Context, userID *int) (*OverviewSta
 
 // TopLink holds a link with its click count.
 type TopLink struct {
-	Code       string `json:"code"`
-	LongURL    string `json:"long_url"`
-	ClickCount int    `json:"click_count"`
+	Code       string    `json:"code"`
+	LongURL    string    `json:"long_url"`
+	ClickCount int       `json:"click_count"`
+	Previous   *LinkRank `json:"previous,omitempty"` // set by FillTopLinksPrevious
+	Change     *float64  `json:"change,omitempty"`   // percent from Previous
 }
 
-// GetTopLinks returns the top N links by click count in the last N days.
-// userID nil means admin (all), otherwise filter by user.
-func (s *Store) GetTopLinks(ctx context.Context, limit, days int, userID *int) ([]TopLink, error) {
+// GetTopLinks returns the top N links by click count in the last N calendar
+// days. userID nil means admin (all), otherwise filter by user.
+func (s *Store) GetTopLinks(ctx context.Context, limit, days int, userID *int, opts StatsOptions) ([]TopLink, error) {
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := statsRanges(days, time.Now(), wm, opts)
+
 	var rows *sql.Rows
-	var err error
 	if userID == nil {
 		rows, err = s.db.QueryContext(ctx,
-			`SELECT l.code, l.long_url, COUNT(c.id) as clicks
+			`SELECT l.code, l.long_url, COALESCE(c.clicks, 0) as clicks
 			 FROM links l
-			 LEFT JOIN click_events c ON l.code = c.code AND c.ts >= NOW() - INTERVAL '1 day' * $2
-			 GROUP BY l.code, l.long_url
+			 LEFT JOIN (SELECT code, SUM(clicks) as clicks FROM `+deviceRollup.source(opts)+` src GROUP BY code) c ON l.code = c.code
 			 ORDER BY clicks DESC
-			 LIMIT $1`, limit, days)
+			 LIMIT $5`, r.args(limit)...)
 	} else {
 		rows, err = s.db.QueryContext(ctx,
-			`SELECT l.code, l.long_url, COUNT(c.id) as clicks
+			`SELECT l.code, l.long_url, COALESCE(c.clicks, 0) as clicks
 			 FROM links l
-			 LEFT JOIN click_events c ON l.code = c.code AND c.ts >= NOW() - INTERVAL '1 day' * $2
-			 WHERE l.user_id = $3
-			 GROUP BY l.code, l.long_url
+			 LEFT JOIN (SELECT code, SUM(clicks) as clicks FROM `+deviceRollup.source(opts)+` src
+			            WHERE code IN (SELECT code FROM links WHERE user_id = $6) GROUP BY code) c ON l.code = c.code
+			 WHERE l.user_id = $6
 			 ORDER BY clicks DESC
-			 LIMIT $1`, limit, days, *userID)
+			 LIMIT $5`, r.args(limit, *userID)...)
 	}
 	if err != nil {
 		return nil, err
@@ -497,23 +687,28 @@ func (s *Store) GetTopLinks(ctx context.Context, limit, days int, userID *int) (
 
 // GetClickTrend returns daily click counts for the last N days across all links.
 // userID nil means admin (all), otherwise filter by user.
-func (s *Store) GetClickTrend(ctx context.Context, days int, userID *int) ([]DayClick, error) {
+func (s *Store) GetClickTrend(ctx context.Context, days int, userID *int, opts StatsOptions) ([]DayClick, error) {
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := dayRanges(days, time.Now(), wm, opts)
+	tz := opts.location().String()
+
 	var rows *sql.Rows
-	var err error
 	if userID == nil {
 		rows, err = s.db.QueryContext(ctx,
-			`SELECT DATE(ts) as day, COUNT(*) as clicks
-			 FROM click_events
-			 WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 GROUP BY DATE(ts)
-			 ORDER BY day ASC`, days)
+			`SELECT DATE(bucket AT TIME ZONE $5) as day, SUM(clicks) as clicks
+			 FROM `+deviceRollup.source(opts)+` c
+			 GROUP BY day
+			 ORDER BY day ASC`, r.args(tz)...)
 	} else {
 		rows, err = s.db.QueryContext(ctx,
-			`SELECT DATE(ts) as day, COUNT(*) as clicks
-			 FROM click_events
-			 WHERE ts >= NOW() - INTERVAL '1 day' * $1 AND code IN (SELECT code FROM links WHERE user_id = $2)
-			 GROUP BY DATE(ts)
-			 ORDER BY day ASC`, days, *userID)
+			`SELECT DATE(bucket AT TIME ZONE $6) as day, SUM(clicks) as clicks
+			 FROM `+deviceRollup.source(opts)+` c
+			 WHERE code IN (SELECT code FROM links WHERE user_id = $5)
+			 GROUP BY day
+			 ORDER BY day ASC`, r.args(*userID, tz)...)
 	}
 	if err != nil {
 		return nil, err
@@ -633,6 +828,47 @@ func (s *Store) DeleteAPIToken(ctx context.Context, id int, userID *int) error {
 	return nil
 }
 
+// StatsOptions filter the clicks counted by stats queries.
+type StatsOptions struct {
+	IncludeBots bool           // count clicks classified as bots (default: humans only)
+	Location    *time.Location // day boundaries and "today", nil = UTC
+}
+
+func (o StatsOptions) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+// localDaysStart is the start (local midnight) of the last days calendar
+// days in loc, including the day of now.
+func localDaysStart(days int, now time.Time, loc *time.Location) time.Time {
+	y, m, d := now.In(loc).Date()
+	return time.Date(y, m, d-days+1, 0, 0, 0, 0, loc)
+}
+
+// statsRanges is the click ranges of the last days calendar days in opts'
+// timezone, including today up to now. Every "last N days" stat uses it.
+// Local midnight falls inside a rollup hour in timezones that are not a
+// whole number of hours from UTC: the rest of that hour is read from
+// click_events.
+func statsRanges(days int, now, watermark time.Time, opts StatsOptions) clickRanges {
+	return exactRanges(localDaysStart(days, now, opts.location()), watermark)
+}
+
+// dayRanges is statsRanges for results grouped by local day. Days that do
+// not start at UTC midnight cannot use the daily rollups. Where local
+// midnight falls inside a rollup hour, an hour within the range is counted
+// in the local day it starts in.
+func dayRanges(days int, now, watermark time.Time, opts StatsOptions) clickRanges {
+	r := statsRanges(days, now, watermark, opts)
+	if opts.location() != time.UTC {
+		r = r.hourlyOnly()
+	}
+	return r
+}
+
 // DistributionItem holds count for a single category value.
 type DistributionItem struct {
 	Name  string `json:"name"`
@@ -648,107 +884,238 @@ type DeviceStats struct {
 
 // GetDeviceStats returns device/browser/OS distribution for all clicks.
 // userID nil means admin (all), otherwise filter by user's links.
-func (s *Store) GetDeviceStats(ctx context.Context, days int, userID *int) (*DeviceStats, error) {
-	stats := &DeviceStats{}
-	var err error
+func (s *Store) GetDeviceStats(ctx context.Context, days int, userID *int, opts StatsOptions) (*DeviceStats, error) {
+	filter, args, err := s.statsScope(ctx, days, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.deviceStats(ctx, opts, filter, args...)
+}
+
+// GetLinkDeviceStats returns device/browser/OS distribution for a specific link.
+// userID nil means admin, otherwise verifies link belongs to user.
+func (s *Store) GetLinkDeviceStats(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (*DeviceStats, error) {
+	filter, args, err := s.linkStatsScope(ctx, code, days, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.deviceStats(ctx, opts, filter, args...)
+}
+
+// statsScope returns the WHERE condition and arguments for stats over the
+// last days calendar days in opts' timezone, for all links (userID nil) or
+// the user's links.
+func (s *Store) statsScope(ctx context.Context, days int, userID *int, opts StatsOptions) (string, []any, error) {
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	r := statsRanges(days, time.Now(), wm, opts)
 
 	if userID == nil {
-		stats.DeviceType, err = s.queryDistribution(ctx,
-			`SELECT COALESCE(NULLIF(device_type, ''), 'unknown') as name, COUNT(*) as count
-			 FROM click_events WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 GROUP BY name ORDER BY count DESC LIMIT 10`, days)
-	} else {
-		stats.DeviceType, err = s.queryDistribution(ctx,
-			`SELECT COALESCE(NULLIF(device_type, ''), 'unknown') as name, COUNT(*) as count
-			 FROM click_events WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 AND code IN (SELECT code FROM links WHERE user_id = $2)
-			 GROUP BY name ORDER BY count DESC LIMIT 10`, days, *userID)
+		return "TRUE", r.args(), nil
 	}
+	return "code IN (SELECT code FROM links WHERE user_id = $5)", r.args(*userID), nil
+}
+
+// linkStatsScope is statsScope for a single link. userID nil means admin,
+// otherwise it verifies the link belongs to the user.
+func (s *Store) linkStatsScope(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (string, []any, error) {
+	if err := s.checkLinkOwner(ctx, code, userID); err != nil {
+		return "", nil, err
+	}
+
+	wm, err := s.rollupWatermark(ctx)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
+	r := statsRanges(days, time.Now(), wm, opts)
+
+	return "code = $5", r.args(code), nil
+}
 
+// checkLinkOwner returns sql.ErrNoRows unless userID is nil (admin) or owns
+// the link.
+func (s *Store) checkLinkOwner(ctx context.Context, code string, userID *int) error {
 	if userID == nil {
-		stats.Browser, err = s.queryDistribution(ctx,
-			`SELECT COALESCE(NULLIF(browser, ''), 'unknown') as name, COUNT(*) as count
-			 FROM click_events WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 GROUP BY name ORDER BY count DESC LIMIT 10`, days)
-	} else {
-		stats.Browser, err = s.queryDistribution(ctx,
-			`SELECT COALESCE(NULLIF(browser, ''), 'unknown') as name, COUNT(*) as count
-			 FROM click_events WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 AND code IN (SELECT code FROM links WHERE user_id = $2)
-			 GROUP BY name ORDER BY count DESC LIMIT 10`, days, *userID)
+		return nil
+	}
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM links WHERE code = $1 AND user_id = $2`, code, *userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// checkLinkExists is checkLinkOwner that also returns sql.ErrNoRows for
+// admins when the link does not exist.
+func (s *Store) checkLinkExists(ctx context.Context, code string, userID *int) error {
+	if userID != nil {
+		return s.checkLinkOwner(ctx, code, userID)
 	}
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM links WHERE code = $1`, code).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// deviceStats returns device/browser/OS distribution over the click source.
+// filter is a WHERE condition whose parameters start at $5.
+func (s *Store) deviceStats(ctx context.Context, opts StatsOptions, filter string, args ...any) (*DeviceStats, error) {
+	stats := &DeviceStats{}
+	err := s.distributions(ctx, deviceRollup.source(opts), []distribution{
+		{"device_type", &stats.DeviceType},
+		{"browser", &stats.Browser},
+		{"os", &stats.OS},
+	}, filter, args...)
 	if err != nil {
 		return nil, err
 	}
+	return stats, nil
+}
 
-	if userID == nil {
-		stats.OS, err = s.queryDistribution(ctx,
-			`SELECT COALESCE(NULLIF(os, ''), 'unknown') as name, COUNT(*) as count
-			 FROM click_events WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 GROUP BY name ORDER BY count DESC LIMIT 10`, days)
-	} else {
-		stats.OS, err = s.queryDistribution(ctx,
-			`SELECT COALESCE(NULLIF(os, ''), 'unknown') as name, COUNT(*) as count
-			 FROM click_events WHERE ts >= NOW() - INTERVAL '1 day' * $1
-			 AND code IN (SELECT code FROM links WHERE user_id = $2)
-			 GROUP BY name ORDER BY count DESC LIMIT 10`, days, *userID)
+// GeoStats holds distribution data for country, region, and city.
+type GeoStats struct {
+	Country []DistributionItem `json:"country"`
+	Region  []DistributionItem `json:"region"`
+	City    []DistributionItem `json:"city"`
+}
+
+// GetGeoStats returns country/region/city distribution for all clicks.
+// userID nil means admin (all), otherwise filter by user's links.
+func (s *Store) GetGeoStats(ctx context.Context, days int, userID *int, opts StatsOptions) (*GeoStats, error) {
+	filter, args, err := s.statsScope(ctx, days, userID, opts)
+	if err != nil {
+		return nil, err
 	}
+	return s.geoStats(ctx, opts, filter, args...)
+}
+
+// GetLinkGeoStats returns country/region/city distribution for a specific link.
+// userID nil means admin, otherwise verifies link belongs to user.
+func (s *Store) GetLinkGeoStats(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (*GeoStats, error) {
+	filter, args, err := s.linkStatsScope(ctx, code, days, userID, opts)
 	if err != nil {
 		return nil, err
 	}
+	return s.geoStats(ctx, opts, filter, args...)
+}
 
+// geoStats returns country/region/city distribution over the geo source.
+// Regions and cities are listed with their country since names repeat.
+func (s *Store) geoStats(ctx context.Context, opts StatsOptions, filter string, args ...any) (*GeoStats, error) {
+	stats := &GeoStats{}
+	err := s.distributions(ctx, geoRollup.source(opts), []distribution{
+		{"country", &stats.Country},
+		{"CASE WHEN region <> '' THEN concat_ws(', ', region, NULLIF(country, '')) END", &stats.Region},
+		{"CASE WHEN city <> '' THEN concat_ws(', ', city, NULLIF(region, ''), NULLIF(country, '')) END", &stats.City},
+	}, filter, args...)
+	if err != nil {
+		return nil, err
+	}
 	return stats, nil
 }
 
-// GetLinkDeviceStats returns device/browser/OS distribution for a specific link.
+// GetReferrerStats returns referrer domain distribution for all clicks.
+// Clicks without a Referer count as "(direct)".
+// userID nil means admin (all), otherwise filter by user's links.
+func (s *Store) GetReferrerStats(ctx context.Context, days int, userID *int, opts StatsOptions) ([]DistributionItem, error) {
+	filter, args, err := s.statsScope(ctx, days, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.referrerStats(ctx, opts, filter, args...)
+}
+
+// GetLinkReferrerStats returns referrer domain distribution for a specific link.
 // userID nil means admin, otherwise verifies link belongs to user.
-func (s *Store) GetLinkDeviceStats(ctx context.Context, code string, days int, userID *int) (*DeviceStats, error) {
-	// Verify link ownership if not admin
-	if userID != nil {
-		var count int
-		err := s.db.QueryRowContext(ctx,
-			`SELECT COUNT(*) FROM links WHERE code = $1 AND user_id = $2`, code, *userID).Scan(&count)
-		if err != nil {
-			return nil, err
-		}
-		if count == 0 {
-			return nil, sql.ErrNoRows
-		}
+func (s *Store) GetLinkReferrerStats(ctx context.Context, code string, days int, userID *int, opts StatsOptions) ([]DistributionItem, error) {
+	filter, args, err := s.linkStatsScope(ctx, code, days, userID, opts)
+	if err != nil {
+		return nil, err
 	}
+	return s.referrerStats(ctx, opts, filter, args...)
+}
 
-	stats := &DeviceStats{}
-	var err error
+func (s *Store) referrerStats(ctx context.Context, opts StatsOptions, filter string, args ...any) ([]DistributionItem, error) {
+	var items []DistributionItem
+	err := s.distributions(ctx, trafficRollup.source(opts), []distribution{
+		{"COALESCE(NULLIF(referer_host, ''), '(direct)')", &items},
+	}, filter, args...)
+	return items, err
+}
 
-	stats.DeviceType, err = s.queryDistribution(ctx,
-		`SELECT COALESCE(NULLIF(device_type, ''), 'unknown') as name, COUNT(*) as count
-		 FROM click_events WHERE code = $1 AND ts >= NOW() - INTERVAL '1 day' * $2
-		 GROUP BY name ORDER BY count DESC LIMIT 10`, code, days)
+// CampaignStats holds distribution data for utm_source, utm_medium and
+// utm_campaign. Clicks without the parameter count as "(none)".
+type CampaignStats struct {
+	Source   []DistributionItem `json:"utm_source"`
+	Medium   []DistributionItem `json:"utm_medium"`
+	Campaign []DistributionItem `json:"utm_campaign"`
+}
+
+// GetCampaignStats returns UTM parameter distribution for all clicks.
+// userID nil means admin (all), otherwise filter by user's links.
+func (s *Store) GetCampaignStats(ctx context.Context, days int, userID *int, opts StatsOptions) (*CampaignStats, error) {
+	filter, args, err := s.statsScope(ctx, days, userID, opts)
 	if err != nil {
 		return nil, err
 	}
+	return s.campaignStats(ctx, opts, filter, args...)
+}
 
-	stats.Browser, err = s.queryDistribution(ctx,
-		`SELECT COALESCE(NULLIF(browser, ''), 'unknown') as name, COUNT(*) as count
-		 FROM click_events WHERE code = $1 AND ts >= NOW() - INTERVAL '1 day' * $2
-		 GROUP BY name ORDER BY count DESC LIMIT 10`, code, days)
+// GetLinkCampaignStats returns UTM parameter distribution for a specific link.
+// userID nil means admin, otherwise verifies link belongs to user.
+func (s *Store) GetLinkCampaignStats(ctx context.Context, code string, days int, userID *int, opts StatsOptions) (*CampaignStats, error) {
+	filter, args, err := s.linkStatsScope(ctx, code, days, userID, opts)
 	if err != nil {
 		return nil, err
 	}
+	return s.campaignStats(ctx, opts, filter, args...)
+}
 
-	stats.OS, err = s.queryDistribution(ctx,
-		`SELECT COALESCE(NULLIF(os, ''), 'unknown') as name, COUNT(*) as count
-		 FROM click_events WHERE code = $1 AND ts >= NOW() - INTERVAL '1 day' * $2
-		 GROUP BY name ORDER BY count DESC LIMIT 10`, code, days)
+func (s *Store) campaignStats(ctx context.Context, opts StatsOptions, filter string, args ...any) (*CampaignStats, error) {
+	stats := &CampaignStats{}
+	err := s.distributions(ctx, trafficRollup.source(opts), []distribution{
+		{"COALESCE(NULLIF(utm_source, ''), '(none)')", &stats.Source},
+		{"COALESCE(NULLIF(utm_medium, ''), '(none)')", &stats.Medium},
+		{"COALESCE(NULLIF(utm_campaign, ''), '(none)')", &stats.Campaign},
+	}, filter, args...)
 	if err != nil {
 		return nil, err
 	}
-
 	return stats, nil
 }
 
+// distribution is one top-10 breakdown: a column expression of the source
+// and where to put the result.
+type distribution struct {
+	column string
+	items  *[]DistributionItem
+}
+
+func (s *Store) distributions(ctx context.Context, source string, ds []distribution, filter string, args ...any) error {
+	for _, d := range ds {
+		items, err := s.queryDistribution(ctx,
+			`SELECT COALESCE(NULLIF(`+d.column+`, ''), 'unknown') as name, SUM(clicks) as count
+			 FROM `+source+` c WHERE `+filter+`
+			 GROUP BY name ORDER BY count DESC LIMIT 10`, args...)
+		if err != nil {
+			return err
+		}
+		*d.items = items
+	}
+	return nil
+}
+
 func (s *Store) queryDistribution(ctx context.Context, query string, args ...any) ([]DistributionItem, error) {
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInvalidQuery is returned for click queries with unknown buckets,
+// dimensions or an empty time range.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// maxQueryRows bounds the (group, bucket) rows a click query returns.
+const maxQueryRows = 10000
+
+// Click query buckets. An empty bucket returns one total per group.
+var queryBuckets = map[string]bool{"": true, "hour": true, "day": true, "week": true, "month": true}
+
+// queryDim is a dimension clicks can be grouped or filtered by.
+type queryDim struct {
+	family *rollupFamily // nil: available in every family (code)
+	column string
+	label  string // output value, same as the distribution endpoints
+}
+
+var queryDims = map[string]queryDim{
+	"code":         {nil, "code", "code"},
+	"device":       {&deviceRollup, "device_type", "COALESCE(NULLIF(device_type, ''), 'unknown')"},
+	"browser":      {&deviceRollup, "browser", "COALESCE(NULLIF(browser, ''), 'unknown')"},
+	"os":           {&deviceRollup, "os", "COALESCE(NULLIF(os, ''), 'unknown')"},
+	"country":      {&geoRollup, "country", "COALESCE(NULLIF(country, ''), 'unknown')"},
+	"region":       {&geoRollup, "region", "COALESCE(NULLIF(region, ''), 'unknown')"},
+	"city":         {&geoRollup, "city", "COALESCE(NULLIF(city, ''), 'unknown')"},
+	"referrer":     {&trafficRollup, "referer_host", "COALESCE(NULLIF(referer_host, ''), '(direct)')"},
+	"utm_source":   {&trafficRollup, "utm_source", "COALESCE(NULLIF(utm_source, ''), '(none)')"},
+	"utm_medium":   {&trafficRollup, "utm_medium", "COALESCE(NULLIF(utm_medium, ''), '(none)')"},
+	"utm_campaign": {&trafficRollup, "utm_campaign", "COALESCE(NULLIF(utm_campaign, ''), '(none)')"},
+}
+
+// ClickQuery selects clicks in [From, To), optionally bucketed in Location
+// and grouped by dimensions. Filters match dimension values as returned.
+type ClickQuery struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location // bucket boundaries, nil = UTC
+	Bucket   string         // "", hour, day, week, month
+	GroupBy  []string
+	Filters  map[string][]string
+	UserID   *int // nil = all links
+	Opts     StatsOptions
+}
+
+// ClickPoint is the click count of one time bucket.
+type ClickPoint struct {
+	Time   time.Time `json:"time"`
+	Clicks int64     `json:"clicks"`
+}
+
+// ClickSeries holds the clicks of one combination of group-by values.
+type ClickSeries struct {
+	Group  map[string]string `json:"group"`
+	Total  int64             `json:"total"`
+	Points []ClickPoint      `json:"points,omitempty"`
+}
+
+// ClickQueryResult is the answer to a ClickQuery. Source is "rollup", or
+// "raw" when the dimensions span rollups or the timezone is not aligned to
+// whole hours; raw results only cover clicks still within retention.
+type ClickQueryResult struct {
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Source    string        `json:"source"`
+	Truncated bool          `json:"truncated"`
+	Series    []ClickSeries `json:"series"`
+}
+
+// QueryClicks runs a click query. Invalid queries return an error wrapping
+// ErrInvalidQuery.
+func (s *Store) QueryClicks(ctx context.Context, q ClickQuery) (*ClickQueryResult, error) {
+	defer observeDB("query_clicks", time.Now())
+
+	wm, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query, args, res, err := buildClickQuery(q, wm)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+`
+		 LIMIT `+strconv.Itoa(maxQueryRows+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	index := make(map[string]int) // group key -> series
+	n := 0
+	for rows.Next() {
+		if n == maxQueryRows {
+			res.Truncated = true
+			break
+		}
+		n++
+
+		values := make([]string, len(q.GroupBy))
+		var t time.Time
+		var clicks int64
+		dest := make([]any, 0, len(values)+2)
+		if q.Bucket != "" {
+			dest = append(dest, &t)
+		}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &clicks)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		key := strings.Join(values, "\x00")
+		i, ok := index[key]
+		if !ok {
+			group := make(map[string]string, len(values))
+			for j, d := range q.GroupBy {
+				group[d] = values[j]
+			}
+			i = len(res.Series)
+			index[key] = i
+			res.Series = append(res.Series, ClickSeries{Group: group})
+		}
+		res.Series[i].Total += clicks
+		if q.Bucket != "" {
+			res.Series[i].Points = append(res.Series[i].Points, ClickPoint{Time: t.In(loc), Clicks: clicks})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	res.From, res.To = res.From.In(loc), res.To.In(loc)
+	return res, nil
+}
+
+// buildClickQuery returns the SQL and arguments for q, without a row limit.
+// Rows are ordered by group, then time.
+func buildClickQuery(q ClickQuery, watermark time.Time) (string, []any, *ClickQueryResult, error) {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	if !queryBuckets[q.Bucket] {
+		return "", nil, nil, fmt.Errorf("%w: unknown bucket %q", ErrInvalidQuery, q.Bucket)
+	}
+
+	// Rollups are hourly: widen the range to whole hours
+	from := q.From.UTC().Truncate(time.Hour)
+	to := q.To.UTC().Truncate(time.Hour)
+	if to.Before(q.To) {
+		to = to.Add(time.Hour)
+	}
+	if !from.Before(to) {
+		return "", nil, nil, fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+
+	// All dimensions must come from one rollup family, otherwise read raw
+	family := &deviceRollup
+	raw := false
+	seen := make(map[string]bool)
+	var used []string
+	for _, d := range q.GroupBy {
+		if seen[d] {
+			return "", nil, nil, fmt.Errorf("%w: duplicate group_by %q", ErrInvalidQuery, d)
+		}
+		seen[d] = true
+		used = append(used, d)
+	}
+	for d := range q.Filters {
+		used = append(used, d)
+	}
+	var picked *rollupFamily
+	for _, name := range used {
+		d, ok := queryDims[name]
+		if !ok {
+			return "", nil, nil, fmt.Errorf("%w: unknown dimension %q", ErrInvalidQuery, name)
+		}
+		if d.family == nil {
+			continue
+		}
+		if picked != nil && picked != d.family {
+			raw = true
+		}
+		picked = d.family
+	}
+	if picked != nil {
+		family = picked
+	}
+	// Hourly rollups cannot be split at half-hour offsets
+	if q.Bucket != "" && (!wholeHourOffset(loc, from) || !wholeHourOffset(loc, to)) {
+		raw = true
+	}
+
+	var source string
+	var args []any
+	res := &ClickQueryResult{From: from, To: to, Source: "rollup"}
+	if raw {
+		res.Source = "raw"
+		source = rawClickSource(q.Opts)
+		args = []any{from}
+	} else {
+		r := newClickRanges(from, watermark).until(to)
+		// Daily rollups only fit UTC days and coarser buckets
+		if q.Bucket == "hour" || (q.Bucket != "" && loc != time.UTC) {
+			r = r.hourlyOnly()
+		}
+		source = family.source(q.Opts)
+		args = r.args()
+	}
+	param := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	// Columns are (t, g0, g1, ...); rows are ordered by group, then time
+	// so each series is contiguous
+	var cols, groups, order []string
+	if q.Bucket != "" {
+		tz := param(loc.String())
+		cols = append(cols, "date_trunc('"+q.Bucket+"', bucket AT TIME ZONE "+tz+") AT TIME ZONE "+tz+" AS t")
+		groups = append(groups, "t")
+	}
+	for i, d := range q.GroupBy {
+		alias := "g" + strconv.Itoa(i)
+		cols = append(cols, queryDims[d].label+" AS "+alias)
+		groups = append(groups, alias)
+		order = append(order, alias)
+	}
+	if q.Bucket != "" {
+		order = append(order, "t")
+	}
+
+	where := []string{"bucket < " + param(to)}
+	filters := make([]string, 0, len(q.Filters))
+	for d := range q.Filters {
+		filters = append(filters, d)
+	}
+	sort.Strings(filters)
+	for _, d := range filters {
+		if len(q.Filters[d]) == 0 {
+			continue
+		}
+		where = append(where, queryDims[d].label+" = ANY("+param(pq.Array(q.Filters[d]))+")")
+	}
+	if q.UserID != nil {
+		where = append(where, "code IN (SELECT code FROM links WHERE user_id = "+param(*q.UserID)+")")
+	}
+
+	query := `SELECT ` + strings.Join(append(cols, "COALESCE(SUM(clicks), 0) AS clicks"), ", ") + `
+		 FROM ` + source + ` c
+		 WHERE ` + strings.Join(where, " AND ")
+	if len(groups) > 0 {
+		query += `
+		 GROUP BY ` + strings.Join(groups, ", ") + `
+		 ORDER BY ` + strings.Join(order, ", ")
+	}
+	return query, args, res, nil
+}
+
+// rawClickSource is a subquery over click_events alone with every query
+// dimension, for clicks at or after $1.
+func rawClickSource(opts StatsOptions) string {
+	cols := []string{"code", "ts AS bucket", "1 AS clicks"}
+	for _, f := range rollupFamilies {
+		for _, d := range f.dims {
+			cols = append(cols, "COALESCE("+d+", '') AS "+d)
+		}
+	}
+	bots := ""
+	if !opts.IncludeBots {
+		bots = " AND NOT is_bot"
+	}
+	return `(SELECT ` + strings.Join(cols, ", ") + ` FROM click_events WHERE ts >= $1::timestamptz` + bots + `)`
+}
+
+// wholeHourOffset reports whether loc is a whole number of hours from UTC at t.
+func wholeHourOffset(loc *time.Location, t time.Time) bool {
+	_, offset := t.In(loc).Zone()
+	return offset%3600 == 0
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildClickQuery(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	uid := 7
+	from := ts("2025-03-01T00:00:00Z")
+	to := ts("2025-03-08T00:00:00Z")
+	wm := ts("2025-03-05T07:00:00Z")
+
+	tests := []struct {
+		name       string
+		q          ClickQuery
+		wantSource string
+		wantSQL    []string
+		wantArgs   int
+		noDaily    bool
+	}{
+		{
+			name:       "totals by code",
+			q:          ClickQuery{From: from, To: to, GroupBy: []string{"code"}},
+			wantSource: "rollup",
+			wantSQL:    []string{"FROM click_rollups_daily", "code AS g0", "GROUP BY g0", "ORDER BY g0", "NOT is_bot"},
+			wantArgs:   5,
+		},
+		{
+			name:       "daily in UTC by country",
+			q:          ClickQuery{From: from, To: to, Bucket: "day", GroupBy: []string{"country"}, Filters: map[string][]string{"code": {"abc"}}},
+			wantSource: "rollup",
+			wantSQL:    []string{"FROM click_geo_rollups_daily", "date_trunc('day', bucket AT TIME ZONE $5) AT TIME ZONE $5 AS t", "ORDER BY g0, t", "code = ANY($7)"},
+			wantArgs:   7,
+		},
+		{
+			name:       "hourly skips daily rollups",
+			q:          ClickQuery{From: from, To: to, Bucket: "hour", Opts: StatsOptions{IncludeBots: true}},
+			wantSource: "rollup",
+			wantSQL:    []string{"GROUP BY t"},
+			wantArgs:   6,
+			noDaily:    true,
+		},
+		{
+			name:       "daily in Berlin skips daily rollups",
+			q:          ClickQuery{From: from, To: to, Location: berlin, Bucket: "day", UserID: &uid},
+			wantSource: "rollup",
+			wantSQL:    []string{"user_id = $7"},
+			wantArgs:   7,
+			noDaily:    true,
+		},
+		{
+			name:       "dimensions across rollups read raw",
+			q:          ClickQuery{From: from, To: to, GroupBy: []string{"device", "country"}},
+			wantSource: "raw",
+			wantSQL:    []string{"FROM click_events WHERE ts >= $1", "bucket < $2"},
+			wantArgs:   2,
+		},
+		{
+			name:       "half-hour timezone reads raw",
+			q:          ClickQuery{From: from, To: to, Location: kolkata, Bucket: "day"},
+			wantSource: "raw",
+			wantArgs:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, res, err := buildClickQuery(tt.q, wm)
+			if err != nil {
+				t.Fatalf("buildClickQuery() error = %v", err)
+			}
+			if res.Source != tt.wantSource {
+				t.Errorf("source = %q, want %q", res.Source, tt.wantSource)
+			}
+			for _, want := range tt.wantSQL {
+				if !strings.Contains(query, want) {
+					t.Errorf("query missing %q:\n%s", want, query)
+				}
+			}
+			if len(args) != tt.wantArgs {
+				t.Errorf("len(args) = %d, want %d", len(args), tt.wantArgs)
+			}
+			if strings.Contains(query, "NOT is_bot") == tt.q.Opts.IncludeBots {
+				t.Errorf("bot filter does not match IncludeBots=%v", tt.q.Opts.IncludeBots)
+			}
+			if tt.wantSource == "rollup" {
+				dayStart, dayEnd := args[1].(time.Time), args[2].(time.Time)
+				if tt.noDaily != dayStart.Equal(dayEnd) {
+					t.Errorf("daily range [%v, %v), want empty = %v", dayStart, dayEnd, tt.noDaily)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildClickQueryInvalid(t *testing.T) {
+	from := ts("2025-03-01T00:00:00Z")
+	to := ts("2025-03-08T00:00:00Z")
+	for name, q := range map[string]ClickQuery{
+		"bucket":    {From: from, To: to, Bucket: "minute"},
+		"dimension": {From: from, To: to, GroupBy: []string{"ip"}},
+		"filter":    {From: from, To: to, Filters: map[string][]string{"ua": {"x"}}},
+		"duplicate": {From: from, To: to, GroupBy: []string{"os", "os"}},
+		"range":     {From: to, To: from},
+	} {
+		if _, _, _, err := buildClickQuery(q, time.Time{}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: error = %v, want ErrInvalidQuery", name, err)
+		}
+	}
+}
+
+func TestBuildClickQueryRoundsToHours(t *testing.T) {
+	q := ClickQuery{From: ts("2025-03-01T10:20:00Z"), To: ts("2025-03-01T12:05:00Z")}
+	_, _, res, err := buildClickQuery(q, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.From.Equal(ts("2025-03-01T10:00:00Z")) || !res.To.Equal(ts("2025-03-01T13:00:00Z")) {
+		t.Errorf("range = [%v, %v), want [10:00, 13:00)", res.From, res.To)
+	}
+}
+
+func TestBuildClickQueryEndsRollupRanges(t *testing.T) {
+	wm := ts("2025-03-07T00:00:00Z") // rollups complete well past to
+	tests := []struct {
+		name             string
+		from, to         string
+		dayStart, dayEnd string
+		hourlyEnd        string
+		emptyDaily       bool
+	}{
+		{"clipped", "2025-03-01T00:00:00Z", "2025-03-03T12:00:00Z",
+			"2025-03-01T00:00:00Z", "2025-03-03T00:00:00Z", "2025-03-03T12:00:00Z", false},
+		{"within one day", "2025-03-01T06:00:00Z", "2025-03-01T18:00:00Z",
+			"", "", "2025-03-01T18:00:00Z", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := ClickQuery{From: ts(tt.from), To: ts(tt.to)}
+			_, args, _, err := buildClickQuery(q, wm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dayStart, dayEnd, hourlyEnd := args[1].(time.Time), args[2].(time.Time), args[3].(time.Time)
+			if tt.emptyDaily {
+				if dayEnd.After(dayStart) {
+					t.Errorf("daily range = [%v, %v), want empty", dayStart, dayEnd)
+				}
+			} else if !dayStart.Equal(ts(tt.dayStart)) || !dayEnd.Equal(ts(tt.dayEnd)) {
+				t.Errorf("daily range = [%v, %v), want [%s, %s)", dayStart, dayEnd, tt.dayStart, tt.dayEnd)
+			}
+			// Daily rollups must not cover the day containing to
+			if dayEnd.After(ts(tt.to)) {
+				t.Errorf("daily range ends at %v, after to", dayEnd)
+			}
+			if !hourlyEnd.Equal(ts(tt.hourlyEnd)) {
+				t.Errorf("hourly rollups end at %v, want %s", hourlyEnd, tt.hourlyEnd)
+			}
+		})
+	}
+}
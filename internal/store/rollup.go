@@ -0,0 +1,333 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Click rollups
+//
+// Each rollup family counts clicks per UTC hour by code and a set of
+// dimensions (device/browser/OS, geo, traffic source) in its hourly table and sums them per
+// UTC day in its daily table. All are complete for ts < watermark
+// (rollup_state). Stats read whole days from the daily table, remaining
+// whole hours from the hourly table and only clicks after the watermark from
+// click_events.
+
+const (
+	rollupName    = "clicks"
+	rollupLockKey = 0x676f3273 // pg advisory lock: one rollup run at a time
+)
+
+const day = 24 * time.Hour
+
+// rollupFamily is a pair of rollup tables over the same dimensions. Every
+// family is also keyed by is_bot. Dimension columns are NOT NULL in rollups;
+// NULLs in click_events become empty strings.
+type rollupFamily struct {
+	hourly string
+	daily  string
+	dims   []string
+}
+
+var (
+	deviceRollup  = rollupFamily{"click_rollups_hourly", "click_rollups_daily", []string{"device_type", "browser", "os"}}
+	geoRollup     = rollupFamily{"click_geo_rollups_hourly", "click_geo_rollups_daily", []string{"country", "region", "city"}}
+	trafficRollup = rollupFamily{"click_traffic_rollups_hourly", "click_traffic_rollups_daily",
+		[]string{"referer_host", "utm_source", "utm_medium", "utm_campaign"}}
+
+	rollupFamilies = []rollupFamily{deviceRollup, geoRollup, trafficRollup}
+)
+
+// rawDims selects the dimensions from click_events, NULLs as empty strings.
+func (f rollupFamily) rawDims() string {
+	raw := make([]string, len(f.dims))
+	for i, d := range f.dims {
+		raw[i] = "COALESCE(" + d + ", '')"
+	}
+	return strings.Join(raw, ", ")
+}
+
+// source is a subquery of (code, bucket, is_bot, dims..., clicks) over the
+// family's rollups and recent raw events, without bot clicks unless
+// opts.IncludeBots. It uses $1-$4 from clickRanges.args, so callers number
+// their own parameters from $5. A start inside an hour (exactRanges) reads
+// the rest of that hour from click_events; the hour is computed from the
+// epoch so it does not depend on the session timezone.
+func (f rollupFamily) source(opts StatsOptions) string {
+	dims, raw := strings.Join(f.dims, ", "), f.rawDims()
+	bots := ""
+	if !opts.IncludeBots {
+		bots = " AND NOT is_bot"
+	}
+	return `(
+	SELECT code, bucket, is_bot, ` + dims + `, clicks FROM ` + f.daily + `
+	 WHERE bucket >= $2::timestamptz AND bucket < $3::timestamptz` + bots + `
+	UNION ALL
+	SELECT code, bucket, is_bot, ` + dims + `, clicks FROM ` + f.hourly + `
+	 WHERE bucket >= $1::timestamptz AND bucket < $4::timestamptz
+	   AND (bucket < $2::timestamptz OR bucket >= $3::timestamptz)` + bots + `
+	UNION ALL
+	SELECT code, ts, is_bot, ` + raw + `, 1 FROM click_events
+	 WHERE ts >= $1::timestamptz
+	   AND ts < LEAST(to_timestamp(ceil(extract(epoch FROM $1::timestamptz) / 3600) * 3600), $4::timestamptz)` + bots + `
+	UNION ALL
+	SELECT code, ts, is_bot, ` + raw + `, 1 FROM click_events
+	 WHERE ts >= GREATEST($1::timestamptz, $4::timestamptz)` + bots + `
+)`
+}
+
+// clickRanges splits a stats range starting at since into the parts read
+// from each table.
+type clickRanges struct {
+	since     time.Time // hour-aligned start, exact with exactRanges
+	dayStart  time.Time // [dayStart, dayEnd) from click_rollups_daily
+	dayEnd    time.Time
+	watermark time.Time // rest of [since, watermark) from click_rollups_hourly, raw after
+}
+
+func newClickRanges(since, watermark time.Time) clickRanges {
+	since = since.UTC().Truncate(time.Hour)
+	watermark = watermark.UTC()
+	if watermark.Before(since) {
+		watermark = since // rollups end before the range: raw only
+	}
+
+	dayStart := since.Truncate(day)
+	if dayStart.Before(since) {
+		dayStart = dayStart.Add(day)
+	}
+	dayEnd := watermark.Truncate(day)
+	if !dayEnd.After(dayStart) {
+		dayStart, dayEnd = since, since
+	}
+
+	return clickRanges{since: since, dayStart: dayStart, dayEnd: dayEnd, watermark: watermark}
+}
+
+// exactRanges is newClickRanges starting at since exactly rather than at
+// its hour: whole hours still come from the rollups, the partial first hour
+// from click_events. For local days in timezones that are not a whole number
+// of hours from UTC.
+func exactRanges(since, watermark time.Time) clickRanges {
+	r := newClickRanges(since, watermark)
+	r.since = since.UTC()
+	return r
+}
+
+// rawRanges reads the whole range from click_events, from since exactly. For
+// results grouped by local hour, which rollup hours straddle in timezones
+// that are not a whole number of hours from UTC.
+func rawRanges(since time.Time) clickRanges {
+	since = since.UTC()
+	return clickRanges{since: since, dayStart: since, dayEnd: since, watermark: since}
+}
+
+// hourlyOnly reads whole days from the hourly rollup too, for day
+// boundaries other than UTC midnight or hour buckets.
+func (r clickRanges) hourlyOnly() clickRanges {
+	r.dayStart, r.dayEnd = r.since, r.since
+	return r
+}
+
+// until ends the range at to for callers that also filter bucket < to:
+// the day and hour containing to are read at finer granularity so they are
+// not counted whole.
+func (r clickRanges) until(to time.Time) clickRanges {
+	to = to.UTC()
+	if end := to.Truncate(day); end.Before(r.dayEnd) {
+		r.dayEnd = end
+		if !r.dayEnd.After(r.dayStart) {
+			r.dayStart, r.dayEnd = r.since, r.since
+		}
+	}
+	if end := to.Truncate(time.Hour); end.Before(r.watermark) {
+		r.watermark = end
+	}
+	return r
+}
+
+func (r clickRanges) args(extra ...any) []any {
+	return append([]any{r.since, r.dayStart, r.dayEnd, r.watermark}, extra...)
+}
+
+// rollupWatermark returns the time up to which rollups are complete,
+// or zero if they have never run.
+func (s *Store) rollupWatermark(ctx context.Context) (time.Time, error) {
+	var watermark time.Time
+	err := s.db.QueryRowContext(ctx,
+		`SELECT watermark FROM rollup_state WHERE name = $1`, rollupName).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return watermark, err
+}
+
+// markDirtyHours records closed hours touched by a batch so the next rollup
+// run recomputes them. Runs in the insert transaction.
+func markDirtyHours(ctx context.Context, tx *sql.Tx, events []ClickEvent) error {
+	current := time.Now().UTC().Truncate(time.Hour)
+	seen := make(map[time.Time]bool)
+	var hours []string
+	for _, e := range events {
+		h := e.Timestamp.UTC().Truncate(time.Hour)
+		if !h.Before(current) || seen[h] {
+			continue
+		}
+		seen[h] = true
+		hours = append(hours, h.Format(time.RFC3339))
+	}
+	if len(hours) == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO click_rollup_dirty (bucket) SELECT unnest($1::timestamptz[])
+		 ON CONFLICT DO NOTHING`, pq.Array(hours))
+	return err
+}
+
+// RollupClicks rolls up closed hours since the watermark (at most maxHours
+// of them) plus hours marked dirty by late clicks, then advances the
+// watermark. Returns the number of hours rolled up; 0 if another instance
+// holds the rollup lock.
+func (s *Store) RollupClicks(ctx context.Context, maxHours int) (int, error) {
+	defer observeDB("rollup_clicks", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, rollupLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	var watermark time.Time
+	err = tx.QueryRowContext(ctx,
+		`SELECT watermark FROM rollup_state WHERE name = $1`, rollupName).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		// First run: start from the oldest click
+		var oldest sql.NullTime
+		if err := tx.QueryRowContext(ctx, `SELECT MIN(ts) FROM click_events`).Scan(&oldest); err != nil {
+			return 0, err
+		}
+		watermark = time.Now()
+		if oldest.Valid {
+			watermark = oldest.Time
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	watermark = watermark.UTC().Truncate(time.Hour)
+
+	end := time.Now().UTC().Truncate(time.Hour)
+	if limit := watermark.Add(time.Duration(maxHours) * time.Hour); maxHours > 0 && limit.Before(end) {
+		end = limit
+	}
+
+	// Late clicks for hours already rolled up
+	rows, err := tx.QueryContext(ctx,
+		`DELETE FROM click_rollup_dirty WHERE bucket < $1 RETURNING bucket`, watermark)
+	if err != nil {
+		return 0, err
+	}
+	var dirty []time.Time
+	for rows.Next() {
+		var h time.Time
+		if err := rows.Scan(&h); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		dirty = append(dirty, h.UTC())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	hours := 0
+	days := make(map[time.Time]bool)
+	for _, h := range dirty {
+		days[h.Truncate(day)] = true
+		hours++
+	}
+	if end.After(watermark) {
+		for d := watermark.Truncate(day); d.Before(end); d = d.Add(day) {
+			days[d] = true
+		}
+		hours += int(end.Sub(watermark) / time.Hour)
+	}
+	for _, f := range rollupFamilies {
+		for _, h := range dirty {
+			if err := f.rollupHours(ctx, tx, h, h.Add(time.Hour)); err != nil {
+				return 0, err
+			}
+		}
+		if end.After(watermark) {
+			if err := f.rollupHours(ctx, tx, watermark, end); err != nil {
+				return 0, err
+			}
+		}
+		for d := range days {
+			if err := f.rollupDay(ctx, tx, d); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO rollup_state (name, watermark) VALUES ($1, $2)
+		 ON CONFLICT (name) DO UPDATE SET watermark = EXCLUDED.watermark`,
+		rollupName, maxTime(end, watermark)); err != nil {
+		return 0, err
+	}
+
+	return hours, tx.Commit()
+}
+
+// rollupHours recomputes the hourly rollup for [from, to) from click_events.
+func (f rollupFamily) rollupHours(ctx context.Context, tx *sql.Tx, from, to time.Time) error {
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM `+f.hourly+` WHERE bucket >= $1 AND bucket < $2`, from, to); err != nil {
+		return err
+	}
+	dims, raw := strings.Join(f.dims, ", "), f.rawDims()
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO `+f.hourly+` (code, bucket, is_bot, `+dims+`, clicks)
+		 SELECT code, date_trunc('hour', ts, 'UTC') AS bucket, is_bot, `+raw+`, COUNT(*)
+		 FROM click_events WHERE ts >= $1 AND ts < $2
+		 GROUP BY code, bucket, is_bot, `+raw, from, to)
+	return err
+}
+
+// rollupDay recomputes the daily rollup for the UTC day starting at d.
+func (f rollupFamily) rollupDay(ctx context.Context, tx *sql.Tx, d time.Time) error {
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM `+f.daily+` WHERE bucket = $1`, d); err != nil {
+		return err
+	}
+	dims := strings.Join(f.dims, ", ")
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO `+f.daily+` (code, bucket, is_bot, `+dims+`, clicks)
+		 SELECT code, $1::timestamptz, is_bot, `+dims+`, SUM(clicks)
+		 FROM `+f.hourly+` WHERE bucket >= $1::timestamptz AND bucket < $2::timestamptz
+		 GROUP BY code, is_bot, `+dims, d, d.Add(day))
+	return err
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func ts(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestNewClickRanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		since     time.Time
+		watermark time.Time
+		want      clickRanges
+	}{
+		{
+			name:      "never rolled up",
+			since:     ts("2025-03-01T10:30:00Z"),
+			watermark: time.Time{},
+			want: clickRanges{
+				since:     ts("2025-03-01T10:00:00Z"),
+				dayStart:  ts("2025-03-01T10:00:00Z"),
+				dayEnd:    ts("2025-03-01T10:00:00Z"),
+				watermark: ts("2025-03-01T10:00:00Z"),
+			},
+		},
+		{
+			name:      "whole days in the middle",
+			since:     ts("2025-03-01T10:30:00Z"),
+			watermark: ts("2025-03-05T07:00:00Z"),
+			want: clickRanges{
+				since:     ts("2025-03-01T10:00:00Z"),
+				dayStart:  ts("2025-03-02T00:00:00Z"),
+				dayEnd:    ts("2025-03-05T00:00:00Z"),
+				watermark: ts("2025-03-05T07:00:00Z"),
+			},
+		},
+		{
+			name:      "no whole day",
+			since:     ts("2025-03-01T10:30:00Z"),
+			watermark: ts("2025-03-01T20:00:00Z"),
+			want: clickRanges{
+				since:     ts("2025-03-01T10:00:00Z"),
+				dayStart:  ts("2025-03-01T10:00:00Z"),
+				dayEnd:    ts("2025-03-01T10:00:00Z"),
+				watermark: ts("2025-03-01T20:00:00Z"),
+			},
+		},
+		{
+			name:      "all time",
+			since:     time.Time{},
+			watermark: ts("2025-03-05T07:00:00Z"),
+			want: clickRanges{
+				since:     time.Time{},
+				dayStart:  time.Time{},
+				dayEnd:    ts("2025-03-05T00:00:00Z"),
+				watermark: ts("2025-03-05T07:00:00Z"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newClickRanges(tt.since, tt.watermark)
+			if !got.since.Equal(tt.want.since) || !got.dayStart.Equal(tt.want.dayStart) ||
+				!got.dayEnd.Equal(tt.want.dayEnd) || !got.watermark.Equal(tt.want.watermark) {
+				t.Errorf("newClickRanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClickRangesUntil(t *testing.T) {
+	r := newClickRanges(ts("2025-03-01T10:30:00Z"), ts("2025-03-05T07:00:00Z"))
+
+	got := r.until(ts("2025-03-03T12:30:00Z"))
+	if !got.dayStart.Equal(ts("2025-03-02T00:00:00Z")) || !got.dayEnd.Equal(ts("2025-03-03T00:00:00Z")) {
+		t.Errorf("daily range = [%v, %v), want [03-02, 03-03)", got.dayStart, got.dayEnd)
+	}
+	if !got.watermark.Equal(ts("2025-03-03T12:00:00Z")) {
+		t.Errorf("watermark = %v, want 12:00 so the partial hour is read raw", got.watermark)
+	}
+
+	got = r.until(ts("2025-03-01T23:00:00Z"))
+	if !got.dayStart.Equal(got.since) || !got.dayEnd.Equal(got.since) {
+		t.Errorf("daily range = [%v, %v), want empty", got.dayStart, got.dayEnd)
+	}
+
+	if got := r.until(ts("2025-03-08T00:00:00Z")); got != r {
+		t.Errorf("until() after the watermark = %+v, want unchanged", got)
+	}
+}
+
+func TestRollupFamilySource(t *testing.T) {
+	src := geoRollup.source(StatsOptions{})
+	for _, want := range []string{
+		"SELECT code, bucket, is_bot, country, region, city, clicks FROM click_geo_rollups_daily",
+		"SELECT code, bucket, is_bot, country, region, city, clicks FROM click_geo_rollups_hourly",
+		"SELECT code, ts, is_bot, COALESCE(country, ''), COALESCE(region, ''), COALESCE(city, ''), 1 FROM click_events",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("source() missing %q:\n%s", want, src)
+		}
+	}
+	// The partial first hour of an exact start comes from click_events
+	if !strings.Contains(src, "ts < LEAST(to_timestamp(ceil(extract(epoch FROM $1::timestamptz) / 3600) * 3600), $4::timestamptz)") {
+		t.Errorf("source() does not read the first partial hour:\n%s", src)
+	}
+	if n := strings.Count(src, "AND NOT is_bot"); n != 4 {
+		t.Errorf("source() filters bots in %d of 4 branches", n)
+	}
+	if strings.Contains(geoRollup.source(StatsOptions{IncludeBots: true}), "NOT is_bot") {
+		t.Error("source() filters bots with IncludeBots")
+	}
+}
+
+func TestDayRanges(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	wm := time.Now().UTC().Truncate(time.Hour)
+
+	r := dayRanges(7, time.Now(), wm, StatsOptions{Location: shanghai})
+	local := r.since.In(shanghai)
+	if local.Hour() != 0 || local.Minute() != 0 {
+		t.Errorf("since = %v, want local midnight", local)
+	}
+	if days := time.Now().In(shanghai).Sub(local); days <= 6*day || days > 7*day {
+		t.Errorf("since is %v ago, want within the 7th day", days)
+	}
+	if !r.dayStart.Equal(r.dayEnd) {
+		t.Errorf("non-UTC days read daily rollups [%v, %v)", r.dayStart, r.dayEnd)
+	}
+
+	r = dayRanges(7, time.Now(), wm, StatsOptions{})
+	if !r.since.Equal(r.since.Truncate(day)) {
+		t.Errorf("UTC since = %v, want midnight", r.since)
+	}
+	if !r.dayStart.Equal(r.since) || !r.dayEnd.After(r.dayStart) {
+		t.Errorf("UTC days skip daily rollups [%v, %v)", r.dayStart, r.dayEnd)
+	}
+}
+
+func TestStatsRanges(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	now := ts("2025-03-10T20:00:00Z") // 04:00 on March 11th in Shanghai
+	wm := ts("2025-03-10T19:00:00Z")
+
+	tests := []struct {
+		name         string
+		loc          *time.Location
+		wantSince    string
+		wantDayStart string // whole UTC days still come from daily rollups
+	}{
+		{"utc", nil, "2025-03-04T00:00:00Z", "2025-03-04T00:00:00Z"},
+		{"local days", shanghai, "2025-03-04T16:00:00Z", "2025-03-05T00:00:00Z"}, // March 5th, 00:00 in Shanghai
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := statsRanges(7, now, wm, StatsOptions{Location: tt.loc})
+			if !r.since.Equal(ts(tt.wantSince)) {
+				t.Errorf("since = %v, want %s", r.since, tt.wantSince)
+			}
+			if !r.dayStart.Equal(ts(tt.wantDayStart)) || !r.dayEnd.Equal(ts("2025-03-10T00:00:00Z")) {
+				t.Errorf("daily range = [%v, %v), want [%s, 2025-03-10)", r.dayStart, r.dayEnd, tt.wantDayStart)
+			}
+		})
+	}
+}
+
+func TestStatsRangesHalfHourOffset(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	now := ts("2025-03-10T20:00:00Z") // 01:30 on March 11th in Kolkata
+	wm := ts("2025-03-10T19:00:00Z")
+	opts := StatsOptions{Location: kolkata}
+
+	for name, r := range map[string]clickRanges{
+		"stats": statsRanges(7, now, wm, opts),
+		"days":  dayRanges(7, now, wm, opts),
+	} {
+		// March 5th, 00:00 in Kolkata, not rounded to the rollup hour
+		if !r.since.Equal(ts("2025-03-04T18:30:00Z")) {
+			t.Errorf("%s: since = %v, want 18:30 UTC", name, r.since)
+		}
+		// Later hours still come from rollups, not from click_events
+		if !r.watermark.Equal(wm) {
+			t.Errorf("%s: watermark = %v, want %v", name, r.watermark, wm)
+		}
+	}
+	if r := statsRanges(7, now, wm, opts); !r.dayStart.Equal(ts("2025-03-05T00:00:00Z")) || !r.dayEnd.Equal(ts("2025-03-10T00:00:00Z")) {
+		t.Errorf("daily range = [%v, %v), want whole UTC days from 2025-03-05", r.dayStart, r.dayEnd)
+	}
+}
@@ -0,0 +1,4 @@
+-- 005_redirect_type.sql
+-- Per-link redirect status code (301/302/307/308), 0 = use REDIRECT_STATUS_CODE
+
+ALTER TABLE links ADD COLUMN IF NOT EXISTS redirect_type SMALLINT NOT NULL DEFAULT 0;
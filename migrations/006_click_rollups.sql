@@ -0,0 +1,40 @@
+-- 006_click_rollups.sql
+-- Pre-aggregated click counts for stats queries. Rollups are complete for
+-- ts < rollup_state.watermark; newer clicks are read from click_events.
+
+-- Clicks per UTC hour by code and device/browser/OS
+CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+    code        TEXT NOT NULL,
+    bucket      TIMESTAMPTZ NOT NULL,
+    device_type TEXT NOT NULL DEFAULT '',
+    browser     TEXT NOT NULL DEFAULT '',
+    os          TEXT NOT NULL DEFAULT '',
+    clicks      BIGINT NOT NULL,
+    PRIMARY KEY (code, bucket, device_type, browser, os)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rollups_hourly_bucket ON click_rollups_hourly (bucket);
+
+-- Clicks per UTC day, summed from the hourly rollup
+CREATE TABLE IF NOT EXISTS click_rollups_daily (
+    code        TEXT NOT NULL,
+    bucket      TIMESTAMPTZ NOT NULL,
+    device_type TEXT NOT NULL DEFAULT '',
+    browser     TEXT NOT NULL DEFAULT '',
+    os          TEXT NOT NULL DEFAULT '',
+    clicks      BIGINT NOT NULL,
+    PRIMARY KEY (code, bucket, device_type, browser, os)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rollups_daily_bucket ON click_rollups_daily (bucket);
+
+-- Rollup progress
+CREATE TABLE IF NOT EXISTS rollup_state (
+    name      TEXT PRIMARY KEY,
+    watermark TIMESTAMPTZ NOT NULL
+);
+
+-- Closed hours that received late clicks and must be rolled up again
+CREATE TABLE IF NOT EXISTS click_rollup_dirty (
+    bucket TIMESTAMPTZ PRIMARY KEY
+);
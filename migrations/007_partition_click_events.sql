@@ -0,0 +1,47 @@
+-- 007_partition_click_events.sql
+-- Monthly range partitions for click_events (click_events_yYYYYmMM, UTC
+-- months). The app creates partitions ahead of time and drops or detaches
+-- old ones (CLICK_RETENTION_MONTHS). Existing rows are moved into partitions.
+
+ALTER TABLE click_events RENAME TO click_events_unpartitioned;
+ALTER INDEX IF EXISTS idx_clicks_code_ts RENAME TO idx_clicks_unpartitioned_code_ts;
+ALTER INDEX IF EXISTS idx_clicks_ts RENAME TO idx_clicks_unpartitioned_ts;
+
+CREATE TABLE click_events (
+    id          BIGINT NOT NULL DEFAULT nextval('click_events_id_seq'),
+    code        TEXT NOT NULL,
+    ts          TIMESTAMPTZ NOT NULL,
+    ip          TEXT,
+    ua          TEXT,
+    referer     TEXT,
+    device_type TEXT,
+    browser     TEXT,
+    os          TEXT,
+    PRIMARY KEY (id, ts)
+) PARTITION BY RANGE (ts);
+
+-- Keep the id sequence when the old table is dropped
+ALTER SEQUENCE click_events_id_seq OWNED BY click_events.id;
+
+CREATE INDEX IF NOT EXISTS idx_clicks_code_ts ON click_events (code, ts);
+CREATE INDEX IF NOT EXISTS idx_clicks_ts ON click_events (ts);
+
+-- Partitions from the oldest existing click through two months ahead
+DO $$
+DECLARE
+    m    TIMESTAMP := date_trunc('month', COALESCE((SELECT MIN(ts) FROM click_events_unpartitioned), NOW()) AT TIME ZONE 'UTC');
+    last TIMESTAMP := date_trunc('month', NOW() AT TIME ZONE 'UTC') + INTERVAL '2 months';
+BEGIN
+    WHILE m <= last LOOP
+        EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF click_events FOR VALUES FROM (%L) TO (%L)',
+            'click_events_' || to_char(m, '"y"YYYY"m"MM'),
+            m AT TIME ZONE 'UTC',
+            (m + INTERVAL '1 month') AT TIME ZONE 'UTC');
+        m := m + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO click_events (id, code, ts, ip, ua, referer, device_type, browser, os)
+SELECT id, code, ts, ip, ua, referer, device_type, browser, os FROM click_events_unpartitioned;
+
+DROP TABLE click_events_unpartitioned;
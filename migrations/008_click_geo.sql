@@ -0,0 +1,41 @@
+-- 008_click_geo.sql
+-- GeoIP location of clicks, resolved by the click consumer.
+
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS country TEXT;
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS region TEXT;
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS city TEXT;
+
+-- Clicks per UTC hour by code and country/region/city
+CREATE TABLE IF NOT EXISTS click_geo_rollups_hourly (
+    code    TEXT NOT NULL,
+    bucket  TIMESTAMPTZ NOT NULL,
+    country TEXT NOT NULL DEFAULT '',
+    region  TEXT NOT NULL DEFAULT '',
+    city    TEXT NOT NULL DEFAULT '',
+    clicks  BIGINT NOT NULL,
+    PRIMARY KEY (code, bucket, country, region, city)
+);
+
+CREATE INDEX IF NOT EXISTS idx_geo_rollups_hourly_bucket ON click_geo_rollups_hourly (bucket);
+
+-- Clicks per UTC day, summed from the hourly geo rollup
+CREATE TABLE IF NOT EXISTS click_geo_rollups_daily (
+    code    TEXT NOT NULL,
+    bucket  TIMESTAMPTZ NOT NULL,
+    country TEXT NOT NULL DEFAULT '',
+    region  TEXT NOT NULL DEFAULT '',
+    city    TEXT NOT NULL DEFAULT '',
+    clicks  BIGINT NOT NULL,
+    PRIMARY KEY (code, bucket, country, region, city)
+);
+
+CREATE INDEX IF NOT EXISTS idx_geo_rollups_daily_bucket ON click_geo_rollups_daily (bucket);
+
+-- Clicks rolled up before this migration have no location
+INSERT INTO click_geo_rollups_hourly (code, bucket, clicks)
+SELECT code, bucket, SUM(clicks) FROM click_rollups_hourly GROUP BY code, bucket
+ON CONFLICT DO NOTHING;
+
+INSERT INTO click_geo_rollups_daily (code, bucket, clicks)
+SELECT code, bucket, SUM(clicks) FROM click_rollups_daily GROUP BY code, bucket
+ON CONFLICT DO NOTHING;
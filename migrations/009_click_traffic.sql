@@ -0,0 +1,64 @@
+-- 009_click_traffic.sql
+-- Referrer domain and UTM parameters of clicks, for traffic source stats.
+
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS referer_host TEXT;
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS utm_source TEXT;
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS utm_medium TEXT;
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS utm_campaign TEXT;
+
+-- Referrer domain of existing clicks (lowercase host without www.)
+UPDATE click_events
+SET referer_host = regexp_replace(
+        lower(substring(referer from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^/?#@]*@)?([^/?#:]+)')),
+        '^www\.', '')
+WHERE referer_host IS NULL AND referer IS NOT NULL AND referer <> '';
+
+-- Clicks per UTC hour by code and referrer domain/UTM parameters
+CREATE TABLE IF NOT EXISTS click_traffic_rollups_hourly (
+    code         TEXT NOT NULL,
+    bucket       TIMESTAMPTZ NOT NULL,
+    referer_host TEXT NOT NULL DEFAULT '',
+    utm_source   TEXT NOT NULL DEFAULT '',
+    utm_medium   TEXT NOT NULL DEFAULT '',
+    utm_campaign TEXT NOT NULL DEFAULT '',
+    clicks       BIGINT NOT NULL,
+    PRIMARY KEY (code, bucket, referer_host, utm_source, utm_medium, utm_campaign)
+);
+
+CREATE INDEX IF NOT EXISTS idx_traffic_rollups_hourly_bucket ON click_traffic_rollups_hourly (bucket);
+
+-- Clicks per UTC day, summed from the hourly traffic rollup
+CREATE TABLE IF NOT EXISTS click_traffic_rollups_daily (
+    code         TEXT NOT NULL,
+    bucket       TIMESTAMPTZ NOT NULL,
+    referer_host TEXT NOT NULL DEFAULT '',
+    utm_source   TEXT NOT NULL DEFAULT '',
+    utm_medium   TEXT NOT NULL DEFAULT '',
+    utm_campaign TEXT NOT NULL DEFAULT '',
+    clicks       BIGINT NOT NULL,
+    PRIMARY KEY (code, bucket, referer_host, utm_source, utm_medium, utm_campaign)
+);
+
+CREATE INDEX IF NOT EXISTS idx_traffic_rollups_daily_bucket ON click_traffic_rollups_daily (bucket);
+
+-- Backfill rolled-up hours from the clicks still stored
+INSERT INTO click_traffic_rollups_hourly (code, bucket, referer_host, clicks)
+SELECT code, date_trunc('hour', ts, 'UTC'), COALESCE(referer_host, ''), COUNT(*)
+FROM click_events
+WHERE ts < (SELECT watermark FROM rollup_state WHERE name = 'clicks')
+GROUP BY 1, 2, 3
+ON CONFLICT DO NOTHING;
+
+-- Hours whose clicks were already dropped by retention have no referrer
+INSERT INTO click_traffic_rollups_hourly (code, bucket, clicks)
+SELECT code, bucket, SUM(clicks) FROM click_rollups_hourly h
+WHERE NOT EXISTS (
+    SELECT 1 FROM click_traffic_rollups_hourly t WHERE t.code = h.code AND t.bucket = h.bucket)
+GROUP BY code, bucket
+ON CONFLICT DO NOTHING;
+
+INSERT INTO click_traffic_rollups_daily (code, bucket, referer_host, clicks)
+SELECT code, date_trunc('day', bucket, 'UTC'), referer_host, SUM(clicks)
+FROM click_traffic_rollups_hourly
+GROUP BY 1, 2, 3
+ON CONFLICT DO NOTHING;
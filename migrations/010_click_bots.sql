@@ -0,0 +1,30 @@
+-- 010_click_bots.sql
+-- Bot classification of clicks. Stats count humans unless include_bots is set.
+-- Clicks recorded before this migration are treated as human.
+
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Every rollup is keyed by is_bot
+ALTER TABLE click_rollups_hourly ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_rollups_hourly DROP CONSTRAINT IF EXISTS click_rollups_hourly_pkey,
+    ADD PRIMARY KEY (code, bucket, is_bot, device_type, browser, os);
+
+ALTER TABLE click_rollups_daily ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_rollups_daily DROP CONSTRAINT IF EXISTS click_rollups_daily_pkey,
+    ADD PRIMARY KEY (code, bucket, is_bot, device_type, browser, os);
+
+ALTER TABLE click_geo_rollups_hourly ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_geo_rollups_hourly DROP CONSTRAINT IF EXISTS click_geo_rollups_hourly_pkey,
+    ADD PRIMARY KEY (code, bucket, is_bot, country, region, city);
+
+ALTER TABLE click_geo_rollups_daily ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_geo_rollups_daily DROP CONSTRAINT IF EXISTS click_geo_rollups_daily_pkey,
+    ADD PRIMARY KEY (code, bucket, is_bot, country, region, city);
+
+ALTER TABLE click_traffic_rollups_hourly ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_traffic_rollups_hourly DROP CONSTRAINT IF EXISTS click_traffic_rollups_hourly_pkey,
+    ADD PRIMARY KEY (code, bucket, is_bot, referer_host, utm_source, utm_medium, utm_campaign);
+
+ALTER TABLE click_traffic_rollups_daily ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_traffic_rollups_daily DROP CONSTRAINT IF EXISTS click_traffic_rollups_daily_pkey,
+    ADD PRIMARY KEY (code, bucket, is_bot, referer_host, utm_source, utm_medium, utm_campaign);
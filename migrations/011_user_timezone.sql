@@ -0,0 +1,4 @@
+-- 011_user_timezone.sql
+-- Per-user default timezone (IANA name) for stats. NULL uses STATS_TIMEZONE.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT;
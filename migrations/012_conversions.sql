@@ -0,0 +1,18 @@
+-- 012_conversions.sql
+-- Click IDs appended to long URLs (CLICK_ID_PARAM) and the conversions
+-- reported against them by postback or pixel.
+
+ALTER TABLE click_events ADD COLUMN IF NOT EXISTS click_id TEXT;
+
+-- One row per click and transaction; repeated reports are ignored
+CREATE TABLE IF NOT EXISTS conversions (
+    id       BIGSERIAL PRIMARY KEY,
+    click_id TEXT NOT NULL,
+    code     TEXT NOT NULL,
+    txn_id   TEXT NOT NULL DEFAULT '',
+    value    NUMERIC(14, 2) NOT NULL DEFAULT 0,
+    ts       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (click_id, txn_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversions_code_ts ON conversions (code, ts);
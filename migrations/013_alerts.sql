@@ -0,0 +1,36 @@
+-- 013_alerts.sql
+-- Click alert rules and the log of webhook deliveries they trigger.
+
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id             SERIAL PRIMARY KEY,
+    user_id        INT REFERENCES users(id) ON DELETE CASCADE, -- NULL = admin rule
+    code           TEXT REFERENCES links(code) ON DELETE CASCADE, -- NULL = all links of the account
+    kind           TEXT NOT NULL, -- clicks, spike
+    threshold      BIGINT NOT NULL,
+    window_minutes INT NOT NULL DEFAULT 0, -- spike only
+    factor         DOUBLE PRECISION NOT NULL DEFAULT 0, -- spike only
+    url            TEXT NOT NULL,
+    secret         TEXT NOT NULL,
+    disabled       BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_fired_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_user ON alert_rules (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    rule_id         INT NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    event           TEXT NOT NULL,
+    payload         JSONB NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending', -- pending, delivered, failed
+    attempts        INT NOT NULL DEFAULT 0,
+    response_status INT,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_rule ON webhook_deliveries (rule_id, id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';